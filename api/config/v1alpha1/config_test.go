@@ -0,0 +1,107 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(content string) string {
+		path := filepath.Join(dir, "config.yaml")
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return path
+	}
+
+	c := Default()
+	err = Load(write(`
+apiVersion: config.www.x-cellent.com/v1alpha1
+kind: ControllerManagerConfiguration
+metalAPI:
+  url: http://metal-api:8080/metal
+  maxRetries: 0
+namespaces: [a, b]
+`), c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.MetalAPI.URL != "http://metal-api:8080/metal" || c.MetalAPI.MaxRetries != 0 || len(c.Namespaces) != 2 {
+		t.Errorf("configuration not loaded: %+v", c)
+	}
+	if c.MetalAPI.Timeout.Duration != 10*time.Second || c.Webhook.Port != 9443 {
+		t.Errorf("defaults not kept: %+v", c)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for name, content := range map[string]string{
+		"unversioned":   "metrics:\n  bindAddress: :8000\n",
+		"unknown kind":  "apiVersion: config.www.x-cellent.com/v1alpha1\nkind: Manager\n",
+		"unknown field": "apiVersion: config.www.x-cellent.com/v1alpha1\nkind: ControllerManagerConfiguration\nmetric: {}\n",
+	} {
+		if err := Load(write(content), Default()); err == nil {
+			t.Errorf("%s: configuration loaded", name)
+		}
+	}
+}
+
+func TestLoadSample(t *testing.T) {
+	c := Default()
+	if err := Load(filepath.Join("..", "..", "..", "config", "manager", "controller_manager_config.yaml"), c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The URL is left to $METALCTL_URL of configmap.yaml.
+	if c.MetalAPI.URL != "" {
+		t.Errorf("sample sets the metal-api url %q", c.MetalAPI.URL)
+	}
+	c.MetalAPI.URL = "http://api.0.0.0.0.xip.io:8080/metal"
+	if err := c.Validate(); err != nil {
+		t.Errorf("sample invalid: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.MetalAPI.URL = "metal-api:8080"
+	c.Logging.Level = "verbose"
+	c.MetalAPI.MinVersion = "latest"
+	c.Namespaces = []string{"Default"}
+	c.MaxConcurrentReconciles.XCluster = -1
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("invalid configuration validated")
+	}
+	for _, field := range []string{"metalAPI.url", "metalAPI.minVersion", "logging.level", "namespaces[0]", "maxConcurrentReconciles.xcluster"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("%s not reported in %v", field, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"time"
+
+	"github.com/LimKianAn/xcluster/metal"
+)
+
+// Default returns the configuration used where neither the configuration file
+// nor a flag tells otherwise.
+func Default() *ControllerManagerConfiguration {
+	opts := metal.DefaultResilienceOptions()
+	return &ControllerManagerConfiguration{
+		TypeMeta: TypeMeta(),
+		Metrics:  MetricsConfiguration{BindAddress: ":8000"},
+		Health:   HealthConfiguration{BindAddress: ":8081"},
+		Webhook:  WebhookConfiguration{Port: 9443},
+		LeaderElection: LeaderElectionConfiguration{
+			ResourceName: "8af6cf17.www.x-cellent.com",
+		},
+		Logging: LoggingConfiguration{Format: "console", Level: "debug"},
+		MetalAPI: MetalAPIConfiguration{
+			MinVersion:       metal.MinVersion,
+			Timeout:          duration(opts.Timeout),
+			MaxRetries:       opts.MaxRetries,
+			Backoff:          duration(opts.Backoff),
+			FailureThreshold: opts.FailureThreshold,
+			Cooldown:         duration(opts.Cooldown),
+		},
+		Tracing:      TracingConfiguration{ServiceName: "xcluster-controller-manager"},
+		ResyncPeriod: duration(5 * time.Minute),
+	}
+}
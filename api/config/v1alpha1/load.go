@@ -0,0 +1,48 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// TypeMeta returns the apiVersion and kind of the configuration file.
+func TypeMeta() metav1.TypeMeta {
+	return metav1.TypeMeta{APIVersion: GroupVersion.String(), Kind: Kind}
+}
+
+// Load reads the configuration file at path into c. Fields missing in the
+// file keep their value in c, e.g. the defaults. Unknown fields are rejected,
+// so that typos don't go unnoticed.
+func Load(path string, c *ControllerManagerConfiguration) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the configuration file: %w", err)
+	}
+	c.TypeMeta = metav1.TypeMeta{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return fmt.Errorf("failed to decode the configuration file %s: %w", path, err)
+	}
+	if c.TypeMeta != TypeMeta() {
+		return fmt.Errorf("configuration file %s is of %s %s instead of %s %s", path, c.APIVersion, c.Kind, GroupVersion, Kind)
+	}
+	return nil
+}
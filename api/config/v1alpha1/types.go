@@ -0,0 +1,185 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v1alpha1 contains the configuration file of the controller manager.
+package v1alpha1
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	// GroupVersion is the group version of the configuration file.
+	GroupVersion = schema.GroupVersion{Group: "config.www.x-cellent.com", Version: "v1alpha1"}
+)
+
+// Kind is the kind of the configuration file.
+const Kind = "ControllerManagerConfiguration"
+
+// ControllerManagerConfiguration configures the controller manager. Flags
+// override what is set here.
+type ControllerManagerConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// Metrics configures the metrics endpoint.
+	Metrics MetricsConfiguration `json:"metrics,omitempty"`
+
+	// Health configures the health probes.
+	Health HealthConfiguration `json:"health,omitempty"`
+
+	// Webhook configures the webhook server.
+	Webhook WebhookConfiguration `json:"webhook,omitempty"`
+
+	// LeaderElection configures the leader election among the replicas of
+	// the controller manager.
+	LeaderElection LeaderElectionConfiguration `json:"leaderElection,omitempty"`
+
+	// Namespaces are the namespaces watched. All are watched if empty.
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// Logging configures the logs.
+	Logging LoggingConfiguration `json:"logging,omitempty"`
+
+	// MetalAPI configures the calls to metal-api.
+	MetalAPI MetalAPIConfiguration `json:"metalAPI,omitempty"`
+
+	// Tracing configures the export of spans.
+	Tracing TracingConfiguration `json:"tracing,omitempty"`
+
+	// ResyncPeriod is how often ready resources are checked for drift of
+	// their metal-stack resources. Zero disables the periodic check.
+	ResyncPeriod metav1.Duration `json:"resyncPeriod,omitempty"`
+
+	// MaxConcurrentReconciles tells how many objects of each kind are
+	// reconciled at once.
+	MaxConcurrentReconciles ConcurrencyConfiguration `json:"maxConcurrentReconciles,omitempty"`
+}
+
+type MetricsConfiguration struct {
+	// BindAddress is the address the metrics endpoint binds to. "0"
+	// disables it.
+	BindAddress string `json:"bindAddress,omitempty"`
+}
+
+type HealthConfiguration struct {
+	// BindAddress is the address the health probes bind to. "0" disables
+	// them.
+	BindAddress string `json:"bindAddress,omitempty"`
+}
+
+type WebhookConfiguration struct {
+	// Port is the port the webhook server listens on.
+	Port int `json:"port,omitempty"`
+}
+
+type LeaderElectionConfiguration struct {
+	// LeaderElect makes only the elected replica reconcile.
+	LeaderElect bool `json:"leaderElect,omitempty"`
+
+	// ResourceName is the name of the lock.
+	ResourceName string `json:"resourceName,omitempty"`
+
+	// ResourceNamespace is the namespace of the lock. It defaults to the
+	// namespace the controller manager runs in.
+	ResourceNamespace string `json:"resourceNamespace,omitempty"`
+}
+
+type LoggingConfiguration struct {
+	// Format is either console or json.
+	Format string `json:"format,omitempty"`
+
+	// Level is one of debug, info or error.
+	Level string `json:"level,omitempty"`
+}
+
+type MetalAPIConfiguration struct {
+	// URL is the endpoint of metal-api. It defaults to $METALCTL_URL.
+	URL string `json:"url,omitempty"`
+
+	// HMACFile is the file holding the HMAC key of metal-api, e.g. mounted
+	// from a secret. The key is taken from $METALCTL_HMAC if empty.
+	HMACFile string `json:"hmacFile,omitempty"`
+
+	// MinVersion is the oldest version of metal-api the manager starts
+	// with.
+	MinVersion string `json:"minVersion,omitempty"`
+
+	// Timeout is the timeout of a single call.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+
+	// MaxRetries is how often idempotent calls are retried on transient
+	// errors.
+	MaxRetries int `json:"maxRetries,omitempty"`
+
+	// Backoff is the initial back-off between retries.
+	Backoff metav1.Duration `json:"backoff,omitempty"`
+
+	// FailureThreshold is the number of consecutive transient failures
+	// which opens the circuit breaker.
+	FailureThreshold int `json:"failureThreshold,omitempty"`
+
+	// Cooldown is how long the circuit breaker stays open.
+	Cooldown metav1.Duration `json:"cooldown,omitempty"`
+
+	// Cassette, if set, is the file every call is recorded in.
+	Cassette string `json:"cassette,omitempty"`
+
+	// DryRun makes the reconcilers leave metal-stack alone and record the
+	// calls which would mutate it instead.
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+type TracingConfiguration struct {
+	// Endpoint is the OTLP/HTTP endpoint of the OpenTelemetry collector.
+	// Tracing is disabled if empty.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// ServiceName is the service name reported with every span.
+	ServiceName string `json:"serviceName,omitempty"`
+}
+
+// ConcurrencyConfiguration tells how many objects of each kind are reconciled
+// at once. Zero means one.
+type ConcurrencyConfiguration struct {
+	XCluster        int `json:"xcluster,omitempty"`
+	XFirewall       int `json:"xfirewall,omitempty"`
+	XIPClaim        int `json:"xipclaim,omitempty"`
+	XMachine        int `json:"xmachine,omitempty"`
+	XNetwork        int `json:"xnetwork,omitempty"`
+	XNetworkPeering int `json:"xnetworkpeering,omitempty"`
+	XProject        int `json:"xproject,omitempty"`
+}
+
+// ByKind returns the fields of c by the lower-case kind they are of.
+func (c *ConcurrencyConfiguration) ByKind() map[string]*int {
+	return map[string]*int{
+		"xcluster":        &c.XCluster,
+		"xfirewall":       &c.XFirewall,
+		"xipclaim":        &c.XIPClaim,
+		"xmachine":        &c.XMachine,
+		"xnetwork":        &c.XNetwork,
+		"xnetworkpeering": &c.XNetworkPeering,
+		"xproject":        &c.XProject,
+	}
+}
+
+// duration returns d as metav1.Duration.
+func duration(d time.Duration) metav1.Duration {
+	return metav1.Duration{Duration: d}
+}
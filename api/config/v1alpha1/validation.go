@@ -0,0 +1,93 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"net/url"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// Validate tells everything wrong with c at once.
+func (c *ControllerManagerConfiguration) Validate() error {
+	var errs field.ErrorList
+
+	if p := c.Webhook.Port; p < 1 || p > 65535 {
+		errs = append(errs, field.Invalid(field.NewPath("webhook", "port"), p, "must be between 1 and 65535"))
+	}
+	if c.LeaderElection.LeaderElect && c.LeaderElection.ResourceName == "" {
+		errs = append(errs, field.Required(field.NewPath("leaderElection", "resourceName"), "is needed to elect a leader"))
+	}
+	for i, ns := range c.Namespaces {
+		for _, msg := range validation.IsDNS1123Label(ns) {
+			errs = append(errs, field.Invalid(field.NewPath("namespaces").Index(i), ns, msg))
+		}
+	}
+
+	logging := field.NewPath("logging")
+	if f := c.Logging.Format; f != "console" && f != "json" {
+		errs = append(errs, field.NotSupported(logging.Child("format"), f, []string{"console", "json"}))
+	}
+	if l := c.Logging.Level; l != "debug" && l != "info" && l != "error" {
+		errs = append(errs, field.NotSupported(logging.Child("level"), l, []string{"debug", "info", "error"}))
+	}
+
+	metalAPI := field.NewPath("metalAPI")
+	if c.MetalAPI.URL == "" {
+		errs = append(errs, field.Required(metalAPI.Child("url"), "set it here, by --metal-api-url or by $METALCTL_URL"))
+	} else if u, err := url.Parse(c.MetalAPI.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, field.Invalid(metalAPI.Child("url"), c.MetalAPI.URL, "must be an http or https URL"))
+	}
+	if _, err := version.ParseSemantic(c.MetalAPI.MinVersion); err != nil {
+		errs = append(errs, field.Invalid(metalAPI.Child("minVersion"), c.MetalAPI.MinVersion, "must be a semantic version"))
+	}
+	if c.MetalAPI.Timeout.Duration <= 0 {
+		errs = append(errs, field.Invalid(metalAPI.Child("timeout"), c.MetalAPI.Timeout.Duration.String(), "must be positive"))
+	}
+	if c.MetalAPI.MaxRetries < 0 {
+		errs = append(errs, field.Invalid(metalAPI.Child("maxRetries"), c.MetalAPI.MaxRetries, "must not be negative"))
+	}
+	if c.MetalAPI.Backoff.Duration <= 0 {
+		errs = append(errs, field.Invalid(metalAPI.Child("backoff"), c.MetalAPI.Backoff.Duration.String(), "must be positive"))
+	}
+	if c.MetalAPI.FailureThreshold < 1 {
+		errs = append(errs, field.Invalid(metalAPI.Child("failureThreshold"), c.MetalAPI.FailureThreshold, "must be at least 1"))
+	}
+	if c.MetalAPI.Cooldown.Duration <= 0 {
+		errs = append(errs, field.Invalid(metalAPI.Child("cooldown"), c.MetalAPI.Cooldown.Duration.String(), "must be positive"))
+	}
+
+	if c.ResyncPeriod.Duration < 0 {
+		errs = append(errs, field.Invalid(field.NewPath("resyncPeriod"), c.ResyncPeriod.Duration.String(), "must not be negative"))
+	}
+	byKind := c.MaxConcurrentReconciles.ByKind()
+	kinds := make([]string, 0, len(byKind))
+	for kind := range byKind {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		if n := *byKind[kind]; n < 0 {
+			errs = append(errs, field.Invalid(field.NewPath("maxConcurrentReconciles", kind), n, "must not be negative"))
+		}
+	}
+
+	return errs.ToAggregate()
+}
@@ -0,0 +1,114 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Phase summarizes where a resource is in its lifecycle.
+// +kubebuilder:validation:Enum=Pending;AllocatingNetwork;ProvisioningFirewall;ProvisioningMachine;AttachingNetwork;Ready;Drifted;Deleting;Failed
+type Phase string
+
+const (
+	// PhasePending means the resource has not been reconciled yet.
+	PhasePending Phase = "Pending"
+	// PhaseAllocatingNetwork means the private network is being allocated in metal-stack.
+	PhaseAllocatingNetwork Phase = "AllocatingNetwork"
+	// PhaseProvisioningFirewall means the metal-stack firewall is being created and is not ready yet.
+	PhaseProvisioningFirewall Phase = "ProvisioningFirewall"
+	// PhaseProvisioningMachine means the metal-stack machine is being allocated and installed.
+	PhaseProvisioningMachine Phase = "ProvisioningMachine"
+	// PhaseAttachingNetwork means a firewall is being attached to a network.
+	PhaseAttachingNetwork Phase = "AttachingNetwork"
+	// PhaseReady means all the metal-stack resources are ready.
+	PhaseReady Phase = "Ready"
+	// PhaseDrifted means a metal-stack resource vanished or changed out of band.
+	PhaseDrifted Phase = "Drifted"
+	// PhaseDeleting means the metal-stack resources are being cleaned up.
+	PhaseDeleting Phase = "Deleting"
+	// PhaseFailed means metal-api rejected a request permanently.
+	PhaseFailed Phase = "Failed"
+)
+
+// ConditionType is the type of a Condition.
+type ConditionType string
+
+const (
+	// MetalAPIAvailable tells whether metal-api could be reached during the last reconciliation.
+	MetalAPIAvailable ConditionType = "MetalAPIAvailable"
+	// Drifted tells whether the metal-stack resources no longer match what was recorded.
+	Drifted ConditionType = "Drifted"
+	// ControlPlaneEndpointReady tells whether the IP of the control plane endpoint is allocated in metal-stack.
+	ControlPlaneEndpointReady ConditionType = "ControlPlaneEndpointReady"
+	// NetworksValid tells whether the additional networks of a machine exist and may be attached to it.
+	NetworksValid ConditionType = "NetworksValid"
+	// NetworksAttached tells whether a machine is attached to the additional networks it is supposed to be.
+	NetworksAttached ConditionType = "NetworksAttached"
+	// PrivateNetworkValid tells whether the private network can be or has been allocated as specified.
+	PrivateNetworkValid ConditionType = "PrivateNetworkValid"
+	// ProjectResolved tells whether the metal-stack project could be taken from the namespace.
+	ProjectResolved ConditionType = "ProjectResolved"
+)
+
+// Condition describes one aspect of the observed state of a resource.
+type Condition struct {
+	Type   ConditionType          `json:"type"`
+	Status corev1.ConditionStatus `json:"status"`
+
+	// LastTransitionTime is the last time the status changed.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// Reason is a CamelCase summary of the last transition.
+	Reason string `json:"reason,omitempty"`
+
+	// Message is a human readable description of the last transition.
+	Message string `json:"message,omitempty"`
+}
+
+func getCondition(conditions []Condition, t ConditionType) *Condition {
+	for i := range conditions {
+		if conditions[i].Type == t {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// setCondition adds or replaces the condition of the same type. The transition
+// time is only bumped if the status changes. It returns whether anything changed.
+func setCondition(conditions *[]Condition, c Condition) bool {
+	existing := getCondition(*conditions, c.Type)
+	if existing == nil {
+		c.LastTransitionTime = metav1.Now()
+		*conditions = append(*conditions, c)
+		return true
+	}
+
+	if existing.Status == c.Status && existing.Reason == c.Reason && existing.Message == c.Message {
+		return false
+	}
+
+	if existing.Status != c.Status {
+		existing.LastTransitionTime = metav1.Now()
+	}
+	existing.Status = c.Status
+	existing.Reason = c.Reason
+	existing.Message = c.Message
+	return true
+}
@@ -0,0 +1,37 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+// NetworkAttachment attaches a machine to a metal-stack network on top of the
+// ones managed by xcluster, e.g. a shared storage network or the network of
+// another tenant.
+type NetworkAttachment struct {
+	// NetworkID is the ID of the metal-stack network.
+	NetworkID string `json:"networkID"`
+
+	// IPs of the network the machine gets. They must be allocated to the
+	// project of the cluster beforehand. If none are given, an IP is acquired
+	// automatically.
+	// +optional
+	IPs []string `json:"ips,omitempty"`
+}
+
+// NetworkStatus lists the IPs a machine got in a metal-stack network.
+type NetworkStatus struct {
+	NetworkID string   `json:"networkID"`
+	IPs       []string `json:"ips,omitempty"`
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -32,15 +33,128 @@ type XClusterSpec struct {
 	Partition string `json:"partition"`
 
 	// PrivateNetworkID is the network ID which connects all the machines together.
+	// It is taken from the XNetwork referenced by NetworkRef. Given without
+	// NetworkRef, the network is used as is.
 	PrivateNetworkID string `json:"privateNetworkID,omitempty"`
 
-	// ProjectID is for grouping all the project-related resources.
-	ProjectID string `json:"projectID"`
+	// NetworkRef names the XNetwork in the same namespace providing the
+	// private network. Unless it or PrivateNetworkID is given, an XNetwork
+	// owned by the XCluster is created and referenced.
+	// +optional
+	NetworkRef *corev1.LocalObjectReference `json:"networkRef,omitempty"`
+
+	// PrivateNetwork describes the private network of the XNetwork created
+	// for the XCluster.
+	// +optional
+	PrivateNetwork PrivateNetworkSpec `json:"privateNetwork,omitempty"`
+
+	// ProjectID is for grouping all the project-related resources. Defaults
+	// to the project of the namespace, see XProject.
+	// +optional
+	ProjectID string `json:"projectID,omitempty"`
 
 	// XFirewallTemplate is the template of the XFirewall.
 	XFirewallTemplate XFirewallTemplate `json:"xFirewallTemplate,omitempty"`
+
+	// DriftPolicy tells what to do if the private network vanishes or changes
+	// out of band, e.g. by metalctl. It is handed down to the XFirewall.
+	// Defaults to Report.
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+
+	// ControlPlaneEndpoint is the endpoint of the API server of the workload
+	// cluster. Cluster API copies it to the owning Cluster.
+	// +optional
+	ControlPlaneEndpoint APIEndpoint `json:"controlPlaneEndpoint"`
+
+	// ControlPlaneNetworkID is the external metal-stack network the IP of the
+	// ControlPlaneEndpoint comes from. If the host of the endpoint is empty, a
+	// static IP is allocated. Otherwise the host is allocated if it is free or
+	// validated if it is not. The endpoint is left alone if empty.
+	// +optional
+	ControlPlaneNetworkID string `json:"controlPlaneNetworkID,omitempty"`
+}
+
+// APIEndpoint is the endpoint of an API server as defined by Cluster API.
+type APIEndpoint struct {
+	// Host is the hostname or IP on which the API server is serving.
+	Host string `json:"host"`
+
+	// Port is the port on which the API server is serving.
+	Port int32 `json:"port"`
+}
+
+// IsZero reports whether the endpoint has not been set.
+func (e APIEndpoint) IsZero() bool {
+	return e.Host == "" && e.Port == 0
 }
 
+// PrivateNetworkSpec describes the private network of an XCluster.
+type PrivateNetworkSpec struct {
+	// PrefixLength of the network. metal-api can't be asked for a prefix
+	// length, it allocates the one configured for the partition. Given
+	// anyway, the network is only allocated if the partition hands out this
+	// one.
+	// +kubebuilder:validation:Minimum=8
+	// +kubebuilder:validation:Maximum=32
+	// +optional
+	PrefixLength *int32 `json:"prefixLength,omitempty"`
+
+	// AddressFamily of the network. metal-api allocates IPv4 networks only.
+	// Defaults to IPv4.
+	// +optional
+	AddressFamily AddressFamily `json:"addressFamily,omitempty"`
+
+	// Shared networks may be attached to machines of other projects.
+	// +optional
+	Shared bool `json:"shared,omitempty"`
+
+	// NAT tells whether traffic leaving the network is masqueraded. metal-api
+	// can't be asked for it, it decides on its own. Given anyway, a network
+	// with another NAT is reported as mismatch, e.g. one adopted by an
+	// XNetwork.
+	// +optional
+	NAT *bool `json:"nat,omitempty"`
+
+	// Labels are added to the network.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// AddressFamily of a network. Only IPv4 can be allocated by metal-api.
+// +kubebuilder:validation:Enum=IPv4
+type AddressFamily string
+
+const (
+	AddressFamilyIPv4 AddressFamily = "IPv4"
+)
+
+// FailureDomainSpec describes a failure domain as defined by Cluster API.
+type FailureDomainSpec struct {
+	// ControlPlane tells whether control plane machines may be placed in the failure domain.
+	// +optional
+	ControlPlane bool `json:"controlPlane,omitempty"`
+
+	// Attributes are arbitrary data about the failure domain.
+	// +optional
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// FailureDomains are the failure domains machines may be placed in, keyed by name.
+type FailureDomains map[string]FailureDomainSpec
+
+// DriftPolicy tells what to do if a metal-stack resource no longer matches
+// what was recorded.
+// +kubebuilder:validation:Enum=Recreate;Report
+type DriftPolicy string
+
+const (
+	// DriftPolicyRecreate recreates the drifted metal-stack resource.
+	DriftPolicyRecreate DriftPolicy = "Recreate"
+	// DriftPolicyReport only marks the resource Drifted.
+	DriftPolicyReport DriftPolicy = "Report"
+)
+
 type XFirewallTemplate struct {
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 	Spec              XFirewallSpec `json:"spec,omitempty"`
@@ -51,12 +165,42 @@ type XClusterStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
+	// Ready tells Cluster API that the infrastructure of the cluster is ready.
 	Ready bool `json:"ready,omitempty"`
+
+	// PrivateNetworkPrefixes are the prefixes of the private network.
+	// +optional
+	PrivateNetworkPrefixes []string `json:"privateNetworkPrefixes,omitempty"`
+
+	// FailureDomains are the metal-stack partitions the machines of the
+	// cluster may be placed in.
+	// +optional
+	FailureDomains FailureDomains `json:"failureDomains,omitempty"`
+
+	// Phase summarizes where the xcluster is in its lifecycle.
+	// +optional
+	Phase Phase `json:"phase,omitempty"`
+
+	// Conditions describe the observed state of the xcluster in detail.
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// DryRunCalls are the metal-api calls for the xcluster which were skipped
+	// because the manager runs with --dry-run.
+	// +optional
+	DryRunCalls []string `json:"dryRunCalls,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=xcl,categories=metal
+// +kubebuilder:printcolumn:name="Partition",type=string,JSONPath=`.spec.partition`
+// +kubebuilder:printcolumn:name="Project",type=string,JSONPath=`.spec.projectID`
+// +kubebuilder:printcolumn:name="Network",type=string,JSONPath=`.spec.privateNetworkID`
+// +kubebuilder:printcolumn:name="Prefixes",type=string,JSONPath=`.status.privateNetworkPrefixes`,priority=1
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
 // +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.ready`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // XCluster is the Schema for the xclusters API
 type XCluster struct {
@@ -84,6 +228,40 @@ func (fw *XCluster) IsBeingDeleted() bool {
 	return !fw.ObjectMeta.DeletionTimestamp.IsZero()
 }
 
+func (cl *XCluster) GetCondition(t ConditionType) *Condition {
+	return getCondition(cl.Status.Conditions, t)
+}
+func (cl *XCluster) SetCondition(c Condition) bool {
+	return setCondition(&cl.Status.Conditions, c)
+}
+func (cl *XCluster) SetPhase(p Phase) bool {
+	changed := cl.Status.Phase != p
+	cl.Status.Phase = p
+	return changed
+}
+
+// RecordDryRunCall adds call to the skipped metal-api calls unless it is
+// already there. It returns whether anything changed.
+func (cl *XCluster) RecordDryRunCall(call string) bool {
+	if containsElem(cl.Status.DryRunCalls, call) {
+		return false
+	}
+	cl.Status.DryRunCalls = append(cl.Status.DryRunCalls, call)
+	return true
+}
+
+// ToXNetwork returns the XNetwork providing the private network of cl.
+func (cl *XCluster) ToXNetwork() *XNetwork {
+	n := &XNetwork{}
+	n.Name = cl.Name
+	n.Namespace = cl.Namespace
+	n.Spec.Partition = cl.Spec.Partition
+	n.Spec.ProjectID = cl.Spec.ProjectID
+	n.Spec.PrivateNetworkSpec = cl.Spec.PrivateNetwork
+	n.Spec.DriftPolicy = cl.Spec.DriftPolicy
+	return n
+}
+
 func (cl *XCluster) ToXFirewall() *XFirewall {
 	fw := &XFirewall{}
 	fw.Name = cl.Name
@@ -91,6 +269,9 @@ func (cl *XCluster) ToXFirewall() *XFirewall {
 	fw.Spec.DefaultNetworkID = cl.Spec.XFirewallTemplate.Spec.DefaultNetworkID
 	fw.Spec.Image = cl.Spec.XFirewallTemplate.Spec.Image
 	fw.Spec.Size = cl.Spec.XFirewallTemplate.Spec.Size
+	fw.Spec.DriftPolicy = cl.Spec.DriftPolicy
+	fw.Spec.AdditionalNetworks = cl.Spec.XFirewallTemplate.Spec.AdditionalNetworks
+	fw.Spec.NetworkChangePolicy = cl.Spec.XFirewallTemplate.Spec.NetworkChangePolicy
 	return fw
 }
 
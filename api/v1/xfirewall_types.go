@@ -32,19 +32,75 @@ type XFirewallSpec struct {
 	Image            string `json:"image,omitempty"`
 	MachineID        string `json:"machineID,omitempty"`
 	Size             string `json:"size,omitempty"`
+
+	// DriftPolicy tells what to do if the firewall machine vanishes or is
+	// reallocated out of band. Defaults to Report.
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+
+	// AdditionalNetworks are attached to the firewall on top of the default
+	// network and the private network of the cluster.
+	// +optional
+	AdditionalNetworks []NetworkAttachment `json:"additionalNetworks,omitempty"`
+
+	// NetworkChangePolicy tells what to do if AdditionalNetworks change
+	// once the firewall machine is allocated. metal-api can't attach networks
+	// to an allocated machine, so attaching them takes a new one. Defaults to
+	// Report.
+	// +optional
+	NetworkChangePolicy NetworkChangePolicy `json:"networkChangePolicy,omitempty"`
 }
 
+// NetworkChangePolicy tells what to do if the additional networks of an
+// allocated machine change.
+// +kubebuilder:validation:Enum=Recreate;Report
+type NetworkChangePolicy string
+
+const (
+	// NetworkChangePolicyRecreate deletes the machine and creates a new one
+	// attached to the changed networks, which interrupts the traffic through
+	// it.
+	NetworkChangePolicyRecreate NetworkChangePolicy = "Recreate"
+	// NetworkChangePolicyReport keeps the machine and sets the
+	// NetworksAttached condition to False.
+	NetworkChangePolicyReport NetworkChangePolicy = "Report"
+)
+
 // XFirewallStatus defines the observed state of XFirewall
 type XFirewallStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
 	Ready bool `json:"ready,omitempty"`
+
+	// Networks lists the IPs the firewall got in each of its networks.
+	// +optional
+	Networks []NetworkStatus `json:"networks,omitempty"`
+
+	// Phase summarizes where the xfirewall is in its lifecycle.
+	// +optional
+	Phase Phase `json:"phase,omitempty"`
+
+	// Conditions describe the observed state of the xfirewall in detail.
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// DryRunCalls are the metal-api calls for the xfirewall which were skipped
+	// because the manager runs with --dry-run.
+	// +optional
+	DryRunCalls []string `json:"dryRunCalls,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=xfw,categories=metal
+// +kubebuilder:printcolumn:name="Machine",type=string,JSONPath=`.spec.machineID`
+// +kubebuilder:printcolumn:name="Network",type=string,JSONPath=`.spec.defaultNetworkID`
+// +kubebuilder:printcolumn:name="Size",type=string,JSONPath=`.spec.size`,priority=1
+// +kubebuilder:printcolumn:name="Image",type=string,JSONPath=`.spec.image`,priority=1
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
 // +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.ready`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // XFirewall is the Schema for the xfirewalls API
 type XFirewall struct {
@@ -72,6 +128,28 @@ func (fw *XFirewall) RemoveFinalizer(finalizer string) {
 	fw.ObjectMeta.Finalizers = removeElem(fw.ObjectMeta.Finalizers, finalizer)
 }
 
+func (fw *XFirewall) GetCondition(t ConditionType) *Condition {
+	return getCondition(fw.Status.Conditions, t)
+}
+func (fw *XFirewall) SetCondition(c Condition) bool {
+	return setCondition(&fw.Status.Conditions, c)
+}
+func (fw *XFirewall) SetPhase(p Phase) bool {
+	changed := fw.Status.Phase != p
+	fw.Status.Phase = p
+	return changed
+}
+
+// RecordDryRunCall adds call to the skipped metal-api calls unless it is
+// already there. It returns whether anything changed.
+func (fw *XFirewall) RecordDryRunCall(call string) bool {
+	if containsElem(fw.Status.DryRunCalls, call) {
+		return false
+	}
+	fw.Status.DryRunCalls = append(fw.Status.DryRunCalls, call)
+	return true
+}
+
 func containsElem(ss []string, s string) bool {
 	for _, elem := range ss {
 		if elem == s {
@@ -0,0 +1,150 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IPType is the type of a metal-stack IP.
+// +kubebuilder:validation:Enum=static;ephemeral
+type IPType string
+
+const (
+	// IPTypeStatic IPs stay allocated until they are freed.
+	IPTypeStatic IPType = "static"
+	// IPTypeEphemeral IPs are freed together with the machine they are attached to.
+	IPTypeEphemeral IPType = "ephemeral"
+)
+
+// XIPClaimSpec defines the desired state of XIPClaim
+type XIPClaimSpec struct {
+	// ClusterName is the name of the XCluster in the same namespace whose
+	// project the IP is allocated to. The XCluster owns the claim.
+	ClusterName string `json:"clusterName"`
+
+	// NetworkID is the metal-stack network the IP is allocated from.
+	NetworkID string `json:"networkID"`
+
+	// Address is the specific IP to allocate. Any free IP of the network is
+	// allocated if empty.
+	// +optional
+	Address string `json:"address,omitempty"`
+
+	// Type of the IP. Defaults to static.
+	// +optional
+	Type IPType `json:"type,omitempty"`
+}
+
+// XIPClaimStatus defines the observed state of XIPClaim
+type XIPClaimStatus struct {
+	// Address is the allocated IP.
+	// +optional
+	Address string `json:"address,omitempty"`
+
+	// Phase summarizes where the xipclaim is in its lifecycle.
+	// +optional
+	Phase Phase `json:"phase,omitempty"`
+
+	// Conditions describe the observed state of the xipclaim in detail.
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// DryRunCalls are the metal-api calls for the xipclaim which were skipped
+	// because the manager runs with --dry-run.
+	// +optional
+	DryRunCalls []string `json:"dryRunCalls,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=xip,categories=metal
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.spec.clusterName`
+// +kubebuilder:printcolumn:name="Network",type=string,JSONPath=`.spec.networkID`
+// +kubebuilder:printcolumn:name="Type",type=string,JSONPath=`.spec.type`,priority=1
+// +kubebuilder:printcolumn:name="Address",type=string,JSONPath=`.status.address`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// XIPClaim is the Schema for the xipclaims API
+type XIPClaim struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   XIPClaimSpec   `json:"spec,omitempty"`
+	Status XIPClaimStatus `json:"status,omitempty"`
+}
+
+// XIPClaimFinalizer is for releasing the IP claimed by XIPClaim
+const XIPClaimFinalizer = "xipclaim.finalizers.cluster.www.x-cellent.com"
+
+func (c *XIPClaim) AddFinalizer(finalizer string) {
+	c.ObjectMeta.Finalizers = append(c.ObjectMeta.Finalizers, finalizer)
+}
+func (c *XIPClaim) HasFinalizer(finalizer string) bool {
+	return containsElem(c.ObjectMeta.Finalizers, finalizer)
+}
+func (c *XIPClaim) RemoveFinalizer(finalizer string) {
+	c.ObjectMeta.Finalizers = removeElem(c.ObjectMeta.Finalizers, finalizer)
+}
+
+func (c *XIPClaim) IsBeingDeleted() bool {
+	return !c.ObjectMeta.DeletionTimestamp.IsZero()
+}
+
+// IPType returns the type of the claimed IP.
+func (c *XIPClaim) IPType() IPType {
+	if c.Spec.Type == "" {
+		return IPTypeStatic
+	}
+	return c.Spec.Type
+}
+
+func (c *XIPClaim) GetCondition(t ConditionType) *Condition {
+	return getCondition(c.Status.Conditions, t)
+}
+func (c *XIPClaim) SetCondition(cond Condition) bool {
+	return setCondition(&c.Status.Conditions, cond)
+}
+func (c *XIPClaim) SetPhase(p Phase) bool {
+	changed := c.Status.Phase != p
+	c.Status.Phase = p
+	return changed
+}
+
+// RecordDryRunCall adds call to the skipped metal-api calls unless it is
+// already there. It returns whether anything changed.
+func (c *XIPClaim) RecordDryRunCall(call string) bool {
+	if containsElem(c.Status.DryRunCalls, call) {
+		return false
+	}
+	c.Status.DryRunCalls = append(c.Status.DryRunCalls, call)
+	return true
+}
+
+// +kubebuilder:object:root=true
+
+// XIPClaimList contains a list of XIPClaim
+type XIPClaimList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []XIPClaim `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&XIPClaim{}, &XIPClaimList{})
+}
@@ -0,0 +1,198 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// XMachineSpec defines the desired state of XMachine
+type XMachineSpec struct {
+	// ProviderID is the ID of the metal-stack machine in the form
+	// metal://<machine-ID>. It is set once the machine is allocated.
+	// +optional
+	ProviderID *string `json:"providerID,omitempty"`
+
+	// Size is the metal-stack size of the machine.
+	Size string `json:"size"`
+
+	// Image is the metal-stack image installed on the machine.
+	Image string `json:"image"`
+
+	// SSHPublicKeys are authorized to log into the machine.
+	// +optional
+	SSHPublicKeys []string `json:"sshPublicKeys,omitempty"`
+
+	// Tags are added to the metal-stack machine.
+	// +optional
+	Tags []string `json:"tags,omitempty"`
+
+	// AdditionalNetworks are attached to the machine on top of the private
+	// network of the cluster.
+	// +optional
+	AdditionalNetworks []NetworkAttachment `json:"additionalNetworks,omitempty"`
+}
+
+// MachineAddressType is the type of a MachineAddress as defined by Cluster API.
+type MachineAddressType string
+
+const (
+	MachineHostName    MachineAddressType = "Hostname"
+	MachineExternalIP  MachineAddressType = "ExternalIP"
+	MachineInternalIP  MachineAddressType = "InternalIP"
+	MachineExternalDNS MachineAddressType = "ExternalDNS"
+	MachineInternalDNS MachineAddressType = "InternalDNS"
+)
+
+// MachineAddress is an address of a machine as defined by Cluster API.
+type MachineAddress struct {
+	Type    MachineAddressType `json:"type"`
+	Address string             `json:"address"`
+}
+
+// XMachineStatus defines the observed state of XMachine
+type XMachineStatus struct {
+	// Ready tells Cluster API that the machine has been provisioned.
+	Ready bool `json:"ready,omitempty"`
+
+	// Addresses of the machine, taken from its metal-stack allocation.
+	// +optional
+	Addresses []MachineAddress `json:"addresses,omitempty"`
+
+	// Networks lists the IPs the machine got in each of its networks.
+	// +optional
+	Networks []NetworkStatus `json:"networks,omitempty"`
+
+	// FailureReason is a machine readable reason of a terminal problem. Cluster
+	// API doesn't expect it to go away without replacing the machine.
+	// +optional
+	FailureReason *string `json:"failureReason,omitempty"`
+
+	// FailureMessage is a human readable description of a terminal problem.
+	// +optional
+	FailureMessage *string `json:"failureMessage,omitempty"`
+
+	// Phase summarizes where the xmachine is in its lifecycle.
+	// +optional
+	Phase Phase `json:"phase,omitempty"`
+
+	// Conditions describe the observed state of the xmachine in detail.
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// DryRunCalls are the metal-api calls for the xmachine which were skipped
+	// because the manager runs with --dry-run.
+	// +optional
+	DryRunCalls []string `json:"dryRunCalls,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=xm,categories=metal
+// +kubebuilder:printcolumn:name="ProviderID",type=string,JSONPath=`.spec.providerID`
+// +kubebuilder:printcolumn:name="Size",type=string,JSONPath=`.spec.size`,priority=1
+// +kubebuilder:printcolumn:name="Image",type=string,JSONPath=`.spec.image`,priority=1
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.ready`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// XMachine is the Schema for the xmachines API
+type XMachine struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   XMachineSpec   `json:"spec,omitempty"`
+	Status XMachineStatus `json:"status,omitempty"`
+}
+
+// XMachineFinalizer is for cleaning up the metal-stack machine managed by XMachine
+const XMachineFinalizer = "xmachine.finalizers.cluster.www.x-cellent.com"
+
+// ProviderIDPrefix is the scheme of the provider IDs of metal-stack machines.
+const ProviderIDPrefix = "metal://"
+
+func (m *XMachine) AddFinalizer(finalizer string) {
+	m.ObjectMeta.Finalizers = append(m.ObjectMeta.Finalizers, finalizer)
+}
+func (m *XMachine) HasFinalizer(finalizer string) bool {
+	return containsElem(m.ObjectMeta.Finalizers, finalizer)
+}
+func (m *XMachine) RemoveFinalizer(finalizer string) {
+	m.ObjectMeta.Finalizers = removeElem(m.ObjectMeta.Finalizers, finalizer)
+}
+
+func (m *XMachine) IsBeingDeleted() bool {
+	return !m.ObjectMeta.DeletionTimestamp.IsZero()
+}
+
+// MachineID returns the ID of the metal-stack machine, or "" if none has been
+// allocated yet.
+func (m *XMachine) MachineID() string {
+	if m.Spec.ProviderID == nil {
+		return ""
+	}
+	return strings.TrimPrefix(*m.Spec.ProviderID, ProviderIDPrefix)
+}
+
+// SetMachineID sets the provider ID to the metal-stack machine with the given ID.
+func (m *XMachine) SetMachineID(id string) {
+	providerID := ProviderIDPrefix + id
+	m.Spec.ProviderID = &providerID
+}
+
+// SetFailure records a terminal problem.
+func (m *XMachine) SetFailure(reason, message string) {
+	m.Status.FailureReason = &reason
+	m.Status.FailureMessage = &message
+}
+
+func (m *XMachine) GetCondition(t ConditionType) *Condition {
+	return getCondition(m.Status.Conditions, t)
+}
+func (m *XMachine) SetCondition(c Condition) bool {
+	return setCondition(&m.Status.Conditions, c)
+}
+func (m *XMachine) SetPhase(p Phase) bool {
+	changed := m.Status.Phase != p
+	m.Status.Phase = p
+	return changed
+}
+
+// RecordDryRunCall adds call to the skipped metal-api calls unless it is
+// already there. It returns whether anything changed.
+func (m *XMachine) RecordDryRunCall(call string) bool {
+	if containsElem(m.Status.DryRunCalls, call) {
+		return false
+	}
+	m.Status.DryRunCalls = append(m.Status.DryRunCalls, call)
+	return true
+}
+
+// +kubebuilder:object:root=true
+
+// XMachineList contains a list of XMachine
+type XMachineList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []XMachine `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&XMachine{}, &XMachineList{})
+}
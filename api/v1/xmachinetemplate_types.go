@@ -0,0 +1,56 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// XMachineTemplateSpec defines the desired state of XMachineTemplate
+type XMachineTemplateSpec struct {
+	// Template is what Cluster API clones XMachines from.
+	Template XMachineTemplateResource `json:"template"`
+}
+
+// XMachineTemplateResource describes the XMachines created from the template.
+type XMachineTemplateResource struct {
+	Spec XMachineSpec `json:"spec"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:shortName=xmt,categories=metal
+
+// XMachineTemplate is the Schema for the xmachinetemplates API
+type XMachineTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec XMachineTemplateSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// XMachineTemplateList contains a list of XMachineTemplate
+type XMachineTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []XMachineTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&XMachineTemplate{}, &XMachineTemplateList{})
+}
@@ -0,0 +1,177 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DeletionPolicy tells what happens to a metal-stack resource once the
+// resource managing it is deleted.
+// +kubebuilder:validation:Enum=Free;Retain
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyFree frees the metal-stack resource.
+	DeletionPolicyFree DeletionPolicy = "Free"
+	// DeletionPolicyRetain leaves the metal-stack resource alone.
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+)
+
+// XNetworkSpec defines the desired state of XNetwork
+type XNetworkSpec struct {
+	// Partition is the physical location of the network.
+	Partition string `json:"partition"`
+
+	// ProjectID is the project the network belongs to. Defaults to the
+	// project of the namespace, see XProject.
+	// +optional
+	ProjectID string `json:"projectID,omitempty"`
+
+	// NetworkID is the ID of the metal-stack network. It is set once the
+	// network is allocated. If it is given up front, the existing network is
+	// adopted instead.
+	// +optional
+	NetworkID string `json:"networkID,omitempty"`
+
+	// The parameters of the network to be allocated.
+	PrivateNetworkSpec `json:",inline"`
+
+	// DeletionPolicy tells whether the network is freed once the xnetwork is
+	// deleted. Defaults to Free for a network allocated by the xnetwork and
+	// to Retain for an adopted one.
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// DriftPolicy tells what to do if the network vanishes or changes out of
+	// band. Defaults to Report.
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+}
+
+// XNetworkStatus defines the observed state of XNetwork
+type XNetworkStatus struct {
+	// Allocated tells whether the network was allocated by the xnetwork
+	// rather than adopted.
+	// +optional
+	Allocated bool `json:"allocated,omitempty"`
+
+	// Ready tells whether the network is allocated and in sync.
+	Ready bool `json:"ready,omitempty"`
+
+	// Prefixes of the network.
+	// +optional
+	Prefixes []string `json:"prefixes,omitempty"`
+
+	// Phase summarizes where the xnetwork is in its lifecycle.
+	// +optional
+	Phase Phase `json:"phase,omitempty"`
+
+	// Conditions describe the observed state of the xnetwork in detail.
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// DryRunCalls are the metal-api calls for the xnetwork which were skipped
+	// because the manager runs with --dry-run.
+	// +optional
+	DryRunCalls []string `json:"dryRunCalls,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=xnet,categories=metal
+// +kubebuilder:printcolumn:name="Partition",type=string,JSONPath=`.spec.partition`
+// +kubebuilder:printcolumn:name="Project",type=string,JSONPath=`.spec.projectID`
+// +kubebuilder:printcolumn:name="Network",type=string,JSONPath=`.spec.networkID`
+// +kubebuilder:printcolumn:name="Prefixes",type=string,JSONPath=`.status.prefixes`,priority=1
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.ready`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// XNetwork is the Schema for the xnetworks API
+type XNetwork struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   XNetworkSpec   `json:"spec,omitempty"`
+	Status XNetworkStatus `json:"status,omitempty"`
+}
+
+// XNetworkFinalizer is for freeing the network managed by XNetwork
+const XNetworkFinalizer = "xnetwork.finalizers.cluster.www.x-cellent.com"
+
+func (n *XNetwork) AddFinalizer(finalizer string) {
+	n.ObjectMeta.Finalizers = append(n.ObjectMeta.Finalizers, finalizer)
+}
+func (n *XNetwork) HasFinalizer(finalizer string) bool {
+	return containsElem(n.ObjectMeta.Finalizers, finalizer)
+}
+func (n *XNetwork) RemoveFinalizer(finalizer string) {
+	n.ObjectMeta.Finalizers = removeElem(n.ObjectMeta.Finalizers, finalizer)
+}
+
+func (n *XNetwork) IsBeingDeleted() bool {
+	return !n.ObjectMeta.DeletionTimestamp.IsZero()
+}
+
+// Frees reports whether the network is freed once n is deleted. Unless the
+// policy says otherwise, only a network n allocated itself is freed.
+func (n *XNetwork) Frees() bool {
+	switch n.Spec.DeletionPolicy {
+	case DeletionPolicyFree:
+		return true
+	case DeletionPolicyRetain:
+		return false
+	default:
+		return n.Status.Allocated
+	}
+}
+
+func (n *XNetwork) GetCondition(t ConditionType) *Condition {
+	return getCondition(n.Status.Conditions, t)
+}
+func (n *XNetwork) SetCondition(c Condition) bool {
+	return setCondition(&n.Status.Conditions, c)
+}
+func (n *XNetwork) SetPhase(p Phase) bool {
+	changed := n.Status.Phase != p
+	n.Status.Phase = p
+	return changed
+}
+
+// RecordDryRunCall adds call to the skipped metal-api calls unless it is
+// already there. It returns whether anything changed.
+func (n *XNetwork) RecordDryRunCall(call string) bool {
+	if containsElem(n.Status.DryRunCalls, call) {
+		return false
+	}
+	n.Status.DryRunCalls = append(n.Status.DryRunCalls, call)
+	return true
+}
+
+// +kubebuilder:object:root=true
+
+// XNetworkList contains a list of XNetwork
+type XNetworkList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []XNetwork `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&XNetwork{}, &XNetworkList{})
+}
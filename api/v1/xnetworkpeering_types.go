@@ -0,0 +1,135 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// XNetworkPeeringSpec defines the desired state of XNetworkPeering
+type XNetworkPeeringSpec struct {
+	// ClusterName is the name of the XCluster in the same namespace whose
+	// firewall is attached to the private network of the peer.
+	ClusterName string `json:"clusterName"`
+
+	// PeerClusterName is the name of the XCluster in the same namespace whose
+	// private network is attached. The network has to be shared unless both
+	// clusters belong to the same project.
+	PeerClusterName string `json:"peerClusterName"`
+}
+
+// XNetworkPeeringStatus defines the observed state of XNetworkPeering
+type XNetworkPeeringStatus struct {
+	// NetworkID is the private network of the peer.
+	// +optional
+	NetworkID string `json:"networkID,omitempty"`
+
+	// Attached tells whether the firewall of the cluster is attached to the
+	// private network of the peer.
+	// +optional
+	Attached bool `json:"attached,omitempty"`
+
+	// Phase summarizes where the xnetworkpeering is in its lifecycle.
+	// +optional
+	Phase Phase `json:"phase,omitempty"`
+
+	// Conditions describe the observed state of the xnetworkpeering in detail.
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// DryRunCalls are the metal-api calls for the xnetworkpeering which were
+	// skipped because the manager runs with --dry-run.
+	// +optional
+	DryRunCalls []string `json:"dryRunCalls,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=xnp,categories=metal
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.spec.clusterName`
+// +kubebuilder:printcolumn:name="Peer",type=string,JSONPath=`.spec.peerClusterName`
+// +kubebuilder:printcolumn:name="Network",type=string,JSONPath=`.status.networkID`,priority=1
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Attached",type=string,JSONPath=`.status.attached`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// XNetworkPeering is the Schema for the xnetworkpeerings API
+type XNetworkPeering struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   XNetworkPeeringSpec   `json:"spec,omitempty"`
+	Status XNetworkPeeringStatus `json:"status,omitempty"`
+}
+
+// XNetworkPeeringFinalizer is for detaching the firewall from the peer network
+const XNetworkPeeringFinalizer = "xnetworkpeering.finalizers.cluster.www.x-cellent.com"
+
+func (p *XNetworkPeering) AddFinalizer(finalizer string) {
+	p.ObjectMeta.Finalizers = append(p.ObjectMeta.Finalizers, finalizer)
+}
+func (p *XNetworkPeering) HasFinalizer(finalizer string) bool {
+	return containsElem(p.ObjectMeta.Finalizers, finalizer)
+}
+func (p *XNetworkPeering) RemoveFinalizer(finalizer string) {
+	p.ObjectMeta.Finalizers = removeElem(p.ObjectMeta.Finalizers, finalizer)
+}
+
+func (p *XNetworkPeering) IsBeingDeleted() bool {
+	return !p.ObjectMeta.DeletionTimestamp.IsZero()
+}
+
+// Involves reports whether the xcluster with the given name is either side of
+// the peering.
+func (p *XNetworkPeering) Involves(clusterName string) bool {
+	return p.Spec.ClusterName == clusterName || p.Spec.PeerClusterName == clusterName
+}
+
+func (p *XNetworkPeering) GetCondition(t ConditionType) *Condition {
+	return getCondition(p.Status.Conditions, t)
+}
+func (p *XNetworkPeering) SetCondition(c Condition) bool {
+	return setCondition(&p.Status.Conditions, c)
+}
+func (p *XNetworkPeering) SetPhase(phase Phase) bool {
+	changed := p.Status.Phase != phase
+	p.Status.Phase = phase
+	return changed
+}
+
+// RecordDryRunCall adds call to the skipped metal-api calls unless it is
+// already there. It returns whether anything changed.
+func (p *XNetworkPeering) RecordDryRunCall(call string) bool {
+	if containsElem(p.Status.DryRunCalls, call) {
+		return false
+	}
+	p.Status.DryRunCalls = append(p.Status.DryRunCalls, call)
+	return true
+}
+
+// +kubebuilder:object:root=true
+
+// XNetworkPeeringList contains a list of XNetworkPeering
+type XNetworkPeeringList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []XNetworkPeering `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&XNetworkPeering{}, &XNetworkPeeringList{})
+}
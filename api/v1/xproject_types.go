@@ -0,0 +1,131 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProjectIDAnnotation on a namespace names the metal-stack project the
+// resources in the namespace belong to unless they name one themselves. It
+// takes precedence over an XProject in the namespace.
+const ProjectIDAnnotation = "cluster.www.x-cellent.com/project-id"
+
+// XProjectSpec defines the desired state of XProject
+type XProjectSpec struct {
+	// Name of the metal-stack project. Defaults to the namespace of the
+	// xproject. Projects are looked up by name and tenant before one is
+	// created, so xprojects of the same name and tenant share the project.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// TenantID is the tenant the project belongs to.
+	TenantID string `json:"tenantID"`
+
+	// Description of the project created.
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// ProjectID is the ID of the metal-stack project. It is set once the
+	// project is found or created. If it is given up front, the existing
+	// project is adopted instead.
+	// +optional
+	ProjectID string `json:"projectID,omitempty"`
+}
+
+// XProjectStatus defines the observed state of XProject
+type XProjectStatus struct {
+	// Ready tells whether the project exists in metal-stack.
+	Ready bool `json:"ready,omitempty"`
+
+	// Phase summarizes where the xproject is in its lifecycle.
+	// +optional
+	Phase Phase `json:"phase,omitempty"`
+
+	// Conditions describe the observed state of the xproject in detail.
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// DryRunCalls are the metal-api calls for the xproject which were skipped
+	// because the manager runs with --dry-run.
+	// +optional
+	DryRunCalls []string `json:"dryRunCalls,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=xprj,categories=metal
+// +kubebuilder:printcolumn:name="Tenant",type=string,JSONPath=`.spec.tenantID`
+// +kubebuilder:printcolumn:name="Project",type=string,JSONPath=`.spec.projectID`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.ready`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// XProject is the Schema for the xprojects API. It maps its namespace to a
+// metal-stack project, which is created if it doesn't exist yet. The project
+// is left in metal-stack once the xproject is deleted, since other resources
+// may still belong to it.
+type XProject struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   XProjectSpec   `json:"spec,omitempty"`
+	Status XProjectStatus `json:"status,omitempty"`
+}
+
+// ProjectName returns the name of the metal-stack project of p.
+func (p *XProject) ProjectName() string {
+	if p.Spec.Name != "" {
+		return p.Spec.Name
+	}
+	return p.Namespace
+}
+
+func (p *XProject) GetCondition(t ConditionType) *Condition {
+	return getCondition(p.Status.Conditions, t)
+}
+func (p *XProject) SetCondition(c Condition) bool {
+	return setCondition(&p.Status.Conditions, c)
+}
+func (p *XProject) SetPhase(ph Phase) bool {
+	changed := p.Status.Phase != ph
+	p.Status.Phase = ph
+	return changed
+}
+
+// RecordDryRunCall adds call to the skipped metal-api calls unless it is
+// already there. It returns whether anything changed.
+func (p *XProject) RecordDryRunCall(call string) bool {
+	if containsElem(p.Status.DryRunCalls, call) {
+		return false
+	}
+	p.Status.DryRunCalls = append(p.Status.DryRunCalls, call)
+	return true
+}
+
+// +kubebuilder:object:root=true
+
+// XProjectList contains a list of XProject
+type XProjectList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []XProject `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&XProject{}, &XProjectList{})
+}
@@ -0,0 +1,94 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// fake-metal-api serves an in-memory metal-api with the endpoints xcluster
+// uses, so that the manager can be run against it in tests and demos
+// without mini-lab:
+//
+//	fake-metal-api --hmac secret &
+//	METALCTL_URL=http://localhost:8080 METALCTL_HMAC=secret make run
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/metal-stack/metal-go/api/models"
+
+	"github.com/LimKianAn/xcluster/metal"
+	"github.com/LimKianAn/xcluster/metal/fake"
+)
+
+func main() {
+	var (
+		addr              string
+		hmacKey           string
+		provisioningDelay time.Duration
+		partitions        string
+		prefixLength      int
+		networks          string
+		projects          string
+		version           string
+	)
+	flag.StringVar(&addr, "bind-address", ":8080", "The address metal-api is served on.")
+	flag.StringVar(&hmacKey, "hmac", os.Getenv("METALCTL_HMAC"),
+		"The HMAC key requests have to be authenticated with. Requests aren't authenticated if empty.")
+	flag.DurationVar(&provisioningDelay, "provisioning-delay", 10*time.Second,
+		"How long machines and firewalls take to be installed.")
+	flag.StringVar(&partitions, "partitions", "vagrant", "Comma-separated IDs of the partitions.")
+	flag.IntVar(&prefixLength, "private-network-prefix-length", fake.DefaultPrefixLength,
+		"The length of the private networks allocated in the partitions.")
+	flag.StringVar(&networks, "external-networks", "internet-vagrant",
+		"Comma-separated IDs of the external networks, e.g. for the default network of firewalls.")
+	flag.StringVar(&projects, "projects", "00000000-0000-0000-0000-000000000000",
+		"Comma-separated IDs of the projects which exist from the start.")
+	flag.StringVar(&version, "version", metal.MinVersion, "The version of metal-api reported.")
+	flag.Parse()
+
+	backend := fake.New()
+	backend.ProvisioningDelay = provisioningDelay
+	backend.Version = version
+	for _, id := range split(partitions) {
+		backend.AddPartition(id, int32(prefixLength))
+	}
+	for _, id := range split(networks) {
+		id := id
+		backend.AddNetwork(&models.V1NetworkResponse{ID: &id, Name: id})
+	}
+	for _, id := range split(projects) {
+		backend.AddProject(id, id, "")
+	}
+
+	fmt.Printf("serving fake metal-api on %s\n", addr)
+	if err := http.ListenAndServe(addr, fake.NewServer(backend, hmacKey)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func split(s string) []string {
+	var result []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
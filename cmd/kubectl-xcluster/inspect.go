@@ -0,0 +1,118 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/metal-stack/metal-go/api/models"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+)
+
+// clusterView is an XCluster together with everything it manages.
+type clusterView struct {
+	XCluster  *clusterv1.XCluster       `json:"xcluster"`
+	XFirewall *clusterv1.XFirewall      `json:"xfirewall,omitempty"`
+	Network   *models.V1NetworkResponse `json:"network,omitempty"`
+	Machine   *models.V1MachineResponse `json:"machine,omitempty"`
+
+	// Errors are the problems met while fetching the resources above.
+	Errors []string `json:"errors,omitempty"`
+}
+
+type inspector struct {
+	client.Client
+	namespace string
+
+	metal metal.Client
+	// metalDisabled tells why metal is nil.
+	metalDisabled string
+}
+
+// collect fetches the xcluster with the given name, or all the xclusters in
+// the namespace if names is empty.
+func (i *inspector) collect(ctx context.Context, names []string) ([]*clusterView, error) {
+	var clusters []clusterv1.XCluster
+	if len(names) == 0 {
+		list := &clusterv1.XClusterList{}
+		if err := i.List(ctx, list, client.InNamespace(i.namespace)); err != nil {
+			return nil, fmt.Errorf("failed to list xclusters: %w", err)
+		}
+		clusters = list.Items
+	} else {
+		if i.namespace == "" {
+			return nil, fmt.Errorf("a namespace is required when getting an xcluster by name")
+		}
+		cl := clusterv1.XCluster{}
+		if err := i.Get(ctx, types.NamespacedName{Namespace: i.namespace, Name: names[0]}, &cl); err != nil {
+			return nil, fmt.Errorf("failed to fetch xcluster: %w", err)
+		}
+		clusters = append(clusters, cl)
+	}
+
+	views := make([]*clusterView, 0, len(clusters))
+	for idx := range clusters {
+		views = append(views, i.inspect(ctx, &clusters[idx]))
+	}
+	return views, nil
+}
+
+func (i *inspector) inspect(ctx context.Context, cl *clusterv1.XCluster) *clusterView {
+	v := &clusterView{XCluster: cl}
+
+	fw := &clusterv1.XFirewall{}
+	key := types.NamespacedName{Namespace: cl.Namespace, Name: cl.ToXFirewall().Name}
+	if err := i.Get(ctx, key, fw); err == nil {
+		v.XFirewall = fw
+	} else if !errors.IsNotFound(err) {
+		v.addError("failed to fetch xfirewall: %v", err)
+	}
+
+	if i.metal == nil {
+		v.addError("%s", i.metalDisabled)
+		return v
+	}
+
+	if id := cl.Spec.PrivateNetworkID; id != "" {
+		if resp, err := i.metal.NetworkGet(ctx, id); err == nil {
+			v.Network = resp.Network
+		} else {
+			v.addError("failed to fetch metal-stack network %s: %v", id, err)
+		}
+	}
+
+	if v.XFirewall != nil && v.XFirewall.Spec.MachineID != "" {
+		id := v.XFirewall.Spec.MachineID
+		if resp, err := i.metal.MachineGet(ctx, id); err == nil {
+			v.Machine = resp.Machine
+		} else {
+			v.addError("failed to fetch metal-stack machine %s: %v", id, err)
+		}
+	}
+
+	return v
+}
+
+func (v *clusterView) addError(format string, args ...interface{}) {
+	v.Errors = append(v.Errors, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,213 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-xcluster is a kubectl plugin which shows XClusters together with
+// their XFirewalls and the live state of the underlying metal-stack network
+// and machine. Put it on your PATH and call it as `kubectl xcluster`.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	metalgo "github.com/metal-stack/metal-go"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+)
+
+const usage = `Inspect XClusters together with their metal-stack state.
+
+Usage:
+  kubectl xcluster get [NAME] [flags]       list xclusters with their firewall machine state
+  kubectl xcluster describe NAME [flags]    show an xcluster, its xfirewall, network and machine in detail
+  kubectl xcluster tree NAME [flags]        show an xcluster and the resources it manages as a tree
+
+metal-api is reached via $METALCTL_URL and $METALCTL_HMAC unless overridden by flags.
+
+Flags:
+`
+
+type options struct {
+	kubeconfig    string
+	kubeContext   string
+	namespace     string
+	allNamespaces bool
+	output        string
+	metalURL      string
+	metalHMAC     string
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		printUsage(newFlagSet(&options{}))
+		return fmt.Errorf("missing command")
+	}
+	cmd := args[0]
+
+	opts := &options{}
+	fs := newFlagSet(opts)
+	names, err := parseInterspersed(fs, args[1:])
+	if err != nil {
+		return err
+	}
+
+	switch opts.output {
+	case "", "json", "yaml":
+	default:
+		return fmt.Errorf("unsupported output format %q, use json or yaml", opts.output)
+	}
+
+	switch cmd {
+	case "get":
+		if len(names) > 1 {
+			return fmt.Errorf("get takes at most one name")
+		}
+	case "describe", "tree":
+		if len(names) != 1 {
+			return fmt.Errorf("%s takes exactly one name", cmd)
+		}
+	case "help", "-h", "--help":
+		printUsage(fs)
+		return nil
+	default:
+		printUsage(fs)
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+
+	ins, err := newInspector(opts)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	views, err := ins.collect(ctx, names)
+	if err != nil {
+		return err
+	}
+
+	if opts.output != "" {
+		return printStructured(out, opts.output, views, cmd == "get" && len(names) == 0)
+	}
+
+	switch cmd {
+	case "get":
+		return printTable(out, views, opts.allNamespaces)
+	case "describe":
+		return printDescription(out, views[0])
+	default:
+		return printTree(out, views[0])
+	}
+}
+
+func newFlagSet(opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet("kubectl-xcluster", flag.ContinueOnError)
+	fs.StringVar(&opts.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file.")
+	fs.StringVar(&opts.kubeContext, "context", "", "The kubeconfig context to use.")
+	fs.StringVar(&opts.namespace, "namespace", "", "The namespace of the xclusters. Defaults to the namespace of the context.")
+	fs.StringVar(&opts.namespace, "n", "", "Shorthand for --namespace.")
+	fs.BoolVar(&opts.allNamespaces, "all-namespaces", false, "List xclusters in all namespaces.")
+	fs.BoolVar(&opts.allNamespaces, "A", false, "Shorthand for --all-namespaces.")
+	fs.StringVar(&opts.output, "output", "", "Output format: json or yaml. Human-readable if empty.")
+	fs.StringVar(&opts.output, "o", "", "Shorthand for --output.")
+	fs.StringVar(&opts.metalURL, "metal-url", os.Getenv("METALCTL_URL"), "The URL of metal-api.")
+	fs.StringVar(&opts.metalHMAC, "metal-hmac", os.Getenv("METALCTL_HMAC"), "The HMAC key of metal-api.")
+	fs.Usage = func() { printUsage(fs) }
+	return fs
+}
+
+func printUsage(fs *flag.FlagSet) {
+	fmt.Fprint(os.Stderr, usage)
+	fs.SetOutput(os.Stderr)
+	fs.PrintDefaults()
+}
+
+// parseInterspersed parses flags which may come before or after the
+// positional arguments, as with kubectl, and returns the latter.
+func parseInterspersed(fs *flag.FlagSet, args []string) (positional []string, err error) {
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func newInspector(opts *options) (*inspector, error) {
+	loading := clientcmd.NewDefaultClientConfigLoadingRules()
+	loading.ExplicitPath = opts.kubeconfig
+	kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loading, &clientcmd.ConfigOverrides{
+		CurrentContext: opts.kubeContext,
+	})
+
+	restConfig, err := kubeConfig.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+
+	namespace := opts.namespace
+	if namespace == "" {
+		if namespace, _, err = kubeConfig.Namespace(); err != nil {
+			return nil, fmt.Errorf("failed to determine the namespace: %w", err)
+		}
+	}
+	if opts.allNamespaces {
+		namespace = ""
+	}
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = clusterv1.AddToScheme(scheme)
+
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+
+	ins := &inspector{Client: c, namespace: namespace}
+
+	if opts.metalURL == "" {
+		ins.metalDisabled = "metal-api URL not set, use --metal-url or $METALCTL_URL"
+		return ins, nil
+	}
+	driver, err := metalgo.NewDriver(opts.metalURL, "", opts.metalHMAC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metal-api client: %w", err)
+	}
+	ins.metal = metal.WithResilience(metal.NewClient(driver), metal.DefaultResilienceOptions())
+
+	return ins, nil
+}
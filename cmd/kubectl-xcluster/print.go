@@ -0,0 +1,257 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	metalgo "github.com/metal-stack/metal-go"
+	"github.com/metal-stack/metal-go/api/models"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/yaml"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+)
+
+const none = "<none>"
+
+func printStructured(out io.Writer, format string, views []*clusterView, list bool) error {
+	var v interface{} = views
+	if !list {
+		v = views[0]
+	}
+
+	var (
+		b   []byte
+		err error
+	)
+	if format == "yaml" {
+		b, err = yaml.Marshal(v)
+	} else {
+		b, err = json.MarshalIndent(v, "", "  ")
+		b = append(b, '\n')
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal output: %w", err)
+	}
+
+	_, err = out.Write(b)
+	return err
+}
+
+func printTable(out io.Writer, views []*clusterView, withNamespace bool) error {
+	w := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+
+	header := "NAME\tPARTITION\tPROJECT\tNETWORK\tPHASE\tREADY\tFIREWALL\tMACHINE STATE\tAGE"
+	if withNamespace {
+		header = "NAMESPACE\t" + header
+	}
+	fmt.Fprintln(w, header)
+
+	for _, v := range views {
+		cl := v.XCluster
+		row := []string{
+			cl.Name,
+			cl.Spec.Partition,
+			cl.Spec.ProjectID,
+			orNone(cl.Spec.PrivateNetworkID),
+			orNone(string(cl.Status.Phase)),
+			fmt.Sprint(cl.Status.Ready),
+			orNone(firewallMachineID(v)),
+			machineState(v),
+			age(cl.CreationTimestamp),
+		}
+		if withNamespace {
+			row = append([]string{cl.Namespace}, row...)
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+
+	return w.Flush()
+}
+
+func printDescription(out io.Writer, v *clusterView) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	cl := v.XCluster
+
+	fmt.Fprintf(w, "XCluster:\t%s/%s\n", cl.Namespace, cl.Name)
+	fmt.Fprintf(w, "  Partition:\t%s\n", cl.Spec.Partition)
+	fmt.Fprintf(w, "  Project:\t%s\n", cl.Spec.ProjectID)
+	fmt.Fprintf(w, "  Private Network:\t%s\n", orNone(cl.Spec.PrivateNetworkID))
+	fmt.Fprintf(w, "  Phase:\t%s\n", orNone(string(cl.Status.Phase)))
+	fmt.Fprintf(w, "  Ready:\t%v\n", cl.Status.Ready)
+	fmt.Fprintf(w, "  Age:\t%s\n", age(cl.CreationTimestamp))
+	printConditions(w, cl.Status.Conditions)
+
+	fmt.Fprintln(w)
+	if fw := v.XFirewall; fw != nil {
+		fmt.Fprintf(w, "XFirewall:\t%s/%s\n", fw.Namespace, fw.Name)
+		fmt.Fprintf(w, "  Machine:\t%s\n", orNone(fw.Spec.MachineID))
+		fmt.Fprintf(w, "  Default Network:\t%s\n", orNone(fw.Spec.DefaultNetworkID))
+		fmt.Fprintf(w, "  Size:\t%s\n", orNone(fw.Spec.Size))
+		fmt.Fprintf(w, "  Image:\t%s\n", orNone(fw.Spec.Image))
+		fmt.Fprintf(w, "  Phase:\t%s\n", orNone(string(fw.Status.Phase)))
+		fmt.Fprintf(w, "  Ready:\t%v\n", fw.Status.Ready)
+		printConditions(w, fw.Status.Conditions)
+	} else {
+		fmt.Fprintf(w, "XFirewall:\t%s\n", none)
+	}
+
+	fmt.Fprintln(w)
+	if n := v.Network; n != nil {
+		fmt.Fprintf(w, "metal-stack Network:\t%s\n", metalgo.StrDeref(n.ID))
+		fmt.Fprintf(w, "  Name:\t%s\n", orNone(n.Name))
+		fmt.Fprintf(w, "  Partition:\t%s\n", orNone(n.Partitionid))
+		fmt.Fprintf(w, "  Project:\t%s\n", orNone(n.Projectid))
+		fmt.Fprintf(w, "  Prefixes:\t%s\n", orNone(strings.Join(n.Prefixes, ", ")))
+		fmt.Fprintf(w, "  Shared:\t%v\n", n.Shared)
+	} else {
+		fmt.Fprintf(w, "metal-stack Network:\t%s\n", none)
+	}
+
+	fmt.Fprintln(w)
+	if m := v.Machine; m != nil {
+		fmt.Fprintf(w, "metal-stack Machine:\t%s\n", metalgo.StrDeref(m.ID))
+		if a := m.Allocation; a != nil {
+			fmt.Fprintf(w, "  Hostname:\t%s\n", metalgo.StrDeref(a.Hostname))
+			fmt.Fprintf(w, "  Project:\t%s\n", metalgo.StrDeref(a.Project))
+			if a.Image != nil {
+				fmt.Fprintf(w, "  Image:\t%s\n", metalgo.StrDeref(a.Image.ID))
+			}
+			for _, n := range a.Networks {
+				fmt.Fprintf(w, "  Network %s:\t%s\n", metalgo.StrDeref(n.Networkid), orNone(strings.Join(n.Ips, ", ")))
+			}
+		}
+		fmt.Fprintf(w, "  Liveliness:\t%s\n", orNone(metalgo.StrDeref(m.Liveliness)))
+		if m.Events != nil && len(m.Events.Log) > 0 {
+			fmt.Fprintln(w, "  Events:")
+			fmt.Fprintln(w, "    TIME\tEVENT\tMESSAGE")
+			for _, e := range m.Events.Log {
+				fmt.Fprintf(w, "    %s\t%s\t%s\n", time.Time(e.Time).Format(time.RFC3339), metalgo.StrDeref(e.Event), e.Message)
+			}
+		}
+	} else {
+		fmt.Fprintf(w, "metal-stack Machine:\t%s\n", none)
+	}
+
+	if len(v.Errors) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Errors:")
+		for _, e := range v.Errors {
+			fmt.Fprintf(w, "  %s\n", e)
+		}
+	}
+
+	return w.Flush()
+}
+
+func printTree(out io.Writer, v *clusterView) error {
+	cl := v.XCluster
+	fmt.Fprintf(out, "XCluster/%s %s\n", cl.Name, phaseOf(string(cl.Status.Phase), cl.Status.Ready))
+
+	network := "metal-stack Network " + none
+	if n := v.Network; n != nil {
+		network = fmt.Sprintf("metal-stack Network/%s %s", metalgo.StrDeref(n.ID), strings.Join(n.Prefixes, ","))
+	} else if cl.Spec.PrivateNetworkID != "" {
+		network = fmt.Sprintf("metal-stack Network/%s (unknown)", cl.Spec.PrivateNetworkID)
+	}
+	fmt.Fprintf(out, "├── %s\n", network)
+
+	fw := v.XFirewall
+	if fw == nil {
+		fmt.Fprintf(out, "└── XFirewall %s\n", none)
+	} else {
+		fmt.Fprintf(out, "└── XFirewall/%s %s\n", fw.Name, phaseOf(string(fw.Status.Phase), fw.Status.Ready))
+		if fw.Spec.MachineID == "" {
+			fmt.Fprintf(out, "    └── metal-stack Machine %s\n", none)
+		} else {
+			fmt.Fprintf(out, "    └── metal-stack Machine/%s %s\n", fw.Spec.MachineID, machineState(v))
+		}
+	}
+
+	for _, e := range v.Errors {
+		fmt.Fprintf(out, "! %s\n", e)
+	}
+	return nil
+}
+
+func printConditions(w io.Writer, conditions []clusterv1.Condition) {
+	if len(conditions) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "  Conditions:")
+	fmt.Fprintln(w, "    TYPE\tSTATUS\tREASON\tAGE\tMESSAGE")
+	for _, c := range conditions {
+		fmt.Fprintf(w, "    %s\t%s\t%s\t%s\t%s\n", c.Type, c.Status, c.Reason, age(c.LastTransitionTime), c.Message)
+	}
+}
+
+func firewallMachineID(v *clusterView) string {
+	if v.XFirewall == nil {
+		return ""
+	}
+	return v.XFirewall.Spec.MachineID
+}
+
+// machineState summarizes the liveliness and the last provisioning event of
+// the firewall machine as metal-api sees it.
+func machineState(v *clusterView) string {
+	m := v.Machine
+	if m == nil {
+		return none
+	}
+
+	state := metalgo.StrDeref(m.Liveliness)
+	if ev := lastEvent(m); ev != nil {
+		state = fmt.Sprintf("%s, %s %s ago", state, metalgo.StrDeref(ev.Event), duration.HumanDuration(time.Since(time.Time(ev.Time))))
+	}
+	return orNone(state)
+}
+
+func lastEvent(m *models.V1MachineResponse) *models.V1MachineProvisioningEvent {
+	if m.Events == nil || len(m.Events.Log) == 0 {
+		return nil
+	}
+	return m.Events.Log[0]
+}
+
+func phaseOf(phase string, ready bool) string {
+	if phase == "" {
+		return fmt.Sprintf("(ready: %v)", ready)
+	}
+	return fmt.Sprintf("(%s, ready: %v)", phase, ready)
+}
+
+func age(t metav1.Time) string {
+	if t.IsZero() {
+		return none
+	}
+	return duration.HumanDuration(time.Since(t.Time))
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return none
+	}
+	return s
+}
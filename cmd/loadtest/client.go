@@ -0,0 +1,99 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// conflictCounter counts the writes of the reconcilers which the api-server
+// rejected because the object changed since it was read.
+type conflictCounter struct {
+	mu    sync.Mutex
+	count map[string]int
+}
+
+// wrap returns c counting its conflicts.
+func (cc *conflictCounter) wrap(c client.Client) client.Client {
+	return &countingClient{Client: c, conflicts: cc}
+}
+
+func (cc *conflictCounter) observe(write string, err error) {
+	if !errors.IsConflict(err) {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.count == nil {
+		cc.count = map[string]int{}
+	}
+	cc.count[write]++
+}
+
+// counts returns the conflicts per kind of write.
+func (cc *conflictCounter) counts() map[string]int {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	counts := map[string]int{}
+	for write, n := range cc.count {
+		counts[write] = n
+	}
+	return counts
+}
+
+type countingClient struct {
+	client.Client
+	conflicts *conflictCounter
+}
+
+func (c *countingClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	err := c.Client.Update(ctx, obj, opts...)
+	c.conflicts.observe("Update", err)
+	return err
+}
+
+func (c *countingClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	err := c.Client.Patch(ctx, obj, patch, opts...)
+	c.conflicts.observe("Patch", err)
+	return err
+}
+
+func (c *countingClient) Status() client.StatusWriter {
+	return &countingStatusWriter{StatusWriter: c.Client.Status(), conflicts: c.conflicts}
+}
+
+type countingStatusWriter struct {
+	client.StatusWriter
+	conflicts *conflictCounter
+}
+
+func (w *countingStatusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	err := w.StatusWriter.Update(ctx, obj, opts...)
+	w.conflicts.observe("Status().Update", err)
+	return err
+}
+
+func (w *countingStatusWriter) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	err := w.StatusWriter.Patch(ctx, obj, patch, opts...)
+	w.conflicts.observe("Status().Patch", err)
+	return err
+}
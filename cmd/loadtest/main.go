@@ -0,0 +1,295 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// loadtest creates many xclusters at once against envtest and the in-memory
+// metal-api, and reports how long they took to get ready, how often they were
+// reconciled, which metal-api calls were made and how many writes to the
+// api-server conflicted:
+//
+//	make manifests
+//	loadtest --clusters 200 --parallel 20
+//
+// envtest needs the binaries of etcd and kube-apiserver, which it looks up in
+// $KUBEBUILDER_ASSETS, /usr/local/kubebuilder/bin by default.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/metal-stack/metal-go/api/models"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/controllers"
+	"github.com/LimKianAn/xcluster/metal"
+	"github.com/LimKianAn/xcluster/metal/fake"
+)
+
+const (
+	partition       = "vagrant"
+	project         = "00000000-0000-0000-0000-000000000000"
+	internetNetwork = "internet-vagrant"
+)
+
+type options struct {
+	clusters          int
+	parallel          int
+	namespace         string
+	timeout           time.Duration
+	pollInterval      time.Duration
+	provisioningDelay time.Duration
+	resyncPeriod      time.Duration
+	maxConcurrent     int
+	crdDir            string
+	delete            bool
+	verbose           bool
+}
+
+func main() {
+	var o options
+	flag.IntVar(&o.clusters, "clusters", 100, "How many xclusters are created.")
+	flag.IntVar(&o.parallel, "parallel", 10, "How many xclusters are created at the same time.")
+	flag.StringVar(&o.namespace, "namespace", "default", "The namespace the xclusters are created in.")
+	flag.DurationVar(&o.timeout, "timeout", 5*time.Minute,
+		"How long the xclusters may take to get ready, and to be deleted again.")
+	flag.DurationVar(&o.pollInterval, "poll-interval", 250*time.Millisecond,
+		"How often the xclusters are listed to tell which are ready.")
+	flag.DurationVar(&o.provisioningDelay, "provisioning-delay", 2*time.Second,
+		"How long the firewalls take to be installed by the fake metal-api.")
+	flag.DurationVar(&o.resyncPeriod, "resync-period", 0,
+		"The resync period of the reconcilers. Zero disables the periodic drift check.")
+	flag.IntVar(&o.maxConcurrent, "max-concurrent-reconciles", 1, "How many objects each reconciler reconciles at once.")
+	flag.StringVar(&o.crdDir, "crd-dir", "config/crd/bases", "The directory of the CRDs installed into envtest.")
+	flag.BoolVar(&o.delete, "delete", true,
+		"Delete the xclusters once ready and report how long that took and whether metal-stack resources leaked.")
+	flag.BoolVar(&o.verbose, "v", false, "Log what the reconcilers do.")
+	flag.Parse()
+
+	if err := run(o); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(o options) error {
+	if o.verbose {
+		ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
+	}
+
+	env := &envtest.Environment{CRDDirectoryPaths: []string{o.crdDir}}
+	cfg, err := env.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start envtest: %w", err)
+	}
+	defer func() {
+		if err := env.Stop(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to stop envtest: %v\n", err)
+		}
+	}()
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = clusterv1.AddToScheme(scheme)
+
+	backend := fake.New()
+	backend.ProvisioningDelay = o.provisioningDelay
+	backend.AddPartition(partition, fake.DefaultPrefixLength)
+	backend.AddProject(project, "loadtest", "loadtest")
+	id := internetNetwork
+	backend.AddNetwork(&models.V1NetworkResponse{ID: &id, Name: internetNetwork})
+	driver := metal.WithSerialization(backend)
+
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{Scheme: scheme, MetricsBindAddress: "0"})
+	if err != nil {
+		return fmt.Errorf("failed to create manager: %w", err)
+	}
+	conflicts := &conflictCounter{}
+	c := conflicts.wrap(mgr.GetClient())
+	if err := (&controllers.XClusterReconciler{
+		Client:                  c,
+		Driver:                  driver,
+		Log:                     ctrl.Log.WithName("controllers").WithName("XCluster"),
+		Scheme:                  mgr.GetScheme(),
+		ResyncPeriod:            o.resyncPeriod,
+		MaxConcurrentReconciles: o.maxConcurrent,
+	}).SetupWithManager(mgr); err != nil {
+		return fmt.Errorf("failed to set up the xcluster reconciler: %w", err)
+	}
+	if err := (&controllers.XNetworkReconciler{
+		Client:                  c,
+		Driver:                  driver,
+		Log:                     ctrl.Log.WithName("controllers").WithName("XNetwork"),
+		Scheme:                  mgr.GetScheme(),
+		ResyncPeriod:            o.resyncPeriod,
+		MaxConcurrentReconciles: o.maxConcurrent,
+	}).SetupWithManager(mgr); err != nil {
+		return fmt.Errorf("failed to set up the xnetwork reconciler: %w", err)
+	}
+	if err := (&controllers.XFirewallReconciler{
+		Client:                  c,
+		Driver:                  driver,
+		Log:                     ctrl.Log.WithName("controllers").WithName("XFirewall"),
+		Scheme:                  mgr.GetScheme(),
+		ResyncPeriod:            o.resyncPeriod,
+		MaxConcurrentReconciles: o.maxConcurrent,
+	}).SetupWithManager(mgr); err != nil {
+		return fmt.Errorf("failed to set up the xfirewall reconciler: %w", err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	errs := make(chan error, 1)
+	go func() { errs <- mgr.Start(stop) }()
+
+	// The xclusters are created and watched by a client of their own, so
+	// that the load test doesn't wait for the cache of the manager.
+	k8s, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+
+	r := &report{clusters: o.clusters}
+	start := time.Now()
+	created, err := createXClusters(k8s, o)
+	if err != nil {
+		return err
+	}
+	r.ready = waitFor(k8s, o, created, func(cl *clusterv1.XCluster) bool { return cl.Status.Ready })
+	r.readyAfter = time.Since(start)
+	r.reconciles = reconcileCounts()
+	r.calls = backend.CallCounts()
+	r.conflicts = conflicts.counts()
+	r.queueWait = meanDurations("workqueue_queue_duration_seconds", "name")
+	r.serializationWait = meanDurations("xcluster_metal_api_serialization_wait_seconds", "operation")
+
+	if o.delete {
+		deleted, err := deleteXClusters(k8s, o, created)
+		if err != nil {
+			return err
+		}
+		r.deleted = waitFor(k8s, o, deleted, nil)
+		r.leaked = backend.ResourcesOf(project)
+	}
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("manager stopped: %w", err)
+	default:
+	}
+
+	r.print(os.Stdout)
+	if !r.ok(o.delete) {
+		return fmt.Errorf("load test failed")
+	}
+	return nil
+}
+
+// createXClusters creates o.clusters xclusters, o.parallel at a time, and
+// returns when each was created.
+func createXClusters(c client.Client, o options) (map[string]time.Time, error) {
+	var (
+		mu      sync.Mutex
+		created = map[string]time.Time{}
+		failed  error
+	)
+	names := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < o.parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				err := c.Create(context.Background(), newXCluster(o.namespace, name))
+				mu.Lock()
+				if err != nil && failed == nil {
+					failed = fmt.Errorf("failed to create xcluster %s: %w", name, err)
+				}
+				created[name] = time.Now()
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < o.clusters; i++ {
+		names <- fmt.Sprintf("loadtest-%04d", i)
+	}
+	close(names)
+	wg.Wait()
+	return created, failed
+}
+
+// deleteXClusters deletes the xclusters and returns when each was deleted.
+func deleteXClusters(c client.Client, o options, clusters map[string]time.Time) (map[string]time.Time, error) {
+	deleted := map[string]time.Time{}
+	for name := range clusters {
+		cl := &clusterv1.XCluster{}
+		cl.Namespace, cl.Name = o.namespace, name
+		if err := c.Delete(context.Background(), cl); err != nil {
+			return nil, fmt.Errorf("failed to delete xcluster %s: %w", name, err)
+		}
+		deleted[name] = time.Now()
+	}
+	return deleted, nil
+}
+
+// waitFor lists the xclusters until done reports true for each of the given
+// ones, or until they are gone if done is nil. It returns how long each took
+// from the given start, leaving out the ones which timed out.
+func waitFor(c client.Client, o options, since map[string]time.Time, done func(*clusterv1.XCluster) bool) map[string]time.Duration {
+	took := map[string]time.Duration{}
+	_ = wait.PollImmediate(o.pollInterval, o.timeout, func() (bool, error) {
+		clusters := &clusterv1.XClusterList{}
+		if err := c.List(context.Background(), clusters, client.InNamespace(o.namespace)); err != nil {
+			return false, nil
+		}
+		now := time.Now()
+		left := map[string]bool{}
+		for i := range clusters.Items {
+			cl := &clusters.Items[i]
+			if _, ok := since[cl.Name]; ok && (done == nil || !done(cl)) {
+				left[cl.Name] = true
+			}
+		}
+		for name, start := range since {
+			if _, ok := took[name]; !ok && !left[name] {
+				took[name] = now.Sub(start)
+			}
+		}
+		return len(took) == len(since), nil
+	})
+	return took
+}
+
+func newXCluster(namespace, name string) *clusterv1.XCluster {
+	cl := &clusterv1.XCluster{}
+	cl.Namespace, cl.Name = namespace, name
+	cl.Spec.Partition = partition
+	cl.Spec.ProjectID = project
+	cl.Spec.XFirewallTemplate.Spec.DefaultNetworkID = internetNetwork
+	cl.Spec.XFirewallTemplate.Spec.Size = "v1-small-x86"
+	cl.Spec.XFirewallTemplate.Spec.Image = "firewall-ubuntu-2.0"
+	return cl
+}
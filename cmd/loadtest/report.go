@@ -0,0 +1,201 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// report is what a load test measured.
+type report struct {
+	clusters int
+	// ready and deleted tell how long each xcluster took to get ready and
+	// to be deleted. The ones which timed out are missing.
+	ready      map[string]time.Duration
+	deleted    map[string]time.Duration
+	readyAfter time.Duration
+	// reconciles are counted per controller and result until all
+	// xclusters were ready.
+	reconciles map[string]map[string]int
+	// calls are the metal-api calls per operation until all xclusters were
+	// ready.
+	calls     map[string]int
+	conflicts map[string]int
+	// queueWait is the mean time requests waited in the work queue per
+	// controller, serializationWait the mean time metal-api calls waited for
+	// allocations in the same project and partition per operation.
+	queueWait         map[string]time.Duration
+	serializationWait map[string]time.Duration
+	// leaked are the metal-stack resources left after the deletion.
+	leaked []string
+}
+
+// ok reports whether every xcluster got ready, and was deleted without
+// leaking metal-stack resources if deleted is true.
+func (r *report) ok(deleted bool) bool {
+	if len(r.ready) != r.clusters {
+		return false
+	}
+	return !deleted || len(r.deleted) == r.clusters && len(r.leaked) == 0
+}
+
+func (r *report) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintf(tw, "xclusters\t%d\n", r.clusters)
+	fmt.Fprintf(tw, "ready\t%d after %s\n", len(r.ready), r.readyAfter.Round(time.Millisecond))
+	printDurations(tw, "time to ready", r.ready)
+	if r.deleted != nil {
+		fmt.Fprintf(tw, "deleted\t%d\n", len(r.deleted))
+		printDurations(tw, "time to deletion", r.deleted)
+		fmt.Fprintf(tw, "leaked metal-stack resources\t%d\t%v\n", len(r.leaked), r.leaked)
+	}
+
+	fmt.Fprintln(tw, "\nreconciles\tresult\tcount\tper xcluster")
+	for _, controller := range sortedKeys(r.reconciles) {
+		for _, result := range sortedKeys(r.reconciles[controller]) {
+			n := r.reconciles[controller][result]
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%.1f\n", controller, result, n, float64(n)/float64(r.clusters))
+		}
+	}
+
+	fmt.Fprintln(tw, "\nmetal-api calls\t\tcount\tper xcluster")
+	for _, op := range sortedKeys(r.calls) {
+		fmt.Fprintf(tw, "%s\t\t%d\t%.1f\n", op, r.calls[op], float64(r.calls[op])/float64(r.clusters))
+	}
+
+	fmt.Fprintln(tw, "\napi-server conflicts\t\tcount\tper xcluster")
+	for _, write := range sortedKeys(r.conflicts) {
+		fmt.Fprintf(tw, "%s\t\t%d\t%.1f\n", write, r.conflicts[write], float64(r.conflicts[write])/float64(r.clusters))
+	}
+
+	fmt.Fprintln(tw, "\nmean wait in the work queue")
+	for _, controller := range sortedKeys(r.queueWait) {
+		fmt.Fprintf(tw, "%s\t\t%s\n", controller, r.queueWait[controller].Round(time.Microsecond))
+	}
+
+	fmt.Fprintln(tw, "\nmean wait for allocations in the same project")
+	for _, op := range sortedKeys(r.serializationWait) {
+		fmt.Fprintf(tw, "%s\t\t%s\n", op, r.serializationWait[op].Round(time.Microsecond))
+	}
+}
+
+// printDurations prints the distribution of durations.
+func printDurations(w io.Writer, name string, durations map[string]time.Duration) {
+	if len(durations) == 0 {
+		return
+	}
+	sorted := make([]time.Duration, 0, len(durations))
+	var sum time.Duration
+	for _, d := range durations {
+		sorted = append(sorted, d)
+		sum += d
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p int) time.Duration {
+		return sorted[(len(sorted)-1)*p/100].Round(time.Millisecond)
+	}
+	fmt.Fprintf(w, "%s\tmin %s\tmean %s\tp50 %s\tp90 %s\tp99 %s\tmax %s\n", name,
+		percentile(0), (sum / time.Duration(len(sorted))).Round(time.Millisecond),
+		percentile(50), percentile(90), percentile(99), percentile(100))
+}
+
+// reconcileCounts returns the reconciles counted by controller-runtime per
+// controller and result.
+func reconcileCounts() map[string]map[string]int {
+	counts := map[string]map[string]int{}
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		return counts
+	}
+	for _, f := range families {
+		if f.GetName() != "controller_runtime_reconcile_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			var controller, result string
+			for _, l := range m.GetLabel() {
+				switch l.GetName() {
+				case "controller":
+					controller = l.GetValue()
+				case "result":
+					result = l.GetValue()
+				}
+			}
+			if counts[controller] == nil {
+				counts[controller] = map[string]int{}
+			}
+			counts[controller][result] = int(m.GetCounter().GetValue())
+		}
+	}
+	return counts
+}
+
+// meanDurations returns the means of the histogram metric in seconds by the
+// value of label.
+func meanDurations(metric, label string) map[string]time.Duration {
+	means := map[string]time.Duration{}
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		return means
+	}
+	for _, f := range families {
+		if f.GetName() != metric {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			h := m.GetHistogram()
+			if h.GetSampleCount() == 0 {
+				continue
+			}
+			for _, l := range m.GetLabel() {
+				if l.GetName() == label {
+					means[l.GetValue()] = time.Duration(h.GetSampleSum() / float64(h.GetSampleCount()) * float64(time.Second))
+				}
+			}
+		}
+	}
+	return means
+}
+
+// sortedKeys returns the keys of m, a map keyed by strings, sorted.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]int:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]map[string]int:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]time.Duration:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
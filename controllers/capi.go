@@ -0,0 +1,110 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// The Cluster API types are not vendored, the few fields xcluster needs are
+// read from unstructured objects.
+const (
+	capiGroup = "cluster.x-k8s.io"
+
+	// pausedAnnotation stops the reconciliation of the annotated object.
+	pausedAnnotation = "cluster.x-k8s.io/paused"
+
+	// clusterNameLabel names the Cluster a Machine belongs to.
+	clusterNameLabel = "cluster.x-k8s.io/cluster-name"
+)
+
+// pausedPollInterval is how often a paused object is checked for having been
+// unpaused. Clusters aren't watched, since their CRD needn't be installed, so
+// unpausing one triggers no reconciliation of its infrastructure.
+const pausedPollInterval = time.Minute
+
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters;machines,verbs=get
+
+// ownerCluster returns the Cluster API Cluster owning obj, or nil if there is
+// none or it is gone.
+func ownerCluster(ctx context.Context, c client.Client, obj metav1.Object) (*unstructured.Unstructured, error) {
+	return capiOwner(ctx, c, obj, "Cluster")
+}
+
+// ownerMachine returns the Cluster API Machine owning obj, or nil if there is
+// none or it is gone.
+func ownerMachine(ctx context.Context, c client.Client, obj metav1.Object) (*unstructured.Unstructured, error) {
+	return capiOwner(ctx, c, obj, "Machine")
+}
+
+func capiOwner(ctx context.Context, c client.Client, obj metav1.Object, kind string) (*unstructured.Unstructured, error) {
+	for _, ref := range obj.GetOwnerReferences() {
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil || gv.Group != capiGroup || ref.Kind != kind {
+			continue
+		}
+		return getCAPIObject(ctx, c, gv.WithKind(kind), obj.GetNamespace(), ref.Name)
+	}
+	return nil, nil
+}
+
+// machineCluster returns the Cluster the Cluster API machine belongs to, or
+// nil if it is unknown or gone.
+func machineCluster(ctx context.Context, c client.Client, machine *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	name := machine.GetLabels()[clusterNameLabel]
+	if name == "" {
+		return nil, nil
+	}
+	gvk := machine.GroupVersionKind()
+	gvk.Kind = "Cluster"
+	return getCAPIObject(ctx, c, gvk, machine.GetNamespace(), name)
+}
+
+func getCAPIObject(ctx context.Context, c client.Client, gvk schema.GroupVersionKind, namespace, name string) (*unstructured.Unstructured, error) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, obj)
+	if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s %s: %w", gvk.Kind, name, err)
+	}
+	return obj, nil
+}
+
+// isPaused reports whether obj or the Cluster owning it are paused.
+func isPaused(cluster *unstructured.Unstructured, obj metav1.Object) bool {
+	if _, ok := obj.GetAnnotations()[pausedAnnotation]; ok {
+		return true
+	}
+	if cluster == nil {
+		return false
+	}
+	paused, _, _ := unstructured.NestedBool(cluster.Object, "spec", "paused")
+	return paused
+}
@@ -0,0 +1,142 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	metalgo "github.com/metal-stack/metal-go"
+	"github.com/metal-stack/metal-go/api/models"
+	corev1 "k8s.io/api/core/v1"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+)
+
+// drift describes how a metal-stack resource differs from what was recorded.
+// The zero value means no drift.
+type drift struct {
+	Reason  string
+	Message string
+}
+
+func (d drift) drifted() bool {
+	return d.Reason != ""
+}
+
+// condition returns the Drifted condition reporting d.
+func (d drift) condition() clusterv1.Condition {
+	if !d.drifted() {
+		return clusterv1.Condition{
+			Type:   clusterv1.Drifted,
+			Status: corev1.ConditionFalse,
+			Reason: "InSync",
+		}
+	}
+	return clusterv1.Condition{
+		Type:    clusterv1.Drifted,
+		Status:  corev1.ConditionTrue,
+		Reason:  d.Reason,
+		Message: d.Message,
+	}
+}
+
+// networkDrift checks that the network with the given id still exists in the
+// given partition and project. The network is returned unless it is gone.
+func networkDrift(ctx context.Context, driver metal.Client, id, partition, project string) (drift, *models.V1NetworkResponse, error) {
+	resp, err := driver.NetworkGet(ctx, id)
+	if metal.IsNotFound(err) {
+		return drift{Reason: "NetworkNotFound", Message: fmt.Sprintf("metal-stack network %s does not exist anymore", id)}, nil, nil
+	}
+	if err != nil {
+		return drift{}, nil, fmt.Errorf("failed to fetch metal-stack network: %w", err)
+	}
+
+	n := resp.Network
+	if n.Partitionid != partition || n.Projectid != project {
+		return drift{
+			Reason: "NetworkMismatch",
+			Message: fmt.Sprintf("metal-stack network %s belongs to partition %q and project %q instead of %q and %q",
+				id, n.Partitionid, n.Projectid, partition, project),
+		}, n, nil
+	}
+	return drift{}, n, nil
+}
+
+// machineDrift checks that the machine with the given id still exists and is
+// allocated to the given project in the given partition. The machine is
+// returned unless it is gone.
+func machineDrift(ctx context.Context, driver metal.Client, id, partition, project string) (drift, *models.V1MachineResponse, error) {
+	resp, err := driver.MachineGet(ctx, id)
+	if metal.IsNotFound(err) {
+		return drift{Reason: "MachineNotFound", Message: fmt.Sprintf("metal-stack machine %s does not exist anymore", id)}, nil, nil
+	}
+	if err != nil {
+		return drift{}, nil, fmt.Errorf("failed to fetch metal-stack machine: %w", err)
+	}
+
+	m := resp.Machine
+	if m.Allocation == nil {
+		return drift{Reason: "MachineNotAllocated", Message: fmt.Sprintf("metal-stack machine %s has been freed", id)}, m, nil
+	}
+
+	var actualPartition string
+	if m.Partition != nil {
+		actualPartition = metalgo.StrDeref(m.Partition.ID)
+	}
+	actualProject := metalgo.StrDeref(m.Allocation.Project)
+	if actualPartition != partition || actualProject != project {
+		return drift{
+			Reason: "MachineMismatch",
+			Message: fmt.Sprintf("metal-stack machine %s is allocated in partition %q to project %q instead of %q and %q",
+				id, actualPartition, actualProject, partition, project),
+		}, m, nil
+	}
+	return drift{}, m, nil
+}
+
+// projectDrift checks that the project with the given id still exists and
+// belongs to the given tenant.
+func projectDrift(ctx context.Context, driver metal.Client, id, tenant string) (drift, error) {
+	resp, err := driver.ProjectGet(ctx, id)
+	if metal.IsNotFound(err) {
+		return drift{Reason: "ProjectNotFound", Message: fmt.Sprintf("metal-stack project %s does not exist anymore", id)}, nil
+	}
+	if err != nil {
+		return drift{}, fmt.Errorf("failed to fetch metal-stack project: %w", err)
+	}
+
+	if actual := resp.Project.TenantID; actual != tenant {
+		return drift{
+			Reason:  "ProjectMismatch",
+			Message: fmt.Sprintf("metal-stack project %s belongs to tenant %q instead of %q", id, actual, tenant),
+		}, nil
+	}
+	return drift{}, nil
+}
+
+// recreate reports whether drifted resources should be recreated under policy.
+func recreate(policy clusterv1.DriftPolicy) bool {
+	return policy == clusterv1.DriftPolicyRecreate
+}
+
+// markedDrifted reports whether obj was found drifted by the last check.
+func markedDrifted(obj statusObject) bool {
+	c := obj.GetCondition(clusterv1.Drifted)
+	return c != nil && c.Status == corev1.ConditionTrue
+}
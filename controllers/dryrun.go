@@ -0,0 +1,61 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/LimKianAn/xcluster/metal"
+)
+
+type dryRunCallsKey struct{}
+
+// withDryRunRecorder makes the metal-api calls the dry-run client skips while
+// reconciling obj show up as events of obj. updateStatus records them in the
+// status of obj at the end of the reconciliation, since updating obj in
+// between overwrites its status with the one on the API server.
+func withDryRunRecorder(ctx context.Context, events record.EventRecorder, obj runtime.Object) context.Context {
+	calls := &[]string{}
+	ctx = context.WithValue(ctx, dryRunCallsKey{}, calls)
+	return metal.WithCallObserver(ctx, func(call metal.Call) {
+		*calls = append(*calls, call.String())
+		if events != nil {
+			events.Eventf(obj, corev1.EventTypeNormal, "DryRun", "Skipped metal-api call %s", call)
+		}
+	})
+}
+
+// dryRunCalls returns the metal-api calls skipped so far in ctx.
+func dryRunCalls(ctx context.Context) []string {
+	if calls, ok := ctx.Value(dryRunCallsKey{}).(*[]string); ok {
+		return *calls
+	}
+	return nil
+}
+
+// realID reports whether id identifies a resource in metal-stack, i.e. it's
+// neither empty nor made up by the dry-run client. Made-up IDs are only known
+// to the dry-run client of the running manager, so they are never written
+// into a spec, and one left there by an older manager is taken as no ID at
+// all: the resource is created as if it had never been.
+func realID(id string) bool {
+	return id != "" && !metal.IsDryRunID(id)
+}
@@ -0,0 +1,79 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+)
+
+// The xnetworks are reconciled against a client of their own, since the
+// manager of the suite reconciles every xnetwork against the fake metal-api.
+var _ = Describe("Dry-run", func() {
+	var (
+		c client.Client
+		n *clusterv1.XNetwork
+	)
+
+	BeforeEach(func() {
+		c = apiServer{fakeclient.NewFakeClientWithScheme(scheme.Scheme)}
+		n = &clusterv1.XNetwork{}
+		n.Name, n.Namespace = "dry-run", "default"
+		n.Spec.Partition = testPartition
+		n.Spec.ProjectID = testProject
+	})
+
+	reconcileWith := func(driver metal.Client) {
+		r := &XNetworkReconciler{Client: c, Driver: driver, Log: ctrl.Log.WithName("dry-run").WithName("XNetwork"), Scheme: scheme.Scheme}
+		_, err := r.Reconcile(ctrl.Request{NamespacedName: keyOf(n)})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Get(context.Background(), keyOf(n), n)).To(Succeed())
+	}
+
+	It("keeps made-up IDs out of the spec", func() {
+		Expect(c.Create(context.Background(), n)).To(Succeed())
+		before := metalAPI.Networks()
+
+		reconcileWith(metal.NewDryRunClient(metalAPI, ctrl.Log.WithName("dry-run")))
+		Expect(n.Spec.NetworkID).To(BeEmpty())
+		Expect(n.Status.Allocated).To(BeFalse())
+		Expect(n.Status.DryRunCalls).To(ContainElement(HavePrefix("NetworkAllocate(")))
+		Expect(metalAPI.Networks()).To(Equal(before))
+	})
+
+	It("allocates the network of a made-up ID left in the spec", func() {
+		n.Spec.NetworkID = "dry-run-0123456789abcdef"
+		Expect(c.Create(context.Background(), n)).To(Succeed())
+
+		reconcileWith(metalAPI)
+		Expect(metal.IsDryRunID(n.Spec.NetworkID)).To(BeFalse())
+		Expect(metalAPI.Networks()).To(ContainElement(n.Spec.NetworkID))
+		Expect(n.Status.Allocated).To(BeTrue())
+
+		_, err := metalAPI.NetworkFree(context.Background(), n.Spec.NetworkID)
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
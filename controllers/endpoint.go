@@ -0,0 +1,152 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	metalgo "github.com/metal-stack/metal-go"
+	"github.com/metal-stack/metal-go/api/models"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+)
+
+// defaultAPIServerPort is the port of the control plane endpoint unless
+// specified otherwise.
+const defaultAPIServerPort = 6443
+
+// xclusterTag marks the metal-stack IPs allocated for cl, so that they are
+// found again and only those are released.
+func xclusterTag(cl *clusterv1.XCluster) string {
+	return "cluster.www.x-cellent.com/xcluster=" + cl.Namespace + "/" + cl.Name
+}
+
+// ReconcileControlPlaneEndpoint allocates the IP of the control plane
+// endpoint of cl, or validates the one given. It reports whether the endpoint
+// is ready.
+func (r *XClusterReconciler) ReconcileControlPlaneEndpoint(ctx context.Context, cl *clusterv1.XCluster, log logr.Logger) (bool, error) {
+	if cl.Spec.ControlPlaneNetworkID == "" {
+		return true, nil
+	}
+
+	ep := &cl.Spec.ControlPlaneEndpoint
+	cond := clusterv1.Condition{
+		Type:   clusterv1.ControlPlaneEndpointReady,
+		Status: corev1.ConditionTrue,
+		Reason: "Allocated",
+	}
+
+	var ip *models.V1IPResponse
+	if ep.Host != "" {
+		resp, err := r.Driver.IPGet(ctx, ep.Host)
+		if err != nil && !metal.IsNotFound(err) {
+			return false, fmt.Errorf("failed to fetch the control plane IP: %w", err)
+		}
+		if err == nil {
+			ip = resp.IP
+			if problem := invalidControlPlaneIP(cl, ip); problem != "" {
+				cond.Status = corev1.ConditionFalse
+				cond.Reason = "Invalid"
+				cond.Message = problem
+				log.Info("invalid control plane IP", "ip", ep.Host, "problem", problem)
+				return false, r.setEndpointCondition(ctx, cl, cond)
+			}
+			if !containsTag(ip.Tags, xclusterTag(cl)) {
+				cond.Reason = "Validated"
+			}
+		}
+	}
+
+	if ip == nil {
+		var err error
+		ip, err = allocateIP(ctx, r.Driver, &metalgo.IPAllocateRequest{
+			IPAddress:   ep.Host,
+			Name:        cl.Name + "-control-plane",
+			Description: "control plane endpoint of xcluster " + cl.Namespace + "/" + cl.Name,
+			Networkid:   cl.Spec.ControlPlaneNetworkID,
+			Projectid:   cl.Spec.ProjectID,
+			Type:        models.V1IPResponseTypeStatic,
+		}, xclusterTag(cl))
+		if err != nil {
+			return false, fmt.Errorf("failed to allocate the control plane IP: %w", err)
+		}
+		log.Info("control plane IP allocated", "ip", metalgo.StrDeref(ip.Ipaddress))
+	}
+
+	if ep.Host == "" || ep.Port == 0 {
+		base := cl.DeepCopy()
+		ep.Host = metalgo.StrDeref(ip.Ipaddress)
+		if ep.Port == 0 {
+			ep.Port = defaultAPIServerPort
+		}
+		if err := r.Patch(ctx, cl, client.MergeFrom(base), fieldOwner); err != nil {
+			return false, fmt.Errorf("failed to update the control plane endpoint of the xcluster: %w", err)
+		}
+	}
+
+	cond.Message = fmt.Sprintf("%s of network %s", ep.Host, cl.Spec.ControlPlaneNetworkID)
+	return true, r.setEndpointCondition(ctx, cl, cond)
+}
+
+// ReleaseControlPlaneIP frees the IP of the control plane endpoint if it was
+// allocated for cl. IPs given by the user are theirs to release.
+func (r *XClusterReconciler) ReleaseControlPlaneIP(ctx context.Context, cl *clusterv1.XCluster, log logr.Logger) error {
+	host := cl.Spec.ControlPlaneEndpoint.Host
+	if cl.Spec.ControlPlaneNetworkID == "" || host == "" {
+		return nil
+	}
+
+	released, err := releaseIP(ctx, r.Driver, host, xclusterTag(cl))
+	if err != nil {
+		return fmt.Errorf("failed to release the control plane IP: %w", err)
+	}
+	if released {
+		log.Info("control plane IP released", "ip", host)
+	}
+	return nil
+}
+
+func (r *XClusterReconciler) setEndpointCondition(ctx context.Context, cl *clusterv1.XCluster, cond clusterv1.Condition) error {
+	base := cl.DeepCopy()
+	if !cl.SetCondition(cond) {
+		return nil
+	}
+	if err := r.Status().Patch(ctx, cl, mergeFromWithLock(base), fieldOwner); err != nil {
+		return fmt.Errorf("failed to update the control plane endpoint condition of the xcluster: %w", err)
+	}
+	return nil
+}
+
+// invalidControlPlaneIP tells what's wrong with ip as the control plane IP of
+// cl, or returns "" if nothing is.
+func invalidControlPlaneIP(cl *clusterv1.XCluster, ip *models.V1IPResponse) string {
+	address := metalgo.StrDeref(ip.Ipaddress)
+	switch {
+	case metalgo.StrDeref(ip.Projectid) != cl.Spec.ProjectID:
+		return fmt.Sprintf("IP %s belongs to project %q instead of %q", address, metalgo.StrDeref(ip.Projectid), cl.Spec.ProjectID)
+	case metalgo.StrDeref(ip.Networkid) != cl.Spec.ControlPlaneNetworkID:
+		return fmt.Sprintf("IP %s belongs to network %q instead of %q", address, metalgo.StrDeref(ip.Networkid), cl.Spec.ControlPlaneNetworkID)
+	case metalgo.StrDeref(ip.Type) != models.V1IPResponseTypeStatic:
+		return fmt.Sprintf("IP %s is %s instead of static", address, metalgo.StrDeref(ip.Type))
+	}
+	return ""
+}
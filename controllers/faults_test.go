@@ -0,0 +1,146 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+	"github.com/LimKianAn/xcluster/metal/fake"
+)
+
+var _ = Describe("XCluster under metal-api faults", func() {
+	var projects int
+
+	AfterEach(func() {
+		metalAPI.ClearFaults()
+	})
+
+	// Every entry runs in a project of its own, so that the resources left
+	// behind in metal-api can be told apart.
+	table.DescribeTable("converges without leaking metal-stack resources",
+		func(faults ...fake.Fault) {
+			projects++
+			project := fmt.Sprintf("faults-%d", projects)
+			metalAPI.AddProject(project, project, "test")
+			for _, f := range faults {
+				metalAPI.Inject(f)
+			}
+
+			cl := newXCluster()
+			cl.Spec.ProjectID = project
+			waitForReady(cl)
+
+			By("creating exactly one network and one firewall")
+			fw := &clusterv1.XFirewall{}
+			Expect(k8sClient.Get(context.Background(), keyOf(cl), fw)).To(Succeed())
+			Expect(metalAPI.ResourcesOf(project)).To(ConsistOf(cl.Spec.PrivateNetworkID, fw.Spec.MachineID))
+
+			By("freeing everything once deleted")
+			Expect(k8sClient.Delete(context.Background(), cl)).To(Succeed())
+			Eventually(func() bool {
+				return errors.IsNotFound(k8sClient.Get(context.Background(), keyOf(cl), cl))
+			}, timeout, interval).Should(BeTrue())
+			Eventually(func() []string {
+				return metalAPI.ResourcesOf(project)
+			}, timeout, interval).Should(BeEmpty())
+		},
+		table.Entry("without faults"),
+		table.Entry("losing the response of the network allocation",
+			fake.Fault{Op: "NetworkAllocate", Nth: 1, Drop: true}),
+		table.Entry("losing the response of the firewall creation",
+			fake.Fault{Op: "FirewallCreate", Nth: 1, Drop: true}),
+		table.Entry("failing the first firewall creation and the second machine check",
+			fake.Fault{Op: "FirewallCreate", Nth: 1, Class: metal.Transient},
+			fake.Fault{Op: "MachineGet", Nth: 2, Class: metal.Transient}),
+		table.Entry("failing the first lookups before allocating",
+			fake.Fault{Op: "NetworkFind", Nth: 1, Class: metal.Transient},
+			fake.Fault{Op: "MachineFind", Nth: 1, Class: metal.Transient}),
+		table.Entry("delaying the allocations",
+			fake.Fault{Op: "NetworkAllocate", Delay: 500 * time.Millisecond},
+			fake.Fault{Op: "FirewallCreate", Delay: 500 * time.Millisecond}),
+		table.Entry("listing every result twice",
+			fake.Fault{Op: "NetworkFind", Duplicate: true},
+			fake.Fault{Op: "MachineFind", Duplicate: true},
+			fake.Fault{Op: "IPFind", Duplicate: true}),
+		table.Entry("losing the response of the machine deletion",
+			fake.Fault{Op: "MachineDelete", Nth: 1, Drop: true}),
+		table.Entry("losing the response of the network release",
+			fake.Fault{Op: "NetworkFree", Nth: 1, Drop: true}),
+		table.Entry("losing the first response of every change",
+			fake.Fault{Op: "NetworkAllocate", Nth: 1, Drop: true},
+			fake.Fault{Op: "FirewallCreate", Nth: 1, Drop: true},
+			fake.Fault{Op: "MachineDelete", Nth: 1, Drop: true},
+			fake.Fault{Op: "NetworkFree", Nth: 1, Drop: true}),
+	)
+
+	It("deletes a firewall whose creation response got lost", func() {
+		projects++
+		project := fmt.Sprintf("faults-%d", projects)
+		metalAPI.AddProject(project, project, "test")
+
+		// The firewall is created but its ID is lost, and it isn't found again
+		// by the lookups which follow the first one.
+		metalAPI.Inject(fake.Fault{Op: "FirewallCreate", Nth: 1, Drop: true})
+		metalAPI.Inject(fake.Fault{Op: "MachineFind", After: 1, Class: metal.Transient})
+
+		cl := newXCluster()
+		cl.Spec.ProjectID = project
+		Expect(k8sClient.Create(context.Background(), cl)).To(Succeed())
+		machines := func() []string {
+			var ids []string
+			for _, id := range metalAPI.ResourcesOf(project) {
+				if containsString(metalAPI.Machines(), id) {
+					ids = append(ids, id)
+				}
+			}
+			return ids
+		}
+		Eventually(machines, timeout, interval).Should(HaveLen(1))
+		lost := machines()[0]
+
+		By("deleting the firewall found by its tag once the xfirewall is deleted")
+		fw := &clusterv1.XFirewall{}
+		Expect(k8sClient.Get(context.Background(), keyOf(cl), fw)).To(Succeed())
+		Expect(fw.Spec.MachineID).To(BeEmpty())
+		Expect(k8sClient.Delete(context.Background(), fw)).To(Succeed())
+		Consistently(func() error {
+			return k8sClient.Get(context.Background(), keyOf(fw), fw)
+		}, time.Second, interval).Should(Succeed())
+		Expect(metalAPI.Machines()).To(ContainElement(lost))
+
+		metalAPI.ClearFaults("MachineFind")
+		Eventually(metalAPI.Machines, timeout, interval).ShouldNot(ContainElement(lost))
+
+		By("freeing everything once the xcluster is deleted")
+		Expect(k8sClient.Delete(context.Background(), cl)).To(Succeed())
+		Eventually(func() bool {
+			return errors.IsNotFound(k8sClient.Get(context.Background(), keyOf(cl), cl))
+		}, timeout, interval).Should(BeTrue())
+		Eventually(func() []string {
+			return metalAPI.ResourcesOf(project)
+		}, timeout, interval).Should(BeEmpty())
+	})
+})
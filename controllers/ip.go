@@ -0,0 +1,84 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	metalgo "github.com/metal-stack/metal-go"
+	"github.com/metal-stack/metal-go/api/models"
+
+	"github.com/LimKianAn/xcluster/metal"
+)
+
+// allocateIP allocates the IP requested by req and tags it with tag, which
+// tells whom the IP belongs to. An IP allocated with the same tag before,
+// whose address didn't make it into the resource, is reused instead.
+func allocateIP(ctx context.Context, driver metal.Client, req *metalgo.IPAllocateRequest, tag string) (*models.V1IPResponse, error) {
+	find := &metalgo.IPFindRequest{
+		ProjectID: &req.Projectid,
+		NetworkID: &req.Networkid,
+		Tags:      []string{tag},
+	}
+	if req.IPAddress != "" {
+		find.IPAddress = &req.IPAddress
+	}
+	found, err := driver.IPFind(ctx, find)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metal-stack IPs: %w", err)
+	}
+	if len(found.IPs) > 0 {
+		return found.IPs[0], nil
+	}
+
+	req.Tags = append(req.Tags, tag)
+	resp, err := driver.IPAllocate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.IP, nil
+}
+
+// releaseIP frees the IP with the given address if it is tagged with tag. It
+// reports whether it did.
+func releaseIP(ctx context.Context, driver metal.Client, address, tag string) (bool, error) {
+	resp, err := driver.IPGet(ctx, address)
+	if metal.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch metal-stack IP: %w", err)
+	}
+	if !containsTag(resp.IP.Tags, tag) {
+		return false, nil
+	}
+
+	if _, err := driver.IPFree(ctx, address); err != nil && !metal.IsNotFound(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	metalgo "github.com/metal-stack/metal-go"
+	"github.com/metal-stack/metal-go/api/models"
+
+	"github.com/LimKianAn/xcluster/metal"
+)
+
+// findMachine returns the allocated machine of the given partition and
+// project tagged with tag, which tells whom the machine belongs to, or nil if
+// there is none. It finds machines again whose ID didn't make it into the
+// resource they were created for.
+func findMachine(ctx context.Context, driver metal.Client, partition, project, tag string) (*models.V1MachineResponse, error) {
+	found, err := driver.MachineFind(ctx, &metalgo.MachineFindRequest{
+		PartitionID:       &partition,
+		AllocationProject: &project,
+		Tags:              []string{tag},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metal-stack machines: %w", err)
+	}
+	for _, m := range found.Machines {
+		if m.Allocation != nil {
+			return m, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,336 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	metalgo "github.com/metal-stack/metal-go"
+	"github.com/metal-stack/metal-go/api/models"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+)
+
+func toNetworks(ss ...string) (networks []metalgo.MachineAllocationNetwork) {
+	for _, s := range ss {
+		networks = append(networks, metalgo.MachineAllocationNetwork{
+			NetworkID:   s,
+			Autoacquire: true,
+		})
+	}
+	return
+}
+
+// withAttachments adds attachments to the networks of a machine allocation
+// and returns the IPs given explicitly.
+func withAttachments(networks []metalgo.MachineAllocationNetwork, attachments []clusterv1.NetworkAttachment) ([]metalgo.MachineAllocationNetwork, []string) {
+	var ips []string
+	for _, a := range attachments {
+		networks = append(networks, metalgo.MachineAllocationNetwork{
+			NetworkID:   a.NetworkID,
+			Autoacquire: len(a.IPs) == 0,
+		})
+		ips = append(ips, a.IPs...)
+	}
+	return networks, ips
+}
+
+// checkAttachments validates attachments against metal-api and records the
+// outcome in the NetworksValid condition of obj. It reports whether the
+// attachments are valid.
+func checkAttachments(ctx context.Context, c client.Client, driver metal.Client, recorder record.EventRecorder, obj statusObject, attachments []clusterv1.NetworkAttachment, partition, project string) (bool, error) {
+	if len(attachments) == 0 && obj.GetCondition(clusterv1.NetworksValid) == nil {
+		return true, nil
+	}
+
+	problem, err := invalidAttachments(ctx, driver, attachments, partition, project)
+	if err != nil {
+		return false, err
+	}
+
+	base := obj.DeepCopyObject()
+	cond := clusterv1.Condition{
+		Type:   clusterv1.NetworksValid,
+		Status: corev1.ConditionTrue,
+		Reason: "Validated",
+	}
+	if problem != "" {
+		cond.Status = corev1.ConditionFalse
+		cond.Reason = "Invalid"
+		cond.Message = problem
+		if recorder != nil {
+			recorder.Event(obj, corev1.EventTypeWarning, "InvalidNetworks", problem)
+		}
+	}
+	if obj.SetCondition(cond) {
+		if err := c.Status().Patch(ctx, obj, mergeFromWithLock(base), fieldOwner); err != nil {
+			return false, fmt.Errorf("failed to update the network condition: %w", err)
+		}
+	}
+	return problem == "", nil
+}
+
+// networkTagPrefix marks the additional networks a machine was created with,
+// which metal-api doesn't tell apart from the ones it adds itself.
+const networkTagPrefix = "cluster.www.x-cellent.com/network="
+
+// networkTags returns the tags marking attachments.
+func networkTags(attachments []clusterv1.NetworkAttachment) []string {
+	tags := []string{}
+	for _, a := range attachments {
+		tags = append(tags, networkTagPrefix+a.NetworkID)
+	}
+	return tags
+}
+
+// attachmentsChanged reports whether m was created with other additional
+// networks than attachments.
+func attachmentsChanged(attachments []clusterv1.NetworkAttachment, m *models.V1MachineResponse) bool {
+	attached := map[string]bool{}
+	for _, tag := range m.Tags {
+		if strings.HasPrefix(tag, networkTagPrefix) {
+			attached[strings.TrimPrefix(tag, networkTagPrefix)] = true
+		}
+	}
+	if len(attached) != len(attachments) {
+		return true
+	}
+	for _, a := range attachments {
+		if !attached[a.NetworkID] {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidAttachments tells what's wrong with attachments for a machine in the
+// given partition and project, or returns "" if nothing is.
+func invalidAttachments(ctx context.Context, driver metal.Client, attachments []clusterv1.NetworkAttachment, partition, project string) (string, error) {
+	seen := map[string]bool{}
+	for _, a := range attachments {
+		if seen[a.NetworkID] {
+			return fmt.Sprintf("network %s is attached more than once", a.NetworkID), nil
+		}
+		seen[a.NetworkID] = true
+
+		resp, err := driver.NetworkGet(ctx, a.NetworkID)
+		if metal.IsNotFound(err) {
+			return fmt.Sprintf("network %s does not exist", a.NetworkID), nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch metal-stack network: %w", err)
+		}
+		if problem := invalidNetwork(resp.Network, partition, project); problem != "" {
+			return problem, nil
+		}
+
+		for _, address := range a.IPs {
+			resp, err := driver.IPGet(ctx, address)
+			if metal.IsNotFound(err) {
+				return fmt.Sprintf("IP %s of network %s is not allocated", address, a.NetworkID), nil
+			}
+			if err != nil {
+				return "", fmt.Errorf("failed to fetch metal-stack IP: %w", err)
+			}
+			ip := resp.IP
+			switch {
+			case metalgo.StrDeref(ip.Networkid) != a.NetworkID:
+				return fmt.Sprintf("IP %s belongs to network %q instead of %q", address, metalgo.StrDeref(ip.Networkid), a.NetworkID), nil
+			case metalgo.StrDeref(ip.Projectid) != project:
+				return fmt.Sprintf("IP %s belongs to project %q instead of %q", address, metalgo.StrDeref(ip.Projectid), project), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// invalidNetwork tells why n can't be attached to a machine in the given
+// partition and project, or returns "" if it can.
+func invalidNetwork(n *models.V1NetworkResponse, partition, project string) string {
+	id := metalgo.StrDeref(n.ID)
+	switch {
+	case n.Partitionid != "" && n.Partitionid != partition:
+		return fmt.Sprintf("network %s is in partition %q instead of %q", id, n.Partitionid, partition)
+	case n.Projectid != "" && n.Projectid != project && !n.Shared:
+		return fmt.Sprintf("network %s belongs to project %q and is not shared", id, n.Projectid)
+	case n.Privatesuper != nil && *n.Privatesuper:
+		return fmt.Sprintf("network %s is a private super network", id)
+	}
+	return ""
+}
+
+// networkStatuses returns the IPs of the allocation of m per network.
+func networkStatuses(m *models.V1MachineResponse) []clusterv1.NetworkStatus {
+	if m.Allocation == nil {
+		return nil
+	}
+
+	var networks []clusterv1.NetworkStatus
+	for _, n := range m.Allocation.Networks {
+		networks = append(networks, clusterv1.NetworkStatus{
+			NetworkID: metalgo.StrDeref(n.Networkid),
+			IPs:       n.Ips,
+		})
+	}
+	return networks
+}
+
+// inUsePollInterval is how often a network to be freed is checked for the
+// machines still allocated in it, whose deletion isn't watched.
+const inUsePollInterval = 15 * time.Second
+
+// networkUsers returns the IDs of the machines and firewalls allocated in the
+// network with the given ID, which must be gone before it is freed.
+func networkUsers(ctx context.Context, driver metal.Client, id string) ([]string, error) {
+	resp, err := driver.MachineFind(ctx, &metalgo.MachineFindRequest{NetworkIDs: []string{id}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the metal-stack machines in the network: %w", err)
+	}
+	var ids []string
+	for _, m := range resp.Machines {
+		ids = append(ids, metalgo.StrDeref(m.ID))
+	}
+	return ids, nil
+}
+
+// networksInvalid reports whether the additional networks of obj were found
+// invalid by the last check.
+func networksInvalid(obj statusObject) bool {
+	c := obj.GetCondition(clusterv1.NetworksValid)
+	return c != nil && c.Status == corev1.ConditionFalse
+}
+
+// invalidPrivateNetwork tells why a private network can't be allocated in
+// partition as specified by spec, or returns "" if it can. metal-api
+// allocates IPv4 networks of the prefix length configured for the partition
+// only, which the CRD leaves to be checked against the partition.
+func invalidPrivateNetwork(ctx context.Context, driver metal.Client, spec clusterv1.PrivateNetworkSpec, partition string) (string, error) {
+	if spec.PrefixLength == nil {
+		return "", nil
+	}
+
+	resp, err := driver.PartitionGet(ctx, partition)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch metal-stack partition: %w", err)
+	}
+	if l := resp.Partition.Privatenetworkprefixlength; l != 0 && l != *spec.PrefixLength {
+		return fmt.Sprintf("partition %s allocates private networks of /%d instead of /%d", partition, l, *spec.PrefixLength), nil
+	}
+	return "", nil
+}
+
+// privateNetworkMismatch tells how n differs from spec, or returns "" if it
+// doesn't.
+func privateNetworkMismatch(spec clusterv1.PrivateNetworkSpec, n *models.V1NetworkResponse) string {
+	id := metalgo.StrDeref(n.ID)
+	for _, prefix := range n.Prefixes {
+		_, ipnet, err := net.ParseCIDR(prefix)
+		if err != nil {
+			continue
+		}
+		ones, bits := ipnet.Mask.Size()
+		if spec.PrefixLength != nil && ones != int(*spec.PrefixLength) {
+			return fmt.Sprintf("prefix %s of network %s is not /%d", prefix, id, *spec.PrefixLength)
+		}
+		if bits != 32 && (spec.AddressFamily == "" || spec.AddressFamily == clusterv1.AddressFamilyIPv4) {
+			return fmt.Sprintf("prefix %s of network %s is not IPv4", prefix, id)
+		}
+	}
+
+	if spec.NAT != nil && n.Nat != nil && *n.Nat != *spec.NAT {
+		return fmt.Sprintf("NAT of network %s is %t instead of %t", id, *n.Nat, *spec.NAT)
+	}
+	if spec.Shared && !n.Shared {
+		return fmt.Sprintf("network %s is not shared", id)
+	}
+
+	keys := make([]string, 0, len(spec.Labels))
+	for k := range spec.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if v, ok := n.Labels[k]; !ok || v != spec.Labels[k] {
+			return fmt.Sprintf("label %s of network %s is %q instead of %q", k, id, v, spec.Labels[k])
+		}
+	}
+	return ""
+}
+
+// privateNetworkCondition returns the PrivateNetworkValid condition reporting
+// problem, if any, for the given reason.
+func privateNetworkCondition(problem, reason string) clusterv1.Condition {
+	if problem == "" {
+		return clusterv1.Condition{
+			Type:   clusterv1.PrivateNetworkValid,
+			Status: corev1.ConditionTrue,
+			Reason: "Valid",
+		}
+	}
+	return clusterv1.Condition{
+		Type:    clusterv1.PrivateNetworkValid,
+		Status:  corev1.ConditionFalse,
+		Reason:  reason,
+		Message: problem,
+	}
+}
+
+// privateNetworkInvalid reports whether the private network of obj can't be
+// allocated or referenced as specified.
+func privateNetworkInvalid(obj statusObject) bool {
+	c := obj.GetCondition(clusterv1.PrivateNetworkValid)
+	return c != nil && c.Status == corev1.ConditionFalse && c.Reason == "Invalid"
+}
+
+// allocateNetwork allocates the network requested by req and labels it with
+// key=value, which tells whom the network belongs to. A network allocated
+// with the same label before, whose ID didn't make it into the resource, is
+// reused instead.
+func allocateNetwork(ctx context.Context, driver metal.Client, req *metalgo.NetworkAllocateRequest, key, value string) (*models.V1NetworkResponse, error) {
+	found, err := driver.NetworkFind(ctx, &metalgo.NetworkFindRequest{
+		PartitionID: &req.PartitionID,
+		ProjectID:   &req.ProjectID,
+		Labels:      map[string]string{key: value},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metal-stack networks: %w", err)
+	}
+	if len(found.Networks) > 0 {
+		return found.Networks[0], nil
+	}
+
+	labels := map[string]string{}
+	for k, v := range req.Labels {
+		labels[k] = v
+	}
+	labels[key] = value
+	req.Labels = labels
+	resp, err := driver.NetworkAllocate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Network, nil
+}
@@ -0,0 +1,47 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fieldOwner is the field manager of every write of the reconcilers. Objects
+// are written as merge patches of what a reconciler changed, without their
+// resourceVersion unless lists are changed, so that concurrent writes of
+// users, GitOps tools and the other reconcilers to other fields neither
+// conflict nor get clobbered.
+const fieldOwner = client.FieldOwner("xcluster-controller")
+
+// mergeFromWithLock is client.MergeFrom, but the patch carries the
+// resourceVersion of the patched object and thus fails with a conflict if the
+// object changed since base was read. A merge patch replaces lists as a
+// whole, so every patch of a list is made with it to not drop what another
+// writer added in the meantime: the finalizers and the additional networks of
+// an xfirewall in the spec, and every status patch, since the status is made
+// of lists like the conditions. base is left as is.
+func mergeFromWithLock(base runtime.Object) client.Patch {
+	// The patch is computed against a copy of base without a resourceVersion,
+	// so that it sets the one of the patched object.
+	base = base.DeepCopyObject()
+	if m, err := meta.Accessor(base); err == nil {
+		m.SetResourceVersion("")
+	}
+	return client.MergeFrom(base)
+}
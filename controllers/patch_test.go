@@ -0,0 +1,89 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+)
+
+var patched int
+
+// Patches are tested on xmachines without an owner Machine, which the
+// reconciler of the suite leaves alone.
+var _ = Describe("Patches", func() {
+	var m, stale *clusterv1.XMachine
+
+	BeforeEach(func() {
+		patched++
+		m = &clusterv1.XMachine{}
+		m.Name, m.Namespace = fmt.Sprintf("patched-%d", patched), "default"
+		m.Spec.Size, m.Spec.Image = "v1-small-x86", "ubuntu-20.04"
+		Expect(k8sClient.Create(context.Background(), m)).To(Succeed())
+		stale = m.DeepCopy()
+
+		// Someone else attaches a network in the meantime.
+		m.Spec.AdditionalNetworks = []clusterv1.NetworkAttachment{{NetworkID: "theirs"}}
+		Expect(k8sClient.Update(context.Background(), m)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(k8sClient.Delete(context.Background(), m)).To(Succeed())
+	})
+
+	It("don't conflict with changes of other fields", func() {
+		base := stale.DeepCopy()
+		stale.Spec.Tags = []string{"ours"}
+		Expect(k8sClient.Patch(context.Background(), stale, client.MergeFrom(base), fieldOwner)).To(Succeed())
+
+		Expect(k8sClient.Get(context.Background(), keyOf(m), m)).To(Succeed())
+		Expect(m.Spec.Tags).To(ConsistOf("ours"))
+		Expect(m.Spec.AdditionalNetworks).To(ConsistOf(clusterv1.NetworkAttachment{NetworkID: "theirs"}))
+	})
+
+	It("conflict with changes of the same list if locked", func() {
+		base := stale.DeepCopy()
+		stale.Spec.AdditionalNetworks = append(stale.Spec.AdditionalNetworks, clusterv1.NetworkAttachment{NetworkID: "ours"})
+		err := k8sClient.Patch(context.Background(), stale, mergeFromWithLock(base), fieldOwner)
+		Expect(errors.IsConflict(err)).To(BeTrue())
+
+		Expect(k8sClient.Get(context.Background(), keyOf(m), m)).To(Succeed())
+		Expect(m.Spec.AdditionalNetworks).To(ConsistOf(clusterv1.NetworkAttachment{NetworkID: "theirs"}))
+	})
+
+	It("conflict with other changes when patching the status if locked", func() {
+		base := stale.DeepCopy()
+		stale.SetCondition(clusterv1.Condition{Type: clusterv1.MetalAPIAvailable, Status: corev1.ConditionTrue, Reason: "Available"})
+		err := k8sClient.Status().Patch(context.Background(), stale, mergeFromWithLock(base), fieldOwner)
+		Expect(errors.IsConflict(err)).To(BeTrue())
+	})
+
+	It("leave the base of a locked patch as is", func() {
+		base := m.DeepCopy()
+		m.Spec.Tags = []string{"ours"}
+		Expect(k8sClient.Patch(context.Background(), m, mergeFromWithLock(base), fieldOwner)).To(Succeed())
+		Expect(base.ResourceVersion).ToNot(BeEmpty())
+	})
+})
@@ -0,0 +1,144 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/metal-stack/metal-go/api/models"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+)
+
+// namespaceProject returns the metal-stack project of namespace, which is
+// named by the ProjectIDAnnotation of the namespace or else provided by the
+// only xproject in it. Unless there is one, it tells why.
+func namespaceProject(ctx context.Context, c client.Client, namespace string) (id, problem string, err error) {
+	ns := &corev1.Namespace{}
+	if err := c.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil {
+		return "", "", fmt.Errorf("failed to fetch namespace: %w", err)
+	}
+	if id := ns.Annotations[clusterv1.ProjectIDAnnotation]; id != "" {
+		return id, "", nil
+	}
+
+	projects := &clusterv1.XProjectList{}
+	if err := c.List(ctx, projects, client.InNamespace(namespace)); err != nil {
+		return "", "", fmt.Errorf("failed to list xprojects: %w", err)
+	}
+	switch len(projects.Items) {
+	case 0:
+		return "", fmt.Sprintf("namespace %s has neither the annotation %s nor an xproject", namespace, clusterv1.ProjectIDAnnotation), nil
+	case 1:
+	default:
+		return "", fmt.Sprintf("namespace %s has %d xprojects instead of one", namespace, len(projects.Items)), nil
+	}
+
+	p := projects.Items[0]
+	if !p.Status.Ready || !realID(p.Spec.ProjectID) {
+		return "", fmt.Sprintf("xproject %s is not ready", p.Name), nil
+	}
+	return p.Spec.ProjectID, "", nil
+}
+
+// defaultProject sets project, the project ID in the spec of obj, to the
+// project of namespace. It reports whether it did. Otherwise the
+// ProjectResolved condition of obj tells why not.
+func defaultProject(ctx context.Context, c client.Client, recorder record.EventRecorder, obj statusObject, namespace string, project *string) (bool, error) {
+	id, problem, err := namespaceProject(ctx, c, namespace)
+	if err != nil {
+		return false, err
+	}
+	if problem != "" {
+		base := obj.DeepCopyObject()
+		if obj.SetCondition(clusterv1.Condition{
+			Type:    clusterv1.ProjectResolved,
+			Status:  corev1.ConditionFalse,
+			Reason:  "NoProject",
+			Message: problem,
+		}) {
+			if recorder != nil {
+				recorder.Event(obj, corev1.EventTypeWarning, "NoProject", problem)
+			}
+			if err := c.Status().Patch(ctx, obj, mergeFromWithLock(base), fieldOwner); err != nil {
+				return false, fmt.Errorf("failed to update the project condition: %w", err)
+			}
+		}
+		return false, nil
+	}
+
+	base := obj.DeepCopyObject()
+	*project = id
+	if err := c.Patch(ctx, obj, client.MergeFrom(base), fieldOwner); err != nil {
+		return false, fmt.Errorf("failed to default the projectID: %w", err)
+	}
+	base = obj.DeepCopyObject()
+	if obj.SetCondition(clusterv1.Condition{
+		Type:    clusterv1.ProjectResolved,
+		Status:  corev1.ConditionTrue,
+		Reason:  "Defaulted",
+		Message: fmt.Sprintf("project %s taken from namespace %s", id, namespace),
+	}) {
+		if err := c.Status().Patch(ctx, obj, mergeFromWithLock(base), fieldOwner); err != nil {
+			return false, fmt.Errorf("failed to update the project condition: %w", err)
+		}
+	}
+	return true, nil
+}
+
+// projectUnresolved reports whether the project of obj couldn't be resolved
+// by the last attempt.
+func projectUnresolved(obj statusObject) bool {
+	c := obj.GetCondition(clusterv1.ProjectResolved)
+	return c != nil && c.Status == corev1.ConditionFalse
+}
+
+// projectNamespace returns the namespace whose project obj, a namespace or an
+// xproject, determines.
+func projectNamespace(obj handler.MapObject) string {
+	if _, ok := obj.Object.(*corev1.Namespace); ok {
+		return obj.Meta.GetName()
+	}
+	return obj.Meta.GetNamespace()
+}
+
+// projectIDOf returns the ID of p.
+func projectIDOf(p *models.V1ProjectResponse) string {
+	if p == nil || p.Meta == nil {
+		return ""
+	}
+	return p.Meta.ID
+}
+
+// uniqueProjects returns projects without the ones listed more than once.
+func uniqueProjects(projects []*models.V1ProjectResponse) []*models.V1ProjectResponse {
+	seen := map[string]bool{}
+	var unique []*models.V1ProjectResponse
+	for _, p := range projects {
+		if id := projectIDOf(p); !seen[id] {
+			seen[id] = true
+			unique = append(unique, p)
+		}
+	}
+	return unique
+}
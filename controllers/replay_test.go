@@ -0,0 +1,192 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+)
+
+// xclusterCassette holds the metal-api calls made while the xcluster of
+// replayedXCluster was created and deleted. It is synthetic: it was recorded
+// against the fake metal-api of package fake rather than mini-lab, so the
+// answers are the fake's. It is to be recorded again in mini-lab by running
+// the manager with --metal-api-cassette and creating and deleting that
+// xcluster.
+var xclusterCassette = filepath.Join("testdata", "xcluster.cassette.json")
+
+// maxRounds is how often the reconcilers are run by turns before giving up.
+const maxRounds = 20
+
+// replayedXCluster returns the xcluster whose reconciliation is recorded in
+// xclusterCassette.
+func replayedXCluster() *clusterv1.XCluster {
+	cl := &clusterv1.XCluster{}
+	cl.Name = "replayed"
+	cl.Namespace = "default"
+	cl.Spec.Partition = testPartition
+	cl.Spec.ProjectID = testProject
+	cl.Spec.XFirewallTemplate.Spec.DefaultNetworkID = internetNetwork
+	cl.Spec.XFirewallTemplate.Spec.Size = "v1-small-x86"
+	cl.Spec.XFirewallTemplate.Spec.Image = "firewall-ubuntu-2.0"
+	return cl
+}
+
+// apiServer deletes objects like the API server does, unlike the fake client
+// it wraps: objects with finalizers are only marked deleted, and removed once
+// their last finalizer is.
+type apiServer struct {
+	client.Client
+}
+
+func (c apiServer) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: m.GetNamespace(), Name: m.GetName()}, obj); err != nil {
+		return err
+	}
+	if len(m.GetFinalizers()) == 0 {
+		return c.Client.Delete(ctx, obj, opts...)
+	}
+	if m.GetDeletionTimestamp() == nil {
+		now := metav1.Now()
+		m.SetDeletionTimestamp(&now)
+	}
+	return c.Client.Update(ctx, obj)
+}
+
+func (c apiServer) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	if m.GetDeletionTimestamp() != nil && len(m.GetFinalizers()) == 0 {
+		return c.Client.Delete(ctx, obj)
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c apiServer) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if err := c.Client.Patch(ctx, obj, patch, opts...); err != nil {
+		return err
+	}
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	if m.GetDeletionTimestamp() != nil && len(m.GetFinalizers()) == 0 {
+		return c.Client.Delete(ctx, obj)
+	}
+	return nil
+}
+
+// replayReconcilers returns the reconcilers of an xcluster, its xnetwork and
+// its xfirewall talking to c and driver.
+func replayReconcilers(c client.Client, driver metal.Client) []reconcile.Reconciler {
+	return []reconcile.Reconciler{
+		&XClusterReconciler{Client: c, Driver: driver, Log: ctrl.Log.WithName("replay").WithName("XCluster"), Scheme: scheme.Scheme},
+		&XNetworkReconciler{Client: c, Driver: driver, Log: ctrl.Log.WithName("replay").WithName("XNetwork"), Scheme: scheme.Scheme},
+		&XFirewallReconciler{Client: c, Driver: driver, Log: ctrl.Log.WithName("replay").WithName("XFirewall"), Scheme: scheme.Scheme},
+	}
+}
+
+// reconcileByTurns runs the reconcilers for the objects named like cl by
+// turns until done reports true. Errors are retried by the next round like
+// the work queue would.
+func reconcileByTurns(reconcilers []reconcile.Reconciler, cl *clusterv1.XCluster, done func() (bool, error)) error {
+	req := ctrl.Request{NamespacedName: keyOf(cl)}
+	for round := 0; round < maxRounds; round++ {
+		for _, r := range reconcilers {
+			_, _ = r.Reconcile(req)
+		}
+		ok, err := done()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("not done after %d rounds", maxRounds)
+}
+
+// createAndDelete creates cl, reconciles it until it is ready, deletes it
+// and reconciles it until it and its xnetwork and xfirewall are gone.
+func createAndDelete(c client.Client, driver metal.Client, cl *clusterv1.XCluster) error {
+	ctx := context.Background()
+	reconcilers := replayReconcilers(c, driver)
+
+	if err := c.Create(ctx, cl); err != nil {
+		return fmt.Errorf("failed to create xcluster: %w", err)
+	}
+	if err := reconcileByTurns(reconcilers, cl, func() (bool, error) {
+		err := c.Get(ctx, keyOf(cl), cl)
+		return cl.Status.Ready, err
+	}); err != nil {
+		return fmt.Errorf("xcluster not ready: %w", err)
+	}
+
+	if err := c.Delete(ctx, cl); err != nil {
+		return fmt.Errorf("failed to delete xcluster: %w", err)
+	}
+	if err := reconcileByTurns(reconcilers, cl, func() (bool, error) {
+		for _, obj := range []runtime.Object{&clusterv1.XCluster{}, &clusterv1.XNetwork{}, &clusterv1.XFirewall{}} {
+			if err := c.Get(ctx, keyOf(cl), obj); client.IgnoreNotFound(err) != nil || err == nil {
+				return false, client.IgnoreNotFound(err)
+			}
+		}
+		return true, nil
+	}); err != nil {
+		return fmt.Errorf("xcluster not deleted: %w", err)
+	}
+	return nil
+}
+
+var _ = Describe("XCluster replaying recorded metal-api calls", func() {
+	It("makes the recorded calls and no others", func() {
+		cassette, err := metal.LoadCassette(xclusterCassette)
+		Expect(err).ToNot(HaveOccurred())
+		replay, err := metal.NewReplayClient(cassette)
+		Expect(err).ToNot(HaveOccurred())
+
+		// The reconcilers run by turns against a client of their own, since the
+		// manager of the suite reconciles every xcluster against the fake
+		// metal-api.
+		c := apiServer{fakeclient.NewFakeClientWithScheme(scheme.Scheme)}
+		Expect(createAndDelete(c, replay, replayedXCluster())).To(Succeed())
+
+		Expect(replay.Unexpected()).To(BeEmpty())
+		Expect(replay.Pending()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,95 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+)
+
+// statusObject is implemented by XCluster and XFirewall.
+type statusObject interface {
+	runtime.Object
+	GetCondition(clusterv1.ConditionType) *clusterv1.Condition
+	SetCondition(clusterv1.Condition) bool
+	SetPhase(clusterv1.Phase) bool
+	RecordDryRunCall(string) bool
+}
+
+// updateStatus records the outcome of the reconciliation which returned result
+// and err in the status of obj: its phase, whether metal-api answered and
+// which metal-api calls were skipped in dry-run mode.
+// While the circuit breaker around metal-api is open, the request is requeued
+// once the breaker lets calls through again instead of going through the
+// rate-limited work queue.
+func updateStatus(ctx context.Context, c client.Client, obj statusObject, phase clusterv1.Phase, result ctrl.Result, err error) (ctrl.Result, error) {
+	base := obj.DeepCopyObject()
+	changed := obj.SetPhase(phase)
+	for _, call := range dryRunCalls(ctx) {
+		if obj.RecordDryRunCall(call) {
+			changed = true
+		}
+	}
+
+	cond := clusterv1.Condition{
+		Type:   clusterv1.MetalAPIAvailable,
+		Status: corev1.ConditionTrue,
+		Reason: "Available",
+	}
+
+	var merr *metal.Error
+	switch {
+	case err != nil && !errors.As(err, &merr):
+		// Not caused by metal-api, so there's nothing to tell about it.
+		cond = clusterv1.Condition{}
+	case errors.Is(err, metal.ErrCircuitOpen):
+		cond.Status = corev1.ConditionFalse
+		cond.Reason = "CircuitOpen"
+		cond.Message = err.Error()
+		result, err = ctrl.Result{RequeueAfter: merr.RetryAfter}, nil
+	case merr != nil && merr.Class == metal.Transient:
+		cond.Status = corev1.ConditionFalse
+		cond.Reason = "Unavailable"
+		cond.Message = err.Error()
+	}
+	if cond.Type != "" && obj.SetCondition(cond) {
+		changed = true
+	}
+
+	if !changed {
+		return result, err
+	}
+	if uerr := c.Status().Patch(ctx, obj, mergeFromWithLock(base), fieldOwner); client.IgnoreNotFound(uerr) != nil && err == nil {
+		err = fmt.Errorf("failed to update the status: %w", uerr)
+	}
+	return result, err
+}
+
+// failedPermanently reports whether metal-api rejected a request for good.
+func failedPermanently(err error) bool {
+	var merr *metal.Error
+	return errors.As(err, &merr) && merr.Class == metal.Permanent
+}
@@ -20,10 +20,12 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/metal-stack/metal-go/api/models"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
@@ -31,6 +33,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal/fake"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -41,6 +44,19 @@ var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
 
+// metalAPI is the fake metal-api the reconcilers talk to.
+var metalAPI *fake.Client
+
+// stopManager stops the manager running the reconcilers.
+var stopManager chan struct{}
+
+const (
+	testPartition = "vagrant"
+	testProject   = "00000000-0000-0000-0000-000000000000"
+	// internetNetwork is the external network firewalls are attached to.
+	internetNetwork = "internet"
+)
+
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
 
@@ -54,7 +70,11 @@ var _ = BeforeSuite(func(done Done) {
 
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
-		CRDDirectoryPaths: []string{filepath.Join("..", "config", "crd", "bases")},
+		CRDDirectoryPaths: []string{
+			filepath.Join("..", "config", "crd", "bases"),
+			// XMachines are owned by the Machines of Cluster API.
+			filepath.Join("testdata", "crd"),
+		},
 	}
 
 	var err error
@@ -65,20 +85,100 @@ var _ = BeforeSuite(func(done Done) {
 	err = clusterv1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
 
-	err = clusterv1.AddToScheme(scheme.Scheme)
-	Expect(err).NotTo(HaveOccurred())
-
 	// +kubebuilder:scaffold:scheme
 
 	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
 	Expect(err).ToNot(HaveOccurred())
 	Expect(k8sClient).ToNot(BeNil())
 
+	By("starting the reconcilers against a fake metal-api")
+	metalAPI = fake.New()
+	metalAPI.AddPartition(testPartition, fake.DefaultPrefixLength)
+	metalAPI.AddProject(testProject, "test", "test")
+	id := internetNetwork
+	metalAPI.AddNetwork(&models.V1NetworkResponse{ID: &id, Name: internetNetwork})
+
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:             scheme.Scheme,
+		MetricsBindAddress: "0",
+	})
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&XClusterReconciler{
+		Client: mgr.GetClient(),
+		Driver: metalAPI,
+		Log:    ctrl.Log.WithName("controllers").WithName("XCluster"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&XFirewallReconciler{
+		Client: mgr.GetClient(),
+		Driver: metalAPI,
+		Log:    ctrl.Log.WithName("controllers").WithName("XFirewall"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	// XClusters allocate their private networks by XNetworks.
+	err = (&XNetworkReconciler{
+		Client: mgr.GetClient(),
+		Driver: metalAPI,
+		Log:    ctrl.Log.WithName("controllers").WithName("XNetwork"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&XMachineReconciler{
+		Client: mgr.GetClient(),
+		Driver: metalAPI,
+		Log:    ctrl.Log.WithName("controllers").WithName("XMachine"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&XIPClaimReconciler{
+		Client: mgr.GetClient(),
+		Driver: metalAPI,
+		Log:    ctrl.Log.WithName("controllers").WithName("XIPClaim"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&XNetworkPeeringReconciler{
+		Client: mgr.GetClient(),
+		Driver: metalAPI,
+		Log:    ctrl.Log.WithName("controllers").WithName("XNetworkPeering"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&XProjectReconciler{
+		Client: mgr.GetClient(),
+		Driver: metalAPI,
+		Log:    ctrl.Log.WithName("controllers").WithName("XProject"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	stopManager = make(chan struct{})
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(stopManager)).To(Succeed())
+	}()
+
 	close(done)
 }, 60)
 
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
-	err := testEnv.Stop()
-	Expect(err).ToNot(HaveOccurred())
+	// BeforeSuite may have failed before the manager or the environment
+	// were started.
+	if stopManager != nil {
+		close(stopManager)
+	}
+	if testEnv != nil {
+		err := testEnv.Stop()
+		Expect(err).ToNot(HaveOccurred())
+	}
 })
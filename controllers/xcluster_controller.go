@@ -19,34 +19,67 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
 	metalgo "github.com/metal-stack/metal-go"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+	"github.com/LimKianAn/xcluster/tracing"
 	"k8s.io/apimachinery/pkg/api/errors"
 )
 
+// readinessPollInterval is how often an xcluster which isn't ready is
+// reconciled in case the watch event of its xnetwork or xfirewall got lost.
+// Normally these events trigger the next reconciliation right away.
+const readinessPollInterval = time.Minute
+
 // XClusterReconciler reconciles a XCluster object
 type XClusterReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
-	Driver *metalgo.Driver
+	Driver metal.Client
+
+	// Recorder, if set, receives an event for every metal-api call skipped in
+	// dry-run mode and for every drift.
+	Recorder record.EventRecorder
+
+	// ResyncPeriod is how often the private network of a ready xcluster is
+	// checked for drift. Zero disables the periodic check.
+	ResyncPeriod time.Duration
+
+	// MaxConcurrentReconciles is how many xclusters are reconciled at once.
+	// Zero means one.
+	MaxConcurrentReconciles int
 }
 
 // +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xclusters,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xclusters/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xfirewalls,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xfirewalls/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xprojects,verbs=get;list;watch
 
-func (r *XClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	ctx := context.Background()
-	log := r.Log.WithValues("xcluster", req.NamespacedName)
+func (r *XClusterReconciler) Reconcile(req ctrl.Request) (result ctrl.Result, err error) {
+	ctx, span := tracing.Start(context.Background(), "XCluster.Reconcile", tracing.String("xcluster", req.NamespacedName.String()))
+	defer func() { span.RecordError(err); span.End() }()
+	log := tracing.Logger(ctx, r.Log.WithValues("xcluster", req.NamespacedName))
 
 	// Fetch XCluster instance
 	cl := &clusterv1.XCluster{}
@@ -54,34 +87,75 @@ func (r *XClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// Honor Cluster API: nothing is done while the xcluster or its owner
+	// Cluster is paused.
+	owner, err := ownerCluster(ctx, r, cl)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if isPaused(owner, cl) {
+		log.Info("reconciliation paused")
+		return ctrl.Result{RequeueAfter: pausedPollInterval}, nil
+	}
+	if owner != nil {
+		log = log.WithValues("cluster", owner.GetName())
+	}
+
+	ctx = withDryRunRecorder(ctx, r.Recorder, cl)
+	defer func() { result, err = updateStatus(ctx, r, cl, xclusterPhase(cl, err), result, err) }()
+
 	if cl.IsBeingDeleted() {
 		return r.ReconcileDeletion(ctx, cl, log)
 	}
 
 	// Add finalizer if none.
 	if !cl.HasFinalizer(clusterv1.XFirewallFinalizer) {
+		base := cl.DeepCopy()
 		cl.AddFinalizer(clusterv1.XFirewallFinalizer)
-		if err := r.Update(ctx, cl); err != nil {
+		if err := r.Patch(ctx, cl, mergeFromWithLock(base), fieldOwner); err != nil {
 			return ctrl.Result{}, fmt.Errorf("failed to update xfirewall finalizer: %w", err)
 		}
 		r.Log.Info("finalizer added")
 	}
 
-	if cl.Spec.PrivateNetworkID == "" {
-		resp, err := r.Driver.NetworkAllocate(&metalgo.NetworkAllocateRequest{
-			Name:        cl.Spec.Partition,
-			PartitionID: cl.Spec.Partition,
-			ProjectID:   cl.Spec.ProjectID,
-		})
+	if !realID(cl.Spec.ProjectID) {
+		if ok, err := defaultProject(ctx, r, r.Recorder, cl, cl.Namespace, &cl.Spec.ProjectID); err != nil || !ok {
+			// Updates of the namespace or its xproject trigger the next reconciliation.
+			return ctrl.Result{}, err
+		}
+		log.Info("projectID defaulted", "project", cl.Spec.ProjectID)
+	}
+
+	if cl.Spec.NetworkRef == nil && !realID(cl.Spec.PrivateNetworkID) {
+		if err := r.CreateXNetwork(ctx, cl, log); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if cl.Spec.NetworkRef != nil {
+		if ready, err := r.ReconcileNetworkRef(ctx, cl, log); err != nil || !ready {
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			// Updates of the xnetwork trigger the next reconciliation.
+			return ctrl.Result{RequeueAfter: readinessPollInterval}, r.markNotReady(ctx, cl)
+		}
+	} else {
+		drifted, err := r.checkNetwork(ctx, cl, log)
 		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("failed to allocate metal-stack network-ID: %w", err)
+			return ctrl.Result{}, err
 		}
-		log.Info("private metal-stack network-ID allocated")
+		if drifted {
+			return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+		}
+	}
 
-		cl.Spec.PrivateNetworkID = *resp.Network.ID
-		if err := r.Update(ctx, cl); err != nil {
-			return ctrl.Result{}, fmt.Errorf("failed to update the privateNetworkID of the xcluster: %v", err)
+	if ready, err := r.ReconcileControlPlaneEndpoint(ctx, cl, log); err != nil || !ready {
+		if err != nil {
+			return ctrl.Result{}, err
 		}
+		// An invalid endpoint has to be fixed in the spec, which triggers the next reconciliation.
+		return ctrl.Result{}, r.markNotReady(ctx, cl)
 	}
 
 	fw := &clusterv1.XFirewall{}
@@ -99,50 +173,326 @@ func (r *XClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			return ctrl.Result{}, fmt.Errorf("failed to set the owner reference of the xfirewall: %w", err)
 		}
 
-		if err := r.Create(ctx, fw); err != nil {
+		if err := r.Create(ctx, fw, fieldOwner); err != nil {
 			return ctrl.Result{}, fmt.Errorf("failed to create xfirewall: %w", err)
 		}
 	}
-	if !fw.Status.Ready {
-		return ctrl.Result{Requeue: true}, nil
+	if fw.IsBeingDeleted() || !fw.Status.Ready {
+		// Updates of the owned xfirewall trigger the next reconciliation, also
+		// once it is gone.
+		log.Info("waiting for the xfirewall to be ready")
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, r.markNotReady(ctx, cl)
 	}
 
-	cl.Status.Ready = true
-	if err := r.Status().Update(ctx, cl); err != nil {
-		return ctrl.Result{}, fmt.Errorf("failed to update the readiness of the xcluster: %v", err)
+	failureDomains := clusterv1.FailureDomains{
+		cl.Spec.Partition: clusterv1.FailureDomainSpec{ControlPlane: true},
+	}
+	if !cl.Status.Ready || !equality.Semantic.DeepEqual(failureDomains, cl.Status.FailureDomains) {
+		base := cl.DeepCopy()
+		cl.Status.Ready = true
+		cl.Status.FailureDomains = failureDomains
+		if err := r.Status().Patch(ctx, cl, mergeFromWithLock(base), fieldOwner); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update the readiness of the xcluster: %w", err)
+		}
+		log.Info("xcluster ready")
 	}
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+}
+
+// markNotReady flips cl back to not ready, e.g. because its xnetwork or its
+// xfirewall isn't ready anymore.
+func (r *XClusterReconciler) markNotReady(ctx context.Context, cl *clusterv1.XCluster) error {
+	if !cl.Status.Ready {
+		return nil
+	}
+	base := cl.DeepCopy()
+	cl.Status.Ready = false
+	if err := r.Status().Patch(ctx, cl, mergeFromWithLock(base), fieldOwner); err != nil {
+		return fmt.Errorf("failed to update the readiness of the xcluster: %w", err)
+	}
+	return nil
+}
+
+// CreateXNetwork creates the xnetwork providing the private network of cl and
+// references it.
+func (r *XClusterReconciler) CreateXNetwork(ctx context.Context, cl *clusterv1.XCluster, log logr.Logger) error {
+	n := cl.ToXNetwork()
+
+	// cl is the owner of n. Once cl is deleted, so is n.
+	if err := controllerutil.SetControllerReference(cl, n, r.Scheme); err != nil {
+		return fmt.Errorf("failed to set the owner reference of the xnetwork: %w", err)
+	}
+	if err := r.Create(ctx, n, fieldOwner); err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create xnetwork: %w", err)
+	}
+	log.Info("xnetwork created")
+
+	base := cl.DeepCopy()
+	cl.Spec.NetworkRef = &corev1.LocalObjectReference{Name: n.Name}
+	if err := r.Patch(ctx, cl, client.MergeFrom(base), fieldOwner); err != nil {
+		return fmt.Errorf("failed to update the networkRef of the xcluster: %w", err)
+	}
+	return nil
+}
+
+// syncXNetwork carries changes of spec.privateNetwork and spec.driftPolicy of
+// cl over to the xnetwork n it owns. The xnetwork reconciler then treats a
+// network which no longer matches its spec as drifted.
+func (r *XClusterReconciler) syncXNetwork(ctx context.Context, cl *clusterv1.XCluster, n *clusterv1.XNetwork, log logr.Logger) error {
+	want := cl.ToXNetwork()
+	if equality.Semantic.DeepEqual(want.Spec.PrivateNetworkSpec, n.Spec.PrivateNetworkSpec) && want.Spec.DriftPolicy == n.Spec.DriftPolicy {
+		return nil
+	}
+	base := n.DeepCopy()
+	n.Spec.PrivateNetworkSpec = want.Spec.PrivateNetworkSpec
+	n.Spec.DriftPolicy = want.Spec.DriftPolicy
+	if err := r.Patch(ctx, n, client.MergeFrom(base), fieldOwner); err != nil {
+		return fmt.Errorf("failed to update the spec of the xnetwork: %w", err)
+	}
+	log.Info("xnetwork spec updated")
+	return nil
+}
+
+// ReconcileNetworkRef takes the private network of cl from the referenced
+// xnetwork once it is ready. If the xnetwork allocated another network, e.g.
+// because the old one drifted, the xfirewall attached to the old one is
+// deleted to be created anew. It reports whether the network is ready.
+func (r *XClusterReconciler) ReconcileNetworkRef(ctx context.Context, cl *clusterv1.XCluster, log logr.Logger) (bool, error) {
+	n := &clusterv1.XNetwork{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: cl.Namespace, Name: cl.Spec.NetworkRef.Name}, n); err != nil {
+		if !errors.IsNotFound(err) {
+			return false, fmt.Errorf("failed to fetch xnetwork instance: %w", err)
+		}
+		log.Info("waiting for the xnetwork")
+		return false, nil
+	}
+
+	var problem string
+	switch {
+	case n.Spec.Partition != cl.Spec.Partition:
+		problem = fmt.Sprintf("xnetwork %s is in partition %q instead of %q", n.Name, n.Spec.Partition, cl.Spec.Partition)
+	case n.Spec.ProjectID != cl.Spec.ProjectID && !n.Spec.Shared:
+		problem = fmt.Sprintf("xnetwork %s belongs to project %q and is not shared", n.Name, n.Spec.ProjectID)
+	}
+	if problem != "" {
+		log.Info("invalid network reference", "problem", problem)
+		if r.Recorder != nil {
+			r.Recorder.Event(cl, corev1.EventTypeWarning, "InvalidPrivateNetwork", problem)
+		}
+		base := cl.DeepCopy()
+		if cl.SetCondition(privateNetworkCondition(problem, "Invalid")) {
+			if err := r.Status().Patch(ctx, cl, mergeFromWithLock(base), fieldOwner); err != nil {
+				return false, fmt.Errorf("failed to update the private network condition of the xcluster: %w", err)
+			}
+		}
+		return false, nil
+	}
+
+	if metav1.IsControlledBy(n, cl) {
+		if err := r.syncXNetwork(ctx, cl, n, log); err != nil {
+			return false, err
+		}
+	}
+
+	if n.IsBeingDeleted() || !n.Status.Ready || !realID(n.Spec.NetworkID) {
+		log.Info("waiting for the xnetwork to be ready")
+		return false, nil
+	}
+
+	if cl.Spec.PrivateNetworkID != n.Spec.NetworkID {
+		if cl.Spec.PrivateNetworkID != "" {
+			if err := r.Delete(ctx, cl.ToXFirewall()); client.IgnoreNotFound(err) != nil {
+				return false, fmt.Errorf("failed to delete the xfirewall of the replaced network: %w", err)
+			}
+			log.Info("xfirewall of the replaced network deleted", "network", cl.Spec.PrivateNetworkID)
+		}
+		base := cl.DeepCopy()
+		cl.Spec.PrivateNetworkID = n.Spec.NetworkID
+		if err := r.Patch(ctx, cl, client.MergeFrom(base), fieldOwner); err != nil {
+			return false, fmt.Errorf("failed to update the privateNetworkID of the xcluster: %w", err)
+		}
+	}
+
+	base := cl.DeepCopy()
+	changed := cl.SetCondition(privateNetworkCondition("", ""))
+	if !equality.Semantic.DeepEqual(n.Status.Prefixes, cl.Status.PrivateNetworkPrefixes) {
+		cl.Status.PrivateNetworkPrefixes = n.Status.Prefixes
+		changed = true
+	}
+	if changed {
+		if err := r.Status().Patch(ctx, cl, mergeFromWithLock(base), fieldOwner); err != nil {
+			return false, fmt.Errorf("failed to update the private network of the xcluster: %w", err)
+		}
+	}
+	return true, nil
+}
+
+// checkNetwork marks cl Drifted if its private network vanished or changed out
+// of band. Under the Recreate policy it drops the network and deletes the
+// xfirewall attached to it, so that both are created anew. Otherwise it
+// records the prefixes of the network and whether it still matches the spec.
+func (r *XClusterReconciler) checkNetwork(ctx context.Context, cl *clusterv1.XCluster, log logr.Logger) (drifted bool, err error) {
+	d, n, err := networkDrift(ctx, r.Driver, cl.Spec.PrivateNetworkID, cl.Spec.Partition, cl.Spec.ProjectID)
+	if err != nil {
+		return false, err
+	}
+
+	base := cl.DeepCopy()
+	changed := cl.SetCondition(d.condition())
+	if !d.drifted() {
+		if !equality.Semantic.DeepEqual(n.Prefixes, cl.Status.PrivateNetworkPrefixes) {
+			cl.Status.PrivateNetworkPrefixes = n.Prefixes
+			changed = true
+		}
+		mismatch := privateNetworkMismatch(cl.Spec.PrivateNetwork, n)
+		if cl.SetCondition(privateNetworkCondition(mismatch, "Mismatch")) {
+			changed = true
+			if mismatch != "" && r.Recorder != nil {
+				r.Recorder.Event(cl, corev1.EventTypeWarning, "PrivateNetworkMismatch", mismatch)
+			}
+		}
+	}
+	if d.drifted() && cl.Status.Ready {
+		cl.Status.Ready = false
+		changed = true
+	}
+	if changed {
+		if err := r.Status().Patch(ctx, cl, mergeFromWithLock(base), fieldOwner); err != nil {
+			return false, fmt.Errorf("failed to update the drift of the xcluster: %w", err)
+		}
+	}
+	if !d.drifted() {
+		return false, nil
+	}
+
+	log.Info("private metal-stack network drifted", "reason", d.Reason, "policy", cl.Spec.DriftPolicy)
+	if r.Recorder != nil {
+		r.Recorder.Event(cl, corev1.EventTypeWarning, d.Reason, d.Message)
+	}
+	if !recreate(cl.Spec.DriftPolicy) {
+		return true, nil
+	}
+
+	if err := r.Delete(ctx, cl.ToXFirewall()); client.IgnoreNotFound(err) != nil {
+		return false, fmt.Errorf("failed to delete the xfirewall of the drifted network: %w", err)
+	}
+	base = cl.DeepCopy()
+	cl.Spec.PrivateNetworkID = ""
+	if err := r.Patch(ctx, cl, client.MergeFrom(base), fieldOwner); err != nil {
+		return false, fmt.Errorf("failed to reset the privateNetworkID of the xcluster: %w", err)
+	}
+	log.Info("drifted private metal-stack network dropped to be recreated")
+
+	return true, nil
+}
+
+// deletePeerings deletes the xnetworkpeerings involving cl. It reports
+// whether any are left.
+func (r *XClusterReconciler) deletePeerings(ctx context.Context, cl *clusterv1.XCluster) (bool, error) {
+	peerings := &clusterv1.XNetworkPeeringList{}
+	if err := r.List(ctx, peerings, client.InNamespace(cl.Namespace)); err != nil {
+		return false, fmt.Errorf("failed to list xnetworkpeerings: %w", err)
+	}
+
+	var left bool
+	for i := range peerings.Items {
+		p := &peerings.Items[i]
+		if !p.Involves(cl.Name) {
+			continue
+		}
+		left = true
+		if p.IsBeingDeleted() {
+			continue
+		}
+		if err := r.Delete(ctx, p); client.IgnoreNotFound(err) != nil {
+			return false, fmt.Errorf("failed to delete xnetworkpeering %s: %w", p.Name, err)
+		}
+	}
+	return left, nil
+}
+
+// deleteOwnedXNetwork deletes the xnetwork referenced by cl if cl owns it.
+// The xnetwork frees the network itself. Referenced xnetworks are left alone.
+func (r *XClusterReconciler) deleteOwnedXNetwork(ctx context.Context, cl *clusterv1.XCluster, log logr.Logger) error {
+	n := &clusterv1.XNetwork{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: cl.Namespace, Name: cl.Spec.NetworkRef.Name}, n); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(n, cl) {
+		return nil
+	}
+	if err := r.Delete(ctx, n); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete xnetwork: %w", err)
+	}
+	log.Info("xnetwork deleted")
+	return nil
 }
 
 func (r *XClusterReconciler) ReconcileDeletion(ctx context.Context, cl *clusterv1.XCluster, log logr.Logger) (ctrl.Result, error) {
+	// Peerings are torn down first, so that no firewall of another cluster
+	// is attached to the private network anymore once it's freed.
+	if peered, err := r.deletePeerings(ctx, cl); err != nil || peered {
+		if peered {
+			log.Info("waiting for the xnetworkpeerings to be deleted")
+		}
+		return ctrl.Result{RequeueAfter: peeringPollInterval}, err
+	}
+
+	// The firewall is allocated in the private network, so the network is
+	// only freed once the firewall is gone.
 	if err := r.Delete(ctx, cl.ToXFirewall()); client.IgnoreNotFound(err) != nil {
 		return ctrl.Result{}, fmt.Errorf("failed to delete xfirewall: %w", err)
 	}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: cl.Namespace, Name: cl.Name}, &clusterv1.XFirewall{}); !errors.IsNotFound(err) {
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to fetch xfirewall instance: %w", err)
+		}
+		// The deletion of the owned xfirewall triggers the next reconciliation.
+		log.Info("waiting for the xfirewall to be deleted")
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, nil
+	}
 	log.Info("xfirewall deleted")
 
-	// todo: A better solution would be asking metal-api if this network is occupied before freeing the network.
-	resp, err := r.Driver.NetworkFind(&metalgo.NetworkFindRequest{
-		ID:        &cl.Spec.PrivateNetworkID,
-		Name:      &cl.Spec.Partition,
-		ProjectID: &cl.Spec.ProjectID,
-	})
-
-	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("failed to list metal-stack networks: %w", err)
+	if err := r.ReleaseControlPlaneIP(ctx, cl, log); err != nil {
+		return ctrl.Result{}, err
 	}
 
-	if len := len(resp.Networks); len > 1 {
-		return ctrl.Result{}, fmt.Errorf("more than one network listed: %w", err)
-	} else if len == 1 {
-		if _, err := r.Driver.NetworkFree(cl.Spec.PrivateNetworkID); err != nil {
-			return ctrl.Result{Requeue: true}, nil
+	if cl.Spec.NetworkRef != nil {
+		if err := r.deleteOwnedXNetwork(ctx, cl, log); err != nil {
+			return ctrl.Result{}, err
+		}
+	} else if realID(cl.Spec.PrivateNetworkID) {
+		users, err := networkUsers(ctx, r.Driver, cl.Spec.PrivateNetworkID)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if len(users) > 0 {
+			log.Info("waiting for the machines in the metal-stack network to be deleted", "machines", users)
+			return ctrl.Result{RequeueAfter: inUsePollInterval}, nil
+		}
+		resp, err := r.Driver.NetworkFind(ctx, &metalgo.NetworkFindRequest{
+			ID:        &cl.Spec.PrivateNetworkID,
+			Name:      &cl.Spec.Partition,
+			ProjectID: &cl.Spec.ProjectID,
+		})
+
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to list metal-stack networks: %w", err)
+		}
+
+		// The network is found by its ID, so every network listed is the same one.
+		if len(resp.Networks) > 0 {
+			if _, err := r.Driver.NetworkFree(ctx, cl.Spec.PrivateNetworkID); err != nil && !metal.IsNotFound(err) {
+				return ctrl.Result{}, fmt.Errorf("failed to free metal-stack network: %w", err)
+			}
 		}
+		log.Info("metal-stack network freed")
 	}
-	log.Info("metal-stack network freed")
 
+	base := cl.DeepCopy()
 	cl.RemoveFinalizer(clusterv1.XFirewallFinalizer)
-	if err := r.Update(ctx, cl); err != nil {
+	if err := r.Patch(ctx, cl, mergeFromWithLock(base), fieldOwner); err != nil {
 		return ctrl.Result{}, fmt.Errorf("failed to remove xcluster finalizer: %w", err)
 	}
 	r.Log.Info("finalizer removed")
@@ -150,9 +500,74 @@ func (r *XClusterReconciler) ReconcileDeletion(ctx context.Context, cl *clusterv
 	return ctrl.Result{}, nil
 }
 
+// xclusterPhase derives the phase of cl after a reconciliation which returned err.
+func xclusterPhase(cl *clusterv1.XCluster, err error) clusterv1.Phase {
+	switch {
+	case cl.IsBeingDeleted():
+		return clusterv1.PhaseDeleting
+	case failedPermanently(err), privateNetworkInvalid(cl):
+		return clusterv1.PhaseFailed
+	case markedDrifted(cl):
+		return clusterv1.PhaseDrifted
+	case cl.Status.Ready:
+		return clusterv1.PhaseReady
+	case !cl.HasFinalizer(clusterv1.XFirewallFinalizer), !realID(cl.Spec.ProjectID):
+		return clusterv1.PhasePending
+	case !realID(cl.Spec.PrivateNetworkID):
+		return clusterv1.PhaseAllocatingNetwork
+	default:
+		return clusterv1.PhaseProvisioningFirewall
+	}
+}
+
+// clustersOf maps an xnetwork to the xclusters referencing it.
+func (r *XClusterReconciler) clustersOf(obj handler.MapObject) []reconcile.Request {
+	clusters := &clusterv1.XClusterList{}
+	if err := r.List(context.Background(), clusters, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "failed to list xclusters", "xnetwork", obj.Meta.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, cl := range clusters.Items {
+		if ref := cl.Spec.NetworkRef; ref != nil && ref.Name == obj.Meta.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: cl.Namespace, Name: cl.Name}})
+		}
+	}
+	return requests
+}
+
+// clustersWithoutProject maps a namespace or an xproject to the xclusters in
+// the namespace waiting for their projectID to be defaulted.
+func (r *XClusterReconciler) clustersWithoutProject(obj handler.MapObject) []reconcile.Request {
+	clusters := &clusterv1.XClusterList{}
+	if err := r.List(context.Background(), clusters, client.InNamespace(projectNamespace(obj))); err != nil {
+		r.Log.Error(err, "failed to list xclusters", "namespace", projectNamespace(obj))
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, cl := range clusters.Items {
+		if !realID(cl.Spec.ProjectID) {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: cl.Namespace, Name: cl.Name}})
+		}
+	}
+	return requests
+}
+
 func (r *XClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&clusterv1.XCluster{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Owns(&clusterv1.XFirewall{}).
+		Watches(&source.Kind{Type: &clusterv1.XNetwork{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.clustersOf),
+		}).
+		Watches(&source.Kind{Type: &clusterv1.XProject{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.clustersWithoutProject),
+		}).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.clustersWithoutProject),
+		}).
 		Complete(r)
 }
@@ -0,0 +1,305 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metalgo "github.com/metal-stack/metal-go"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+)
+
+const (
+	timeout  = 10 * time.Second
+	interval = 100 * time.Millisecond
+)
+
+var clusters int
+
+// newXCluster returns an xcluster with a unique name, whose private network
+// is allocated by an xnetwork.
+func newXCluster() *clusterv1.XCluster {
+	clusters++
+	cl := &clusterv1.XCluster{}
+	cl.Name = fmt.Sprintf("xcluster-%d", clusters)
+	cl.Namespace = "default"
+	cl.Spec.Partition = testPartition
+	cl.Spec.ProjectID = testProject
+	cl.Spec.XFirewallTemplate.Spec.DefaultNetworkID = internetNetwork
+	cl.Spec.XFirewallTemplate.Spec.Size = "v1-small-x86"
+	cl.Spec.XFirewallTemplate.Spec.Image = "firewall-ubuntu-2.0"
+	return cl
+}
+
+func keyOf(obj metav1.Object) types.NamespacedName {
+	return types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+}
+
+// conditionStatus returns the status of the condition of type t of obj, or ""
+// if there is none.
+func conditionStatus(obj statusObject, t clusterv1.ConditionType) corev1.ConditionStatus {
+	if c := obj.GetCondition(t); c != nil {
+		return c.Status
+	}
+	return ""
+}
+
+// waitForReady creates cl and waits for it to become ready.
+func waitForReady(cl *clusterv1.XCluster) {
+	Expect(k8sClient.Create(context.Background(), cl)).To(Succeed())
+	Eventually(func() (bool, error) {
+		err := k8sClient.Get(context.Background(), keyOf(cl), cl)
+		return cl.Status.Ready, err
+	}, timeout, interval).Should(BeTrue())
+}
+
+var _ = Describe("XCluster", func() {
+	var cl *clusterv1.XCluster
+
+	BeforeEach(func() {
+		cl = newXCluster()
+	})
+
+	AfterEach(func() {
+		metalAPI.ClearFaults()
+	})
+
+	It("allocates the private network, creates the xfirewall and gets ready", func() {
+		Expect(k8sClient.Create(context.Background(), cl)).To(Succeed())
+
+		By("allocating the private network")
+		Eventually(func() (string, error) {
+			err := k8sClient.Get(context.Background(), keyOf(cl), cl)
+			return cl.Spec.PrivateNetworkID, err
+		}, timeout, interval).ShouldNot(BeEmpty())
+		Expect(metalAPI.Networks()).To(ContainElement(cl.Spec.PrivateNetworkID))
+		Expect(cl.HasFinalizer(clusterv1.XFirewallFinalizer)).To(BeTrue())
+
+		By("creating the xfirewall owned by the xcluster")
+		fw := &clusterv1.XFirewall{}
+		Eventually(func() (string, error) {
+			err := k8sClient.Get(context.Background(), keyOf(cl), fw)
+			return fw.Spec.MachineID, err
+		}, timeout, interval).ShouldNot(BeEmpty())
+		Expect(metav1.IsControlledBy(fw, cl)).To(BeTrue())
+		Expect(fw.HasFinalizer(clusterv1.XFirewallFinalizer)).To(BeTrue())
+		Expect(metalAPI.Machines()).To(ContainElement(fw.Spec.MachineID))
+
+		By("propagating the readiness of the xfirewall")
+		Eventually(func() (bool, error) {
+			err := k8sClient.Get(context.Background(), keyOf(cl), cl)
+			return cl.Status.Ready, err
+		}, timeout, interval).Should(BeTrue())
+		Expect(k8sClient.Get(context.Background(), keyOf(fw), fw)).To(Succeed())
+		Expect(fw.Status.Ready).To(BeTrue())
+		Expect(cl.Status.Phase).To(Equal(clusterv1.PhaseReady))
+		Expect(cl.Status.FailureDomains).To(HaveKey(testPartition))
+	})
+
+	It("flips back to not ready once the xfirewall isn't ready anymore", func() {
+		waitForReady(cl)
+		fw := &clusterv1.XFirewall{}
+		Expect(k8sClient.Get(context.Background(), keyOf(cl), fw)).To(Succeed())
+
+		By("deleting the firewall out of band and touching the xfirewall to check it")
+		_, err := metalAPI.MachineDelete(context.Background(), fw.Spec.MachineID)
+		Expect(err).ToNot(HaveOccurred())
+		fw.Annotations = map[string]string{"test": "touched"}
+		Expect(k8sClient.Update(context.Background(), fw)).To(Succeed())
+
+		Eventually(func() (bool, error) {
+			err := k8sClient.Get(context.Background(), keyOf(fw), fw)
+			return fw.Status.Ready, err
+		}, timeout, interval).Should(BeFalse())
+		Eventually(func() (clusterv1.Phase, error) {
+			err := k8sClient.Get(context.Background(), keyOf(cl), cl)
+			return cl.Status.Phase, err
+		}, timeout, interval).Should(Equal(clusterv1.PhaseProvisioningFirewall))
+		Expect(cl.Status.Ready).To(BeFalse())
+	})
+
+	It("deletes the machine and frees the network once deleted", func() {
+		waitForReady(cl)
+		fw := &clusterv1.XFirewall{}
+		Expect(k8sClient.Get(context.Background(), keyOf(cl), fw)).To(Succeed())
+		machineID, networkID := fw.Spec.MachineID, cl.Spec.PrivateNetworkID
+
+		Expect(k8sClient.Delete(context.Background(), cl)).To(Succeed())
+
+		Eventually(func() bool {
+			return errors.IsNotFound(k8sClient.Get(context.Background(), keyOf(cl), cl))
+		}, timeout, interval).Should(BeTrue())
+		Eventually(func() bool {
+			return errors.IsNotFound(k8sClient.Get(context.Background(), keyOf(fw), fw))
+		}, timeout, interval).Should(BeTrue())
+		Eventually(metalAPI.Machines, timeout, interval).ShouldNot(ContainElement(machineID))
+		Eventually(metalAPI.Networks, timeout, interval).ShouldNot(ContainElement(networkID))
+	})
+
+	table.DescribeTable("recovers once metal-api is available again",
+		func(op string, newObject func() statusObject) {
+			metalAPI.Fail(op, metal.Transient)
+			Expect(k8sClient.Create(context.Background(), cl)).To(Succeed())
+
+			// The xnetwork or xfirewall calling op reports metal-api unavailable.
+			obj := newObject()
+			available := func() (corev1.ConditionStatus, error) {
+				err := k8sClient.Get(context.Background(), keyOf(cl), obj)
+				return conditionStatus(obj, clusterv1.MetalAPIAvailable), err
+			}
+			Eventually(available, timeout, interval).Should(Equal(corev1.ConditionFalse))
+
+			metalAPI.Fail(op, "")
+			Eventually(available, timeout, interval).Should(Equal(corev1.ConditionTrue))
+			Eventually(func() (bool, error) {
+				err := k8sClient.Get(context.Background(), keyOf(cl), cl)
+				return cl.Status.Ready, err
+			}, timeout, interval).Should(BeTrue())
+		},
+		table.Entry("allocating the network", "NetworkAllocate", func() statusObject { return &clusterv1.XNetwork{} }),
+		table.Entry("checking the network", "NetworkGet", func() statusObject { return &clusterv1.XNetwork{} }),
+		table.Entry("creating the firewall", "FirewallCreate", func() statusObject { return &clusterv1.XFirewall{} }),
+		table.Entry("checking the firewall", "MachineGet", func() statusObject { return &clusterv1.XFirewall{} }),
+	)
+
+	It("marks the xfirewall failed if metal-api rejects the firewall", func() {
+		metalAPI.Fail("FirewallCreate", metal.Permanent)
+		Expect(k8sClient.Create(context.Background(), cl)).To(Succeed())
+
+		fw := &clusterv1.XFirewall{}
+		Eventually(func() (clusterv1.Phase, error) {
+			err := k8sClient.Get(context.Background(), keyOf(cl), fw)
+			return fw.Status.Phase, err
+		}, timeout, interval).Should(Equal(clusterv1.PhaseFailed))
+		Expect(k8sClient.Get(context.Background(), keyOf(cl), cl)).To(Succeed())
+		Expect(cl.Status.Ready).To(BeFalse())
+	})
+
+	It("keeps the xfirewall until its machine is deleted", func() {
+		waitForReady(cl)
+		fw := &clusterv1.XFirewall{}
+		Expect(k8sClient.Get(context.Background(), keyOf(cl), fw)).To(Succeed())
+		machineID := fw.Spec.MachineID
+
+		metalAPI.Fail("MachineDelete", metal.Transient)
+		Expect(k8sClient.Delete(context.Background(), cl)).To(Succeed())
+
+		Eventually(func() (clusterv1.Phase, error) {
+			err := k8sClient.Get(context.Background(), keyOf(fw), fw)
+			return fw.Status.Phase, err
+		}, timeout, interval).Should(Equal(clusterv1.PhaseDeleting))
+		Consistently(func() error {
+			return k8sClient.Get(context.Background(), keyOf(fw), fw)
+		}, time.Second, interval).Should(Succeed())
+		Expect(metalAPI.Machines()).To(ContainElement(machineID))
+
+		metalAPI.Fail("MachineDelete", "")
+		Eventually(func() bool {
+			return errors.IsNotFound(k8sClient.Get(context.Background(), keyOf(fw), fw))
+		}, timeout, interval).Should(BeTrue())
+		Eventually(metalAPI.Machines, timeout, interval).ShouldNot(ContainElement(machineID))
+	})
+
+	It("carries changes of the private network over to the owned xnetwork", func() {
+		waitForReady(cl)
+
+		cl.Spec.PrivateNetwork.Labels = map[string]string{"purpose": "test"}
+		Expect(k8sClient.Update(context.Background(), cl)).To(Succeed())
+
+		n := &clusterv1.XNetwork{}
+		Eventually(func() (string, error) {
+			err := k8sClient.Get(context.Background(), keyOf(cl), n)
+			return n.Spec.Labels["purpose"], err
+		}, timeout, interval).Should(Equal("test"))
+	})
+
+	It("keeps the network until no machine is allocated in it anymore", func() {
+		waitForReady(cl)
+		networkID := cl.Spec.PrivateNetworkID
+
+		By("allocating a machine in the network out of band")
+		resp, err := metalAPI.MachineCreate(context.Background(), &metalgo.MachineCreateRequest{
+			Name:      "worker",
+			Partition: testPartition,
+			Project:   testProject,
+			Networks:  []metalgo.MachineAllocationNetwork{{NetworkID: networkID}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(k8sClient.Delete(context.Background(), cl)).To(Succeed())
+
+		n := &clusterv1.XNetwork{}
+		Consistently(func() error {
+			return k8sClient.Get(context.Background(), keyOf(cl), n)
+		}, time.Second, interval).Should(Succeed())
+		Expect(metalAPI.Networks()).To(ContainElement(networkID))
+
+		_, err = metalAPI.MachineDelete(context.Background(), metalgo.StrDeref(resp.Machine.ID))
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(func() bool {
+			return errors.IsNotFound(k8sClient.Get(context.Background(), keyOf(cl), n))
+		}, 2*inUsePollInterval, interval).Should(BeTrue())
+		Eventually(metalAPI.Networks, timeout, interval).ShouldNot(ContainElement(networkID))
+	})
+
+	It("is deleted without calling metal-api before its network is allocated", func() {
+		cl.Spec.ProjectID = ""
+		Expect(k8sClient.Create(context.Background(), cl)).To(Succeed())
+		Eventually(func() (corev1.ConditionStatus, error) {
+			err := k8sClient.Get(context.Background(), keyOf(cl), cl)
+			return conditionStatus(cl, clusterv1.ProjectResolved), err
+		}, timeout, interval).Should(Equal(corev1.ConditionFalse))
+		Expect(cl.Spec.PrivateNetworkID).To(BeEmpty())
+
+		metalAPI.Fail("MachineFind", metal.Transient)
+		metalAPI.Fail("NetworkFind", metal.Transient)
+		Expect(k8sClient.Delete(context.Background(), cl)).To(Succeed())
+		Eventually(func() bool {
+			return errors.IsNotFound(k8sClient.Get(context.Background(), keyOf(cl), cl))
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("keeps the xnetwork until its network is freed", func() {
+		waitForReady(cl)
+		networkID := cl.Spec.PrivateNetworkID
+
+		metalAPI.Fail("NetworkFree", metal.Transient)
+		Expect(k8sClient.Delete(context.Background(), cl)).To(Succeed())
+
+		n := &clusterv1.XNetwork{}
+		Consistently(func() error {
+			return k8sClient.Get(context.Background(), keyOf(cl), n)
+		}, time.Second, interval).Should(Succeed())
+		Expect(metalAPI.Networks()).To(ContainElement(networkID))
+
+		metalAPI.Fail("NetworkFree", "")
+		Eventually(func() bool {
+			return errors.IsNotFound(k8sClient.Get(context.Background(), keyOf(cl), n))
+		}, timeout, interval).Should(BeTrue())
+		Eventually(metalAPI.Networks, timeout, interval).ShouldNot(ContainElement(networkID))
+	})
+})
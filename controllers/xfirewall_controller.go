@@ -19,37 +19,67 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
 	metalgo "github.com/metal-stack/metal-go"
+	"github.com/metal-stack/metal-go/api/models"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 
 	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+	"github.com/LimKianAn/xcluster/tracing"
 )
 
+// xfirewallTag marks the metal-stack firewall created for fw, so that it is
+// found again.
+func xfirewallTag(fw *clusterv1.XFirewall) string {
+	return "cluster.www.x-cellent.com/xfirewall=" + fw.Namespace + "/" + fw.Name
+}
+
 // XFirewallReconciler reconciles a XFirewall object
 type XFirewallReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
-	Driver *metalgo.Driver
+	Driver metal.Client
+
+	// Recorder, if set, receives an event for every metal-api call skipped in
+	// dry-run mode and for every drift.
+	Recorder record.EventRecorder
+
+	// ResyncPeriod is how often the machine of a ready xfirewall is checked
+	// for drift. Zero disables the periodic check.
+	ResyncPeriod time.Duration
+
+	// MaxConcurrentReconciles is how many xfirewalls are reconciled at once.
+	// Zero means one.
+	MaxConcurrentReconciles int
 }
 
 // +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xfirewalls,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xfirewalls/status,verbs=get;update;patch
 
-func (r *XFirewallReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	ctx := context.Background()
-	log := r.Log.WithValues("xfirewall", req.NamespacedName)
+func (r *XFirewallReconciler) Reconcile(req ctrl.Request) (result ctrl.Result, err error) {
+	ctx, span := tracing.Start(context.Background(), "XFirewall.Reconcile", tracing.String("xfirewall", req.NamespacedName.String()))
+	defer func() { span.RecordError(err); span.End() }()
+	log := tracing.Logger(ctx, r.Log.WithValues("xfirewall", req.NamespacedName))
 
 	// Fetch XFirewall instance
 	fw := &clusterv1.XFirewall{}
 	if err := r.Get(ctx, req.NamespacedName, fw); err != nil {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	ctx = withDryRunRecorder(ctx, r.Recorder, fw)
+	defer func() { result, err = updateStatus(ctx, r, fw, xfirewallPhase(fw, err), result, err) }()
 
 	if fw.IsBeingDeleted() {
 		// Resetting the states of the underlying raw machine before XFirewall is deleted on API-server.
@@ -58,42 +88,85 @@ func (r *XFirewallReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
 	// Add finalizer if none.
 	if !fw.HasFinalizer(clusterv1.XFirewallFinalizer) {
+		base := fw.DeepCopy()
 		fw.AddFinalizer(clusterv1.XFirewallFinalizer)
-		if err := r.Update(ctx, fw); err != nil {
+		if err := r.Patch(ctx, fw, mergeFromWithLock(base), fieldOwner); err != nil {
 			return ctrl.Result{}, fmt.Errorf("failed to update xfirewall finalizer: %w", err)
 		}
 		r.Log.Info("finalizer added")
 	}
 
-	if fw.Spec.MachineID == "" {
-		if err := r.CreateMetalStackFirewall(ctx, fw); err != nil {
+	if !realID(fw.Spec.MachineID) {
+		created, err := r.CreateMetalStackFirewall(ctx, fw)
+		if err != nil {
 			return ctrl.Result{}, fmt.Errorf("failed to create metal-stack firewall: %w", err)
 		}
+		if !created {
+			// Fixing the networks changes fw, which triggers the next reconciliation.
+			log.Info("invalid additional networks")
+			return ctrl.Result{}, nil
+		}
 		r.Log.Info("metal-stack firewall created")
+	} else {
+		machine, drifted, err := r.checkMachine(ctx, fw, log)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if drifted {
+			return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+		}
+		if reattached, err := r.reattach(ctx, fw, machine, log); err != nil || reattached {
+			// Dropping the machine-ID changes fw, which triggers the next reconciliation.
+			return ctrl.Result{}, err
+		}
 	}
 
 	// todo: Ask metal-api if metal-stack firewall is ready
 	if !fw.Status.Ready {
+		base := fw.DeepCopy()
 		fw.Status.Ready = true
-		if err := r.Status().Update(ctx, fw); err != nil {
+		if err := r.Status().Patch(ctx, fw, mergeFromWithLock(base), fieldOwner); err != nil {
 			return ctrl.Result{}, fmt.Errorf("failed to update the status of xfirewall: %w", err)
 		}
 		r.Log.Info("xfirewall status updated as ready")
 	}
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
 }
 
-func (r *XFirewallReconciler) CreateMetalStackFirewall(ctx context.Context, fw *clusterv1.XFirewall) error {
+// CreateMetalStackFirewall creates the metal-stack firewall of fw unless its
+// additional networks are invalid. It reports whether it did.
+func (r *XFirewallReconciler) CreateMetalStackFirewall(ctx context.Context, fw *clusterv1.XFirewall) (bool, error) {
 	cl := &clusterv1.XCluster{}
 	if err := r.Get(ctx, types.NamespacedName{
 		Namespace: fw.Namespace,
 		Name:      fw.Name,
 	}, cl); err != nil {
-		return fmt.Errorf("failed to fetch owner xcluster instance: %w", err)
+		return false, fmt.Errorf("failed to fetch owner xcluster instance: %w", err)
+	}
+
+	valid, err := checkAttachments(ctx, r, r.Driver, r.Recorder, fw, fw.Spec.AdditionalNetworks, cl.Spec.Partition, cl.Spec.ProjectID)
+	if err != nil || !valid {
+		return false, err
+	}
+	networks, ips := withAttachments(toNetworks(fw.Spec.DefaultNetworkID, cl.Spec.PrivateNetworkID), fw.Spec.AdditionalNetworks)
+
+	// A firewall created before, whose machine-ID didn't make it into fw, is
+	// adopted instead of creating another one.
+	machine, err := findMachine(ctx, r.Driver, cl.Spec.Partition, cl.Spec.ProjectID, xfirewallTag(fw))
+	if err != nil {
+		return false, err
+	}
+	if machine != nil {
+		base := fw.DeepCopy()
+		fw.Spec.MachineID = *machine.ID
+		if err := r.Patch(ctx, fw, client.MergeFrom(base), fieldOwner); err != nil {
+			return false, fmt.Errorf("failed to update xfirewall machine-ID: %w", err)
+		}
+		return true, nil
 	}
 
-	resp, err := r.Driver.FirewallCreate(&metalgo.FirewallCreateRequest{
+	resp, err := r.Driver.FirewallCreate(ctx, &metalgo.FirewallCreateRequest{
 		MachineCreateRequest: metalgo.MachineCreateRequest{
 			Description:   "",
 			Name:          fw.Name,
@@ -103,31 +176,199 @@ func (r *XFirewallReconciler) CreateMetalStackFirewall(ctx context.Context, fw *
 			Partition:     cl.Spec.Partition,
 			Image:         fw.Spec.Image,
 			SSHPublicKeys: []string{},
-			Networks:      toNetworks(fw.Spec.DefaultNetworkID, cl.Spec.PrivateNetworkID),
+			Networks:      networks,
+			IPs:           ips,
 			UserData:      "",
-			Tags:          []string{},
+			Tags:          append(networkTags(fw.Spec.AdditionalNetworks), xfirewallTag(fw)),
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("failed to create metal-stack firewall: %w", err)
+		return false, fmt.Errorf("failed to create metal-stack firewall: %w", err)
 	}
 
+	if metal.IsDryRunID(*resp.Firewall.ID) {
+		// Made-up IDs aren't recorded, see realID.
+		return true, nil
+	}
+	base := fw.DeepCopy()
 	fw.Spec.MachineID = *resp.Firewall.ID
-	if err := r.Update(ctx, fw); err != nil {
-		return fmt.Errorf("failed to update xfirewall machine-ID: %w", err)
+	if err := r.Patch(ctx, fw, client.MergeFrom(base), fieldOwner); err != nil {
+		return false, fmt.Errorf("failed to update xfirewall machine-ID: %w", err)
+	}
+
+	return true, nil
+}
+
+// checkMachine marks fw Drifted if its machine vanished, was freed or was
+// reallocated out of band, and records the IPs of the machine otherwise.
+// Under the Recreate policy it forgets the machine, so that a new one is
+// created. The old machine is left alone since it may belong to someone
+// else by now.
+func (r *XFirewallReconciler) checkMachine(ctx context.Context, fw *clusterv1.XFirewall, log logr.Logger) (machine *models.V1MachineResponse, drifted bool, err error) {
+	cl := &clusterv1.XCluster{}
+	if err := r.Get(ctx, types.NamespacedName{
+		Namespace: fw.Namespace,
+		Name:      fw.Name,
+	}, cl); err != nil {
+		return nil, false, fmt.Errorf("failed to fetch owner xcluster instance: %w", err)
+	}
+
+	d, machine, err := machineDrift(ctx, r.Driver, fw.Spec.MachineID, cl.Spec.Partition, cl.Spec.ProjectID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	base := fw.DeepCopy()
+	changed := fw.SetCondition(d.condition())
+	if !d.drifted() {
+		if networks := networkStatuses(machine); !equality.Semantic.DeepEqual(networks, fw.Status.Networks) {
+			fw.Status.Networks = networks
+			changed = true
+		}
 	}
+	if d.drifted() && fw.Status.Ready {
+		fw.Status.Ready = false
+		changed = true
+	}
+	if changed {
+		if err := r.Status().Patch(ctx, fw, mergeFromWithLock(base), fieldOwner); err != nil {
+			return nil, false, fmt.Errorf("failed to update the drift of the xfirewall: %w", err)
+		}
+	}
+	if !d.drifted() {
+		return machine, false, nil
+	}
+
+	log.Info("metal-stack firewall drifted", "reason", d.Reason, "policy", fw.Spec.DriftPolicy)
+	if r.Recorder != nil {
+		r.Recorder.Event(fw, corev1.EventTypeWarning, d.Reason, d.Message)
+	}
+	if !recreate(fw.Spec.DriftPolicy) {
+		return nil, true, nil
+	}
+
+	base = fw.DeepCopy()
+	fw.Spec.MachineID = ""
+	if err := r.Patch(ctx, fw, client.MergeFrom(base), fieldOwner); err != nil {
+		return nil, false, fmt.Errorf("failed to reset the machine-ID of the xfirewall: %w", err)
+	}
+	log.Info("drifted metal-stack firewall dropped to be recreated")
+
+	return nil, true, nil
+}
 
+// reattach handles additional networks of fw which changed since its
+// metal-stack firewall was created. metal-api can't update the networks of an
+// allocated machine in place, so attaching them takes a new firewall, which
+// interrupts the traffic of the whole cluster. Hence the firewall is only
+// recreated under the Recreate NetworkChangePolicy, after the new networks
+// are validated, so that it isn't torn down for nothing, and an event
+// announced it. Otherwise the NetworksAttached condition turns False. It
+// reports whether the firewall was dropped.
+func (r *XFirewallReconciler) reattach(ctx context.Context, fw *clusterv1.XFirewall, machine *models.V1MachineResponse, log logr.Logger) (bool, error) {
+	if !attachmentsChanged(fw.Spec.AdditionalNetworks, machine) {
+		return false, r.setNetworksAttached(ctx, fw, clusterv1.Condition{
+			Type:   clusterv1.NetworksAttached,
+			Status: corev1.ConditionTrue,
+			Reason: "Attached",
+		})
+	}
+	if fw.Spec.NetworkChangePolicy != clusterv1.NetworkChangePolicyRecreate {
+		log.Info("changed networks not attached", "policy", fw.Spec.NetworkChangePolicy)
+		return false, r.setNetworksAttached(ctx, fw, clusterv1.Condition{
+			Type:   clusterv1.NetworksAttached,
+			Status: corev1.ConditionFalse,
+			Reason: "RecreationRequired",
+			Message: fmt.Sprintf("metal-api can't attach networks to the allocated firewall %s, "+
+				"set spec.networkChangePolicy to Recreate to have it recreated with them", fw.Spec.MachineID),
+		})
+	}
+
+	cl := &clusterv1.XCluster{}
+	if err := r.Get(ctx, types.NamespacedName{
+		Namespace: fw.Namespace,
+		Name:      fw.Name,
+	}, cl); err != nil {
+		return false, fmt.Errorf("failed to fetch owner xcluster instance: %w", err)
+	}
+	valid, err := checkAttachments(ctx, r, r.Driver, r.Recorder, fw, fw.Spec.AdditionalNetworks, cl.Spec.Partition, cl.Spec.ProjectID)
+	if err != nil || !valid {
+		return false, err
+	}
+
+	if r.Recorder != nil {
+		r.Recorder.Eventf(fw, corev1.EventTypeWarning, "Recreating",
+			"metal-stack firewall %s is deleted and recreated to attach the changed networks", fw.Spec.MachineID)
+	}
+	if _, err := r.Driver.MachineDelete(ctx, fw.Spec.MachineID); err != nil && !metal.IsNotFound(err) {
+		return false, fmt.Errorf("failed to delete metal-stack firewall: %w", err)
+	}
+	log.Info("metal-stack firewall deleted to attach the changed networks", "machine", fw.Spec.MachineID)
+
+	base := fw.DeepCopy()
+	fw.Spec.MachineID = ""
+	if err := r.Patch(ctx, fw, client.MergeFrom(base), fieldOwner); err != nil {
+		return false, fmt.Errorf("failed to reset the machine-ID of the xfirewall: %w", err)
+	}
+	base = fw.DeepCopy()
+	fw.Status.Ready = false
+	fw.Status.Networks = nil
+	fw.SetCondition(clusterv1.Condition{
+		Type:   clusterv1.NetworksAttached,
+		Status: corev1.ConditionFalse,
+		Reason: "Recreating",
+	})
+	if err := r.Status().Patch(ctx, fw, mergeFromWithLock(base), fieldOwner); err != nil {
+		return false, fmt.Errorf("failed to update the readiness of the xfirewall: %w", err)
+	}
+
+	return true, nil
+}
+
+// setNetworksAttached sets cond on fw, warning of changed networks left
+// unattached. Firewalls without additional networks go without it.
+func (r *XFirewallReconciler) setNetworksAttached(ctx context.Context, fw *clusterv1.XFirewall, cond clusterv1.Condition) error {
+	if cond.Status == corev1.ConditionTrue && len(fw.Spec.AdditionalNetworks) == 0 && fw.GetCondition(clusterv1.NetworksAttached) == nil {
+		return nil
+	}
+
+	base := fw.DeepCopy()
+	if !fw.SetCondition(cond) {
+		return nil
+	}
+	if cond.Status == corev1.ConditionFalse && r.Recorder != nil {
+		r.Recorder.Event(fw, corev1.EventTypeWarning, "NetworksChanged", cond.Message)
+	}
+	if err := r.Status().Patch(ctx, fw, mergeFromWithLock(base), fieldOwner); err != nil {
+		return fmt.Errorf("failed to update the network condition of the xfirewall: %w", err)
+	}
 	return nil
 }
 
 func (r *XFirewallReconciler) DeleteMetalStackFirewall(ctx context.Context, fw *clusterv1.XFirewall, log logr.Logger) (ctrl.Result, error) {
-	if _, err := r.Driver.MachineDelete(fw.Spec.MachineID); err != nil {
-		return ctrl.Result{}, fmt.Errorf("failed to delete metal-stack firewall: %w", err)
+	id := fw.Spec.MachineID
+	if !realID(id) {
+		// The machine-ID of a firewall whose creation response got lost
+		// didn't make it into fw, so the firewall is looked up by its tag.
+		id = ""
+		machine, err := r.findFirewall(ctx, fw)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if machine != nil {
+			id = *machine.ID
+		}
+	}
+	if id != "" {
+		if _, err := r.Driver.MachineDelete(ctx, id); err != nil && !metal.IsNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("failed to delete metal-stack firewall: %w", err)
+		}
+		log.Info("states of the machine managed by xfirewall reset", "machine", id)
 	}
-	log.Info("states of the machine managed by xfirewall reset")
 
+	base := fw.DeepCopy()
 	fw.RemoveFinalizer(clusterv1.XFirewallFinalizer)
-	if err := r.Update(ctx, fw); err != nil {
+	if err := r.Patch(ctx, fw, mergeFromWithLock(base), fieldOwner); err != nil {
 		return ctrl.Result{}, fmt.Errorf("failed to remove xfirewall finalizer: %w", err)
 	}
 	r.Log.Info("finalizer removed")
@@ -135,18 +376,41 @@ func (r *XFirewallReconciler) DeleteMetalStackFirewall(ctx context.Context, fw *
 	return ctrl.Result{}, nil
 }
 
+// findFirewall returns the metal-stack firewall tagged with fw in the
+// partition and project of the xcluster of fw, or nil if there is none or the
+// xcluster is gone.
+func (r *XFirewallReconciler) findFirewall(ctx context.Context, fw *clusterv1.XFirewall) (*models.V1MachineResponse, error) {
+	cl := &clusterv1.XCluster{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: fw.Namespace, Name: fw.Name}, cl); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch owner xcluster instance: %w", err)
+	}
+	return findMachine(ctx, r.Driver, cl.Spec.Partition, cl.Spec.ProjectID, xfirewallTag(fw))
+}
+
+// xfirewallPhase derives the phase of fw after a reconciliation which returned err.
+func xfirewallPhase(fw *clusterv1.XFirewall, err error) clusterv1.Phase {
+	switch {
+	case fw.IsBeingDeleted():
+		return clusterv1.PhaseDeleting
+	case failedPermanently(err), networksInvalid(fw):
+		return clusterv1.PhaseFailed
+	case markedDrifted(fw):
+		return clusterv1.PhaseDrifted
+	case fw.Status.Ready:
+		return clusterv1.PhaseReady
+	case !fw.HasFinalizer(clusterv1.XFirewallFinalizer):
+		return clusterv1.PhasePending
+	default:
+		return clusterv1.PhaseProvisioningFirewall
+	}
+}
+
 func (r *XFirewallReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&clusterv1.XFirewall{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 }
-
-func toNetworks(ss ...string) (networks []metalgo.MachineAllocationNetwork) {
-	for _, s := range ss {
-		networks = append(networks, metalgo.MachineAllocationNetwork{
-			NetworkID:   s,
-			Autoacquire: true,
-		})
-	}
-	return
-}
@@ -0,0 +1,86 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/metal-stack/metal-go/api/models"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+)
+
+// storageNetwork is an external network firewalls may be attached to on top.
+const storageNetwork = "storage"
+
+var _ = Describe("XFirewall", func() {
+	var (
+		cl *clusterv1.XCluster
+		fw *clusterv1.XFirewall
+	)
+
+	BeforeEach(func() {
+		id := storageNetwork
+		metalAPI.AddNetwork(&models.V1NetworkResponse{ID: &id, Name: storageNetwork, Partitionid: testPartition})
+
+		cl = newXCluster()
+		waitForReady(cl)
+		fw = &clusterv1.XFirewall{}
+		Expect(k8sClient.Get(context.Background(), keyOf(cl), fw)).To(Succeed())
+	})
+
+	// attach adds the storage network to fw under the given policy.
+	attach := func(policy clusterv1.NetworkChangePolicy) {
+		base := fw.DeepCopy()
+		fw.Spec.AdditionalNetworks = []clusterv1.NetworkAttachment{{NetworkID: storageNetwork}}
+		fw.Spec.NetworkChangePolicy = policy
+		Expect(k8sClient.Patch(context.Background(), fw, client.MergeFrom(base))).To(Succeed())
+	}
+
+	networksAttached := func() (corev1.ConditionStatus, error) {
+		err := k8sClient.Get(context.Background(), keyOf(fw), fw)
+		return conditionStatus(fw, clusterv1.NetworksAttached), err
+	}
+
+	It("keeps the firewall if its networks change under the Report policy", func() {
+		machineID := fw.Spec.MachineID
+		attach(clusterv1.NetworkChangePolicyReport)
+
+		Eventually(networksAttached, timeout, interval).Should(Equal(corev1.ConditionFalse))
+		Expect(fw.Spec.MachineID).To(Equal(machineID))
+		Expect(fw.Status.Ready).To(BeTrue())
+		Expect(metalAPI.Machines()).To(ContainElement(machineID))
+	})
+
+	It("recreates the firewall with the changed networks under the Recreate policy", func() {
+		machineID := fw.Spec.MachineID
+		attach(clusterv1.NetworkChangePolicyRecreate)
+
+		Eventually(networksAttached, timeout, interval).Should(Equal(corev1.ConditionTrue))
+		Expect(fw.Spec.MachineID).ToNot(BeEmpty())
+		Expect(fw.Spec.MachineID).ToNot(Equal(machineID))
+		Expect(metalAPI.Machines()).ToNot(ContainElement(machineID))
+		Eventually(func() (bool, error) {
+			err := k8sClient.Get(context.Background(), keyOf(fw), fw)
+			return connectedTo(fw.Status.Networks, storageNetwork), err
+		}, timeout, interval).Should(BeTrue())
+	})
+})
@@ -0,0 +1,164 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	metalgo "github.com/metal-stack/metal-go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+	"github.com/LimKianAn/xcluster/tracing"
+)
+
+// XIPClaimReconciler reconciles a XIPClaim object
+type XIPClaimReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	Driver metal.Client
+
+	// Recorder, if set, receives an event for every metal-api call skipped in
+	// dry-run mode.
+	Recorder record.EventRecorder
+
+	// MaxConcurrentReconciles is how many xipclaims are reconciled at once.
+	// Zero means one.
+	MaxConcurrentReconciles int
+}
+
+// +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xipclaims,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xipclaims/status,verbs=get;update;patch
+
+func (r *XIPClaimReconciler) Reconcile(req ctrl.Request) (result ctrl.Result, err error) {
+	ctx, span := tracing.Start(context.Background(), "XIPClaim.Reconcile", tracing.String("xipclaim", req.NamespacedName.String()))
+	defer func() { span.RecordError(err); span.End() }()
+	log := tracing.Logger(ctx, r.Log.WithValues("xipclaim", req.NamespacedName))
+
+	// Fetch XIPClaim instance
+	claim := &clusterv1.XIPClaim{}
+	if err := r.Get(ctx, req.NamespacedName, claim); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	ctx = withDryRunRecorder(ctx, r.Recorder, claim)
+	defer func() { result, err = updateStatus(ctx, r, claim, xipclaimPhase(claim, err), result, err) }()
+
+	if claim.IsBeingDeleted() {
+		return r.ReconcileDeletion(ctx, claim, log)
+	}
+
+	cl := &clusterv1.XCluster{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: claim.Namespace, Name: claim.Spec.ClusterName}, cl); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to fetch xcluster instance: %w", err)
+	}
+
+	// Add finalizer and owner if none. Once cl is deleted, so is claim.
+	if !claim.HasFinalizer(clusterv1.XIPClaimFinalizer) || metav1.GetControllerOf(claim) == nil {
+		base := claim.DeepCopy()
+		claim.AddFinalizer(clusterv1.XIPClaimFinalizer)
+		if err := controllerutil.SetControllerReference(cl, claim, r.Scheme); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to set the owner reference of the xipclaim: %w", err)
+		}
+		if err := r.Patch(ctx, claim, mergeFromWithLock(base), fieldOwner); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update xipclaim finalizer: %w", err)
+		}
+		log.Info("finalizer and owner added")
+	}
+
+	if claim.Status.Address != "" {
+		return ctrl.Result{}, nil
+	}
+
+	ip, err := allocateIP(ctx, r.Driver, &metalgo.IPAllocateRequest{
+		IPAddress:   claim.Spec.Address,
+		Name:        claim.Name,
+		Description: "claimed by xipclaim " + claim.Namespace + "/" + claim.Name,
+		Networkid:   claim.Spec.NetworkID,
+		Projectid:   cl.Spec.ProjectID,
+		Type:        string(claim.IPType()),
+	}, xipclaimTag(claim))
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to allocate metal-stack IP: %w", err)
+	}
+
+	base := claim.DeepCopy()
+	claim.Status.Address = metalgo.StrDeref(ip.Ipaddress)
+	if err := r.Status().Patch(ctx, claim, mergeFromWithLock(base), fieldOwner); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update the address of the xipclaim: %w", err)
+	}
+	log.Info("metal-stack IP allocated", "ip", claim.Status.Address)
+
+	return ctrl.Result{}, nil
+}
+
+func (r *XIPClaimReconciler) ReconcileDeletion(ctx context.Context, claim *clusterv1.XIPClaim, log logr.Logger) (ctrl.Result, error) {
+	if address := claim.Status.Address; address != "" {
+		released, err := releaseIP(ctx, r.Driver, address, xipclaimTag(claim))
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to release metal-stack IP: %w", err)
+		}
+		if released {
+			log.Info("metal-stack IP released", "ip", address)
+		}
+	}
+
+	base := claim.DeepCopy()
+	claim.RemoveFinalizer(clusterv1.XIPClaimFinalizer)
+	if err := r.Patch(ctx, claim, mergeFromWithLock(base), fieldOwner); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to remove xipclaim finalizer: %w", err)
+	}
+	log.Info("finalizer removed")
+
+	return ctrl.Result{}, nil
+}
+
+// xipclaimTag marks the metal-stack IP allocated for claim.
+func xipclaimTag(claim *clusterv1.XIPClaim) string {
+	return "cluster.www.x-cellent.com/xipclaim=" + claim.Namespace + "/" + claim.Name
+}
+
+// xipclaimPhase derives the phase of claim after a reconciliation which returned err.
+func xipclaimPhase(claim *clusterv1.XIPClaim, err error) clusterv1.Phase {
+	switch {
+	case claim.IsBeingDeleted():
+		return clusterv1.PhaseDeleting
+	case failedPermanently(err):
+		return clusterv1.PhaseFailed
+	case claim.Status.Address != "":
+		return clusterv1.PhaseReady
+	default:
+		return clusterv1.PhasePending
+	}
+}
+
+func (r *XIPClaimReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&clusterv1.XIPClaim{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Complete(r)
+}
@@ -0,0 +1,69 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+)
+
+var _ = Describe("XIPClaim", func() {
+	var (
+		cl    *clusterv1.XCluster
+		claim *clusterv1.XIPClaim
+	)
+
+	BeforeEach(func() {
+		cl = newXCluster()
+		waitForReady(cl)
+
+		claim = &clusterv1.XIPClaim{}
+		claim.Name = cl.Name + "-ingress"
+		claim.Namespace = cl.Namespace
+		claim.Spec.ClusterName = cl.Name
+		claim.Spec.NetworkID = internetNetwork
+	})
+
+	address := func() (string, error) {
+		err := k8sClient.Get(context.Background(), keyOf(claim), claim)
+		return claim.Status.Address, err
+	}
+
+	It("allocates the IP in the project of the xcluster and releases it once deleted", func() {
+		Expect(k8sClient.Create(context.Background(), claim)).To(Succeed())
+
+		Eventually(address, timeout, interval).ShouldNot(BeEmpty())
+		Expect(metav1.IsControlledBy(claim, cl)).To(BeTrue())
+		Expect(claim.Status.Phase).To(Equal(clusterv1.PhaseReady))
+		ip, err := metalAPI.IPGet(context.Background(), claim.Status.Address)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*ip.IP.Projectid).To(Equal(testProject))
+		Expect(*ip.IP.Type).To(Equal(string(clusterv1.IPTypeStatic)))
+
+		Expect(k8sClient.Delete(context.Background(), claim)).To(Succeed())
+		Eventually(func() bool {
+			return errors.IsNotFound(k8sClient.Get(context.Background(), keyOf(claim), claim))
+		}, timeout, interval).Should(BeTrue())
+		Expect(metalAPI.IPs()).ToNot(ContainElement(claim.Status.Address))
+	})
+})
@@ -0,0 +1,380 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	metalgo "github.com/metal-stack/metal-go"
+	"github.com/metal-stack/metal-go/api/models"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+	"github.com/LimKianAn/xcluster/tracing"
+)
+
+// machinePollInterval is how often an xmachine is reconciled while it waits
+// for things which aren't watched: the readiness of its xcluster, the
+// bootstrap data and the installation of the metal-stack machine.
+const machinePollInterval = 15 * time.Second
+
+// xmachineTag marks the metal-stack machine created for m, so that it is
+// found again.
+func xmachineTag(m *clusterv1.XMachine) string {
+	return "cluster.www.x-cellent.com/xmachine=" + m.Namespace + "/" + m.Name
+}
+
+// XMachineReconciler reconciles a XMachine object
+type XMachineReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	Driver metal.Client
+
+	// Recorder, if set, receives an event for every metal-api call skipped in
+	// dry-run mode.
+	Recorder record.EventRecorder
+
+	// MaxConcurrentReconciles is how many xmachines are reconciled at once.
+	// Zero means one.
+	MaxConcurrentReconciles int
+}
+
+// +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xmachines,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xmachines/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xmachinetemplates,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+func (r *XMachineReconciler) Reconcile(req ctrl.Request) (result ctrl.Result, err error) {
+	ctx, span := tracing.Start(context.Background(), "XMachine.Reconcile", tracing.String("xmachine", req.NamespacedName.String()))
+	defer func() { span.RecordError(err); span.End() }()
+	log := tracing.Logger(ctx, r.Log.WithValues("xmachine", req.NamespacedName))
+
+	// Fetch XMachine instance
+	m := &clusterv1.XMachine{}
+	if err := r.Get(ctx, req.NamespacedName, m); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// An xmachine is created by Cluster API, which sets the owner Machine
+	// right after. Its update triggers the next reconciliation.
+	machine, err := ownerMachine(ctx, r, m)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if machine == nil && !m.IsBeingDeleted() {
+		log.Info("waiting for the owner machine")
+		return ctrl.Result{}, nil
+	}
+
+	var cluster *unstructured.Unstructured
+	if machine != nil {
+		if cluster, err = machineCluster(ctx, r, machine); err != nil {
+			return ctrl.Result{}, err
+		}
+		log = log.WithValues("machine", machine.GetName())
+	}
+	if isPaused(cluster, m) {
+		log.Info("reconciliation paused")
+		return ctrl.Result{RequeueAfter: pausedPollInterval}, nil
+	}
+
+	ctx = withDryRunRecorder(ctx, r.Recorder, m)
+	defer func() { result, err = updateStatus(ctx, r, m, xmachinePhase(m, err), result, err) }()
+
+	if m.IsBeingDeleted() {
+		return r.ReconcileDeletion(ctx, m, cluster, log)
+	}
+
+	// Add finalizer if none.
+	if !m.HasFinalizer(clusterv1.XMachineFinalizer) {
+		base := m.DeepCopy()
+		m.AddFinalizer(clusterv1.XMachineFinalizer)
+		if err := r.Patch(ctx, m, mergeFromWithLock(base), fieldOwner); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update xmachine finalizer: %w", err)
+		}
+		log.Info("finalizer added")
+	}
+
+	if m.Status.FailureReason != nil {
+		// Cluster API replaces failed machines, there is nothing left to do.
+		return ctrl.Result{}, nil
+	}
+
+	if !realID(m.MachineID()) {
+		return r.CreateMetalStackMachine(ctx, m, machine, cluster, log)
+	}
+
+	return r.UpdateMachineStatus(ctx, m, log)
+}
+
+// CreateMetalStackMachine allocates the metal-stack machine of m in the
+// private network of the xcluster, once both the xcluster and the bootstrap
+// data of machine are ready.
+func (r *XMachineReconciler) CreateMetalStackMachine(ctx context.Context, m *clusterv1.XMachine, machine, cluster *unstructured.Unstructured, log logr.Logger) (ctrl.Result, error) {
+	if cluster == nil {
+		log.Info("waiting for the cluster of the machine")
+		return ctrl.Result{RequeueAfter: machinePollInterval}, nil
+	}
+
+	name, _, _ := unstructured.NestedString(cluster.Object, "spec", "infrastructureRef", "name")
+	kind, _, _ := unstructured.NestedString(cluster.Object, "spec", "infrastructureRef", "kind")
+	if name == "" || kind != "XCluster" {
+		log.Info("waiting for the cluster to reference an xcluster")
+		return ctrl.Result{RequeueAfter: machinePollInterval}, nil
+	}
+
+	cl := &clusterv1.XCluster{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: m.Namespace, Name: name}, cl); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to fetch xcluster instance: %w", err)
+	}
+	if !cl.Status.Ready {
+		log.Info("waiting for the xcluster to be ready")
+		return ctrl.Result{RequeueAfter: machinePollInterval}, nil
+	}
+
+	dataSecretName, _, _ := unstructured.NestedString(machine.Object, "spec", "bootstrap", "dataSecretName")
+	if dataSecretName == "" {
+		log.Info("waiting for the bootstrap data")
+		return ctrl.Result{RequeueAfter: machinePollInterval}, nil
+	}
+
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: m.Namespace, Name: dataSecretName}, secret); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to fetch the bootstrap data: %w", err)
+	}
+	userData, ok := secret.Data["value"]
+	if !ok {
+		return ctrl.Result{}, fmt.Errorf("bootstrap data secret %s has no value", dataSecretName)
+	}
+
+	valid, err := checkAttachments(ctx, r, r.Driver, r.Recorder, m, m.Spec.AdditionalNetworks, cl.Spec.Partition, cl.Spec.ProjectID)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !valid {
+		// Fixing the networks changes m, which triggers the next reconciliation.
+		log.Info("invalid additional networks")
+		return ctrl.Result{}, nil
+	}
+	networks, ips := withAttachments(toNetworks(cl.Spec.PrivateNetworkID), m.Spec.AdditionalNetworks)
+
+	// A machine created before, whose ID didn't make it into m, is adopted
+	// instead of creating another one.
+	found, err := findMachine(ctx, r.Driver, cl.Spec.Partition, cl.Spec.ProjectID, xmachineTag(m))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if found != nil {
+		base := m.DeepCopy()
+		m.SetMachineID(*found.ID)
+		if err := r.Patch(ctx, m, client.MergeFrom(base), fieldOwner); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update the providerID of the xmachine: %w", err)
+		}
+		log.Info("metal-stack machine found", "machine", *found.ID)
+		return ctrl.Result{RequeueAfter: machinePollInterval}, nil
+	}
+
+	resp, err := r.Driver.MachineCreate(ctx, &metalgo.MachineCreateRequest{
+		Name:          m.Name,
+		Hostname:      m.Name,
+		Size:          m.Spec.Size,
+		Project:       cl.Spec.ProjectID,
+		Partition:     cl.Spec.Partition,
+		Image:         m.Spec.Image,
+		SSHPublicKeys: m.Spec.SSHPublicKeys,
+		Networks:      networks,
+		IPs:           ips,
+		UserData:      string(userData),
+		Tags:          append(append([]string{clusterNameLabel + "=" + cluster.GetName(), xmachineTag(m)}, m.Spec.Tags...), networkTags(m.Spec.AdditionalNetworks)...),
+	})
+	if failedPermanently(err) {
+		base := m.DeepCopy()
+		m.SetFailure("CreateError", err.Error())
+		if err := r.Status().Patch(ctx, m, mergeFromWithLock(base), fieldOwner); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update the failure of the xmachine: %w", err)
+		}
+	}
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to create metal-stack machine: %w", err)
+	}
+	log.Info("metal-stack machine created")
+	if metal.IsDryRunID(*resp.Machine.ID) {
+		// Made-up IDs aren't recorded, see realID.
+		return ctrl.Result{RequeueAfter: machinePollInterval}, nil
+	}
+
+	base := m.DeepCopy()
+	m.SetMachineID(*resp.Machine.ID)
+	if err := r.Patch(ctx, m, client.MergeFrom(base), fieldOwner); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update the providerID of the xmachine: %w", err)
+	}
+
+	return ctrl.Result{RequeueAfter: machinePollInterval}, nil
+}
+
+// UpdateMachineStatus copies the addresses and the readiness of the
+// metal-stack machine to the status of m.
+func (r *XMachineReconciler) UpdateMachineStatus(ctx context.Context, m *clusterv1.XMachine, log logr.Logger) (ctrl.Result, error) {
+	resp, err := r.Driver.MachineGet(ctx, m.MachineID())
+	if metal.IsNotFound(err) {
+		base := m.DeepCopy()
+		m.Status.Ready = false
+		m.SetFailure("MachineNotFound", fmt.Sprintf("metal-stack machine %s does not exist anymore", m.MachineID()))
+		if err := r.Status().Patch(ctx, m, mergeFromWithLock(base), fieldOwner); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update the failure of the xmachine: %w", err)
+		}
+		log.Info("metal-stack machine vanished")
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to fetch metal-stack machine: %w", err)
+	}
+
+	status := m.Status.DeepCopy()
+	status.Addresses = machineAddresses(resp.Machine)
+	status.Networks = networkStatuses(resp.Machine)
+	if a := resp.Machine.Allocation; a != nil && a.Succeeded != nil {
+		status.Ready = *a.Succeeded
+	}
+	if !equality.Semantic.DeepEqual(status, &m.Status) {
+		base := m.DeepCopy()
+		m.Status = *status
+		if err := r.Status().Patch(ctx, m, mergeFromWithLock(base), fieldOwner); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update the status of the xmachine: %w", err)
+		}
+		log.Info("xmachine status updated", "ready", m.Status.Ready)
+	}
+
+	if !m.Status.Ready {
+		return ctrl.Result{RequeueAfter: machinePollInterval}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *XMachineReconciler) ReconcileDeletion(ctx context.Context, m *clusterv1.XMachine, cluster *unstructured.Unstructured, log logr.Logger) (ctrl.Result, error) {
+	id := m.MachineID()
+	if !realID(id) {
+		// The ID of a machine whose creation response got lost didn't make
+		// it into m, so the machine is looked up by its tag.
+		id = ""
+		machine, err := r.findTaggedMachine(ctx, m, cluster)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if machine != nil {
+			id = *machine.ID
+		}
+	}
+	if id != "" {
+		if _, err := r.Driver.MachineDelete(ctx, id); err != nil && !metal.IsNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("failed to delete metal-stack machine: %w", err)
+		}
+		log.Info("metal-stack machine deleted", "machine", id)
+	}
+
+	base := m.DeepCopy()
+	m.RemoveFinalizer(clusterv1.XMachineFinalizer)
+	if err := r.Patch(ctx, m, mergeFromWithLock(base), fieldOwner); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to remove xmachine finalizer: %w", err)
+	}
+	log.Info("finalizer removed")
+
+	return ctrl.Result{}, nil
+}
+
+// findTaggedMachine returns the metal-stack machine tagged with m in the partition
+// and project of the xcluster of cluster, or nil if there is none or the
+// xcluster is unknown.
+func (r *XMachineReconciler) findTaggedMachine(ctx context.Context, m *clusterv1.XMachine, cluster *unstructured.Unstructured) (*models.V1MachineResponse, error) {
+	if cluster == nil {
+		return nil, nil
+	}
+	name, _, _ := unstructured.NestedString(cluster.Object, "spec", "infrastructureRef", "name")
+	kind, _, _ := unstructured.NestedString(cluster.Object, "spec", "infrastructureRef", "kind")
+	if name == "" || kind != "XCluster" {
+		return nil, nil
+	}
+
+	cl := &clusterv1.XCluster{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: m.Namespace, Name: name}, cl); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch xcluster instance: %w", err)
+	}
+	return findMachine(ctx, r.Driver, cl.Spec.Partition, cl.Spec.ProjectID, xmachineTag(m))
+}
+
+// machineAddresses returns the hostname and the IPs of the allocation of m.
+func machineAddresses(m *models.V1MachineResponse) []clusterv1.MachineAddress {
+	a := m.Allocation
+	if a == nil {
+		return nil
+	}
+
+	var addresses []clusterv1.MachineAddress
+	if hostname := metalgo.StrDeref(a.Hostname); hostname != "" {
+		addresses = append(addresses, clusterv1.MachineAddress{Type: clusterv1.MachineHostName, Address: hostname})
+	}
+	for _, n := range a.Networks {
+		t := clusterv1.MachineExternalIP
+		if n.Private != nil && *n.Private {
+			t = clusterv1.MachineInternalIP
+		}
+		for _, ip := range n.Ips {
+			addresses = append(addresses, clusterv1.MachineAddress{Type: t, Address: ip})
+		}
+	}
+	return addresses
+}
+
+// xmachinePhase derives the phase of m after a reconciliation which returned err.
+func xmachinePhase(m *clusterv1.XMachine, err error) clusterv1.Phase {
+	switch {
+	case m.IsBeingDeleted():
+		return clusterv1.PhaseDeleting
+	case failedPermanently(err), m.Status.FailureReason != nil, networksInvalid(m):
+		return clusterv1.PhaseFailed
+	case m.Status.Ready:
+		return clusterv1.PhaseReady
+	case !m.HasFinalizer(clusterv1.XMachineFinalizer):
+		return clusterv1.PhasePending
+	default:
+		return clusterv1.PhaseProvisioningMachine
+	}
+}
+
+func (r *XMachineReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&clusterv1.XMachine{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Complete(r)
+}
@@ -0,0 +1,175 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	metalgo "github.com/metal-stack/metal-go"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+	"github.com/LimKianAn/xcluster/metal/fake"
+)
+
+// capiVersion is the version of the Cluster API objects the specs create.
+const capiVersion = capiGroup + "/v1alpha3"
+
+// newCAPIObject returns a Cluster API object of the given kind named like cl.
+func newCAPIObject(kind string, cl *clusterv1.XCluster, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	obj.SetAPIVersion(capiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(cl.Namespace)
+	obj.SetName(cl.Name)
+	return obj
+}
+
+// newXMachine creates the Cluster of cl and a Machine of it with its bootstrap
+// data and returns an xmachine owned by the Machine.
+func newXMachine(cl *clusterv1.XCluster) *clusterv1.XMachine {
+	ctx := context.Background()
+
+	cluster := newCAPIObject("Cluster", cl, map[string]interface{}{
+		"infrastructureRef": map[string]interface{}{
+			"apiVersion": clusterv1.GroupVersion.String(),
+			"kind":       "XCluster",
+			"name":       cl.Name,
+		},
+	})
+	Expect(k8sClient.Create(ctx, cluster)).To(Succeed())
+
+	secret := &corev1.Secret{Data: map[string][]byte{"value": []byte("#cloud-config")}}
+	secret.Name = cl.Name + "-bootstrap"
+	secret.Namespace = cl.Namespace
+	Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+
+	machine := newCAPIObject("Machine", cl, map[string]interface{}{
+		"clusterName": cl.Name,
+		"bootstrap":   map[string]interface{}{"dataSecretName": secret.Name},
+	})
+	machine.SetLabels(map[string]string{clusterNameLabel: cl.Name})
+	Expect(k8sClient.Create(ctx, machine)).To(Succeed())
+
+	m := &clusterv1.XMachine{}
+	m.Name = cl.Name
+	m.Namespace = cl.Namespace
+	m.Spec.Size = "v1-small-x86"
+	m.Spec.Image = "ubuntu-20.04"
+	m.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: capiVersion,
+		Kind:       "Machine",
+		Name:       machine.GetName(),
+		UID:        machine.GetUID(),
+	}}
+	return m
+}
+
+var _ = Describe("XMachine", func() {
+	var (
+		cl *clusterv1.XCluster
+		m  *clusterv1.XMachine
+	)
+
+	BeforeEach(func() {
+		cl = newXCluster()
+		waitForReady(cl)
+		m = newXMachine(cl)
+	})
+
+	AfterEach(func() {
+		metalAPI.ClearFaults()
+	})
+
+	ready := func() (bool, error) {
+		err := k8sClient.Get(context.Background(), keyOf(m), m)
+		return m.Status.Ready, err
+	}
+
+	It("creates the machine in the private network of the xcluster and gets ready", func() {
+		Expect(k8sClient.Create(context.Background(), m)).To(Succeed())
+
+		Eventually(ready, timeout, interval).Should(BeTrue())
+		Expect(m.Status.Phase).To(Equal(clusterv1.PhaseReady))
+		Expect(metalAPI.Machines()).To(ContainElement(m.MachineID()))
+		resp, err := metalAPI.MachineGet(context.Background(), m.MachineID())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(connectedTo(networkStatuses(resp.Machine), cl.Spec.PrivateNetworkID)).To(BeTrue())
+		Expect(metalgo.StrDeref(resp.Machine.Allocation.Project)).To(Equal(testProject))
+	})
+
+	It("deletes the machine once deleted", func() {
+		Expect(k8sClient.Create(context.Background(), m)).To(Succeed())
+		Eventually(ready, timeout, interval).Should(BeTrue())
+		machineID := m.MachineID()
+
+		Expect(k8sClient.Delete(context.Background(), m)).To(Succeed())
+
+		Eventually(func() bool {
+			return errors.IsNotFound(k8sClient.Get(context.Background(), keyOf(m), m))
+		}, timeout, interval).Should(BeTrue())
+		Expect(metalAPI.Machines()).ToNot(ContainElement(machineID))
+	})
+
+	It("deletes a machine whose creation response got lost once deleted", func() {
+		// The machine is created but its ID is lost, and it isn't found again
+		// by the lookups which follow the first one.
+		metalAPI.Inject(fake.Fault{Op: "MachineCreate", Nth: 1, Drop: true})
+		metalAPI.Inject(fake.Fault{Op: "MachineFind", After: 1, Class: metal.Transient})
+		before := metalAPI.Machines()
+		Expect(k8sClient.Create(context.Background(), m)).To(Succeed())
+
+		Eventually(metalAPI.Machines, timeout, interval).Should(HaveLen(len(before) + 1))
+		var lost string
+		for _, id := range metalAPI.Machines() {
+			if !containsString(before, id) {
+				lost = id
+			}
+		}
+		Expect(k8sClient.Get(context.Background(), keyOf(m), m)).To(Succeed())
+		Expect(m.MachineID()).To(BeEmpty())
+
+		Expect(k8sClient.Delete(context.Background(), m)).To(Succeed())
+		Consistently(func() error {
+			return k8sClient.Get(context.Background(), keyOf(m), m)
+		}, time.Second, interval).Should(Succeed())
+		Expect(metalAPI.Machines()).To(ContainElement(lost))
+
+		metalAPI.ClearFaults("MachineFind")
+		Eventually(func() bool {
+			return errors.IsNotFound(k8sClient.Get(context.Background(), keyOf(m), m))
+		}, timeout, interval).Should(BeTrue())
+		Expect(metalAPI.Machines()).ToNot(ContainElement(lost))
+	})
+})
+
+// containsString reports whether ss contains s.
+func containsString(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,322 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	metalgo "github.com/metal-stack/metal-go"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+	"github.com/LimKianAn/xcluster/tracing"
+)
+
+// xnetworkLabel marks the metal-stack network allocated for an xnetwork with
+// the namespace and name of the xnetwork, so that it is found again.
+const xnetworkLabel = "cluster.www.x-cellent.com/xnetwork"
+
+// XNetworkReconciler reconciles a XNetwork object
+type XNetworkReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	Driver metal.Client
+
+	// Recorder, if set, receives an event for every metal-api call skipped in
+	// dry-run mode and for every drift.
+	Recorder record.EventRecorder
+
+	// ResyncPeriod is how often the network of a ready xnetwork is checked
+	// for drift. Zero disables the periodic check.
+	ResyncPeriod time.Duration
+
+	// MaxConcurrentReconciles is how many xnetworks are reconciled at once.
+	// Zero means one.
+	MaxConcurrentReconciles int
+}
+
+// +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xnetworks,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xnetworks/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xprojects,verbs=get;list;watch
+
+func (r *XNetworkReconciler) Reconcile(req ctrl.Request) (result ctrl.Result, err error) {
+	ctx, span := tracing.Start(context.Background(), "XNetwork.Reconcile", tracing.String("xnetwork", req.NamespacedName.String()))
+	defer func() { span.RecordError(err); span.End() }()
+	log := tracing.Logger(ctx, r.Log.WithValues("xnetwork", req.NamespacedName))
+
+	// Fetch XNetwork instance
+	n := &clusterv1.XNetwork{}
+	if err := r.Get(ctx, req.NamespacedName, n); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	ctx = withDryRunRecorder(ctx, r.Recorder, n)
+	defer func() { result, err = updateStatus(ctx, r, n, xnetworkPhase(n, err), result, err) }()
+
+	if n.IsBeingDeleted() {
+		return r.ReconcileDeletion(ctx, n, log)
+	}
+
+	// Add finalizer if none.
+	if !n.HasFinalizer(clusterv1.XNetworkFinalizer) {
+		base := n.DeepCopy()
+		n.AddFinalizer(clusterv1.XNetworkFinalizer)
+		if err := r.Patch(ctx, n, mergeFromWithLock(base), fieldOwner); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update xnetwork finalizer: %w", err)
+		}
+		log.Info("finalizer added")
+	}
+
+	if !realID(n.Spec.ProjectID) {
+		if ok, err := defaultProject(ctx, r, r.Recorder, n, n.Namespace, &n.Spec.ProjectID); err != nil || !ok {
+			// Updates of the namespace or its xproject trigger the next reconciliation.
+			return ctrl.Result{}, err
+		}
+		log.Info("projectID defaulted", "project", n.Spec.ProjectID)
+	}
+
+	if !realID(n.Spec.NetworkID) {
+		return r.AllocateNetwork(ctx, n, log)
+	}
+
+	drifted, err := r.checkNetwork(ctx, n, log)
+	if err != nil || drifted {
+		return ctrl.Result{RequeueAfter: r.ResyncPeriod}, err
+	}
+
+	if !n.Status.Ready {
+		base := n.DeepCopy()
+		n.Status.Ready = true
+		if err := r.Status().Patch(ctx, n, mergeFromWithLock(base), fieldOwner); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update the readiness of the xnetwork: %w", err)
+		}
+		log.Info("xnetwork status updated as ready")
+	}
+
+	return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+}
+
+// AllocateNetwork allocates the metal-stack network of n unless it can't be
+// allocated as specified.
+func (r *XNetworkReconciler) AllocateNetwork(ctx context.Context, n *clusterv1.XNetwork, log logr.Logger) (ctrl.Result, error) {
+	problem, err := invalidPrivateNetwork(ctx, r.Driver, n.Spec.PrivateNetworkSpec, n.Spec.Partition)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if problem != "" {
+		log.Info("invalid network", "problem", problem)
+		if r.Recorder != nil {
+			r.Recorder.Event(n, corev1.EventTypeWarning, "InvalidNetwork", problem)
+		}
+	}
+	base := n.DeepCopy()
+	if n.SetCondition(privateNetworkCondition(problem, "Invalid")) {
+		if err := r.Status().Patch(ctx, n, mergeFromWithLock(base), fieldOwner); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update the network condition of the xnetwork: %w", err)
+		}
+	}
+	if problem != "" {
+		// An invalid network has to be fixed in the spec, which triggers the next reconciliation.
+		return ctrl.Result{}, nil
+	}
+
+	network, err := allocateNetwork(ctx, r.Driver, &metalgo.NetworkAllocateRequest{
+		Name:        n.Name,
+		Description: "xnetwork " + n.Namespace + "/" + n.Name,
+		PartitionID: n.Spec.Partition,
+		ProjectID:   n.Spec.ProjectID,
+		Shared:      n.Spec.Shared,
+		Labels:      n.Spec.Labels,
+	}, xnetworkLabel, n.Namespace+"/"+n.Name)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to allocate metal-stack network: %w", err)
+	}
+	log.Info("metal-stack network allocated", "network", metalgo.StrDeref(network.ID))
+	if metal.IsDryRunID(*network.ID) {
+		// Made-up IDs aren't recorded, see realID.
+		return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+	}
+
+	// The network is recorded as allocated before its ID, so that it's freed
+	// even if its ID is only recorded by the next reconciliation.
+	base = n.DeepCopy()
+	n.Status.Allocated = true
+	if err := r.Status().Patch(ctx, n, mergeFromWithLock(base), fieldOwner); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to record the allocation of the xnetwork: %w", err)
+	}
+
+	base = n.DeepCopy()
+	n.Spec.NetworkID = *network.ID
+	if err := r.Patch(ctx, n, client.MergeFrom(base), fieldOwner); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update the networkID of the xnetwork: %w", err)
+	}
+
+	base = n.DeepCopy()
+	n.Status.Ready = true
+	n.Status.Prefixes = network.Prefixes
+	if err := r.Status().Patch(ctx, n, mergeFromWithLock(base), fieldOwner); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update the status of the xnetwork: %w", err)
+	}
+
+	return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+}
+
+// checkNetwork marks n Drifted if its network vanished or changed out of
+// band. Under the Recreate policy it drops the network, so that a new one is
+// allocated. Otherwise it records the prefixes of the network and whether it
+// still matches the spec.
+func (r *XNetworkReconciler) checkNetwork(ctx context.Context, n *clusterv1.XNetwork, log logr.Logger) (drifted bool, err error) {
+	d, network, err := networkDrift(ctx, r.Driver, n.Spec.NetworkID, n.Spec.Partition, n.Spec.ProjectID)
+	if err != nil {
+		return false, err
+	}
+
+	base := n.DeepCopy()
+	changed := n.SetCondition(d.condition())
+	if !d.drifted() {
+		if !equality.Semantic.DeepEqual(network.Prefixes, n.Status.Prefixes) {
+			n.Status.Prefixes = network.Prefixes
+			changed = true
+		}
+		mismatch := privateNetworkMismatch(n.Spec.PrivateNetworkSpec, network)
+		if n.SetCondition(privateNetworkCondition(mismatch, "Mismatch")) {
+			changed = true
+			if mismatch != "" && r.Recorder != nil {
+				r.Recorder.Event(n, corev1.EventTypeWarning, "NetworkMismatch", mismatch)
+			}
+		}
+	}
+	if d.drifted() && n.Status.Ready {
+		n.Status.Ready = false
+		changed = true
+	}
+	if changed {
+		if err := r.Status().Patch(ctx, n, mergeFromWithLock(base), fieldOwner); err != nil {
+			return false, fmt.Errorf("failed to update the drift of the xnetwork: %w", err)
+		}
+	}
+	if !d.drifted() {
+		return false, nil
+	}
+
+	log.Info("metal-stack network drifted", "reason", d.Reason, "policy", n.Spec.DriftPolicy)
+	if r.Recorder != nil {
+		r.Recorder.Event(n, corev1.EventTypeWarning, d.Reason, d.Message)
+	}
+	if !recreate(n.Spec.DriftPolicy) {
+		return true, nil
+	}
+
+	base = n.DeepCopy()
+	n.Spec.NetworkID = ""
+	if err := r.Patch(ctx, n, client.MergeFrom(base), fieldOwner); err != nil {
+		return false, fmt.Errorf("failed to reset the networkID of the xnetwork: %w", err)
+	}
+	log.Info("drifted metal-stack network dropped to be recreated")
+
+	return true, nil
+}
+
+func (r *XNetworkReconciler) ReconcileDeletion(ctx context.Context, n *clusterv1.XNetwork, log logr.Logger) (ctrl.Result, error) {
+	if realID(n.Spec.NetworkID) && n.Frees() {
+		users, err := networkUsers(ctx, r.Driver, n.Spec.NetworkID)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if len(users) > 0 {
+			log.Info("waiting for the machines in the metal-stack network to be deleted", "machines", users)
+			return ctrl.Result{RequeueAfter: inUsePollInterval}, nil
+		}
+		if _, err := r.Driver.NetworkFree(ctx, n.Spec.NetworkID); err != nil && !metal.IsNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("failed to free metal-stack network: %w", err)
+		}
+		log.Info("metal-stack network freed")
+	}
+
+	base := n.DeepCopy()
+	n.RemoveFinalizer(clusterv1.XNetworkFinalizer)
+	if err := r.Patch(ctx, n, mergeFromWithLock(base), fieldOwner); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to remove xnetwork finalizer: %w", err)
+	}
+	log.Info("finalizer removed")
+
+	return ctrl.Result{}, nil
+}
+
+// xnetworkPhase derives the phase of n after a reconciliation which returned err.
+func xnetworkPhase(n *clusterv1.XNetwork, err error) clusterv1.Phase {
+	switch {
+	case n.IsBeingDeleted():
+		return clusterv1.PhaseDeleting
+	case failedPermanently(err), privateNetworkInvalid(n):
+		return clusterv1.PhaseFailed
+	case markedDrifted(n):
+		return clusterv1.PhaseDrifted
+	case n.Status.Ready:
+		return clusterv1.PhaseReady
+	case !n.HasFinalizer(clusterv1.XNetworkFinalizer), !realID(n.Spec.ProjectID):
+		return clusterv1.PhasePending
+	default:
+		return clusterv1.PhaseAllocatingNetwork
+	}
+}
+
+// networksWithoutProject maps a namespace or an xproject to the xnetworks in
+// the namespace waiting for their projectID to be defaulted.
+func (r *XNetworkReconciler) networksWithoutProject(obj handler.MapObject) []reconcile.Request {
+	networks := &clusterv1.XNetworkList{}
+	if err := r.List(context.Background(), networks, client.InNamespace(projectNamespace(obj))); err != nil {
+		r.Log.Error(err, "failed to list xnetworks", "namespace", projectNamespace(obj))
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, n := range networks.Items {
+		if !realID(n.Spec.ProjectID) {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: n.Namespace, Name: n.Name}})
+		}
+	}
+	return requests
+}
+
+func (r *XNetworkReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&clusterv1.XNetwork{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Watches(&source.Kind{Type: &clusterv1.XProject{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.networksWithoutProject),
+		}).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.networksWithoutProject),
+		}).
+		Complete(r)
+}
@@ -0,0 +1,81 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	metalgo "github.com/metal-stack/metal-go"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+)
+
+var networks int
+
+var _ = Describe("XNetwork", func() {
+	var n *clusterv1.XNetwork
+
+	BeforeEach(func() {
+		networks++
+		n = &clusterv1.XNetwork{}
+		n.Name = fmt.Sprintf("xnetwork-%d", networks)
+		n.Namespace = "default"
+		n.Spec.Partition = testPartition
+		n.Spec.ProjectID = testProject
+	})
+
+	ready := func() (bool, error) {
+		err := k8sClient.Get(context.Background(), keyOf(n), n)
+		return n.Status.Ready, err
+	}
+	deleted := func() bool {
+		return errors.IsNotFound(k8sClient.Get(context.Background(), keyOf(n), n))
+	}
+
+	It("frees the network it allocated once deleted", func() {
+		Expect(k8sClient.Create(context.Background(), n)).To(Succeed())
+		Eventually(ready, timeout, interval).Should(BeTrue())
+		Expect(n.Status.Allocated).To(BeTrue())
+		networkID := n.Spec.NetworkID
+
+		Expect(k8sClient.Delete(context.Background(), n)).To(Succeed())
+		Eventually(deleted, timeout, interval).Should(BeTrue())
+		Expect(metalAPI.Networks()).ToNot(ContainElement(networkID))
+	})
+
+	It("keeps an adopted network once deleted", func() {
+		resp, err := metalAPI.NetworkAllocate(context.Background(), &metalgo.NetworkAllocateRequest{
+			Name:        "adopted",
+			PartitionID: testPartition,
+			ProjectID:   testProject,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		networkID := metalgo.StrDeref(resp.Network.ID)
+		n.Spec.NetworkID = networkID
+		Expect(k8sClient.Create(context.Background(), n)).To(Succeed())
+		Eventually(ready, timeout, interval).Should(BeTrue())
+		Expect(n.Status.Allocated).To(BeFalse())
+
+		Expect(k8sClient.Delete(context.Background(), n)).To(Succeed())
+		Eventually(deleted, timeout, interval).Should(BeTrue())
+		Expect(metalAPI.Networks()).To(ContainElement(networkID))
+	})
+})
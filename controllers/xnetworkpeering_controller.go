@@ -0,0 +1,355 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+	"github.com/LimKianAn/xcluster/tracing"
+)
+
+// peeringPollInterval is how often an xnetworkpeering is reconciled while it
+// waits for the private network of the peer xcluster, which isn't watched.
+const peeringPollInterval = 15 * time.Second
+
+// XNetworkPeeringReconciler reconciles a XNetworkPeering object
+type XNetworkPeeringReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	Driver metal.Client
+
+	// Recorder, if set, receives an event for every metal-api call skipped in
+	// dry-run mode and for invalid peer networks.
+	Recorder record.EventRecorder
+
+	// MaxConcurrentReconciles is how many xnetworkpeerings are reconciled at once.
+	// Zero means one.
+	MaxConcurrentReconciles int
+}
+
+// +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xnetworkpeerings,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xnetworkpeerings/status,verbs=get;update;patch
+
+func (r *XNetworkPeeringReconciler) Reconcile(req ctrl.Request) (result ctrl.Result, err error) {
+	ctx, span := tracing.Start(context.Background(), "XNetworkPeering.Reconcile", tracing.String("xnetworkpeering", req.NamespacedName.String()))
+	defer func() { span.RecordError(err); span.End() }()
+	log := tracing.Logger(ctx, r.Log.WithValues("xnetworkpeering", req.NamespacedName))
+
+	// Fetch XNetworkPeering instance
+	p := &clusterv1.XNetworkPeering{}
+	if err := r.Get(ctx, req.NamespacedName, p); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	ctx = withDryRunRecorder(ctx, r.Recorder, p)
+	defer func() { result, err = updateStatus(ctx, r, p, xnetworkpeeringPhase(p, err), result, err) }()
+
+	if p.IsBeingDeleted() {
+		return r.ReconcileDeletion(ctx, p, log)
+	}
+
+	// Add finalizer if none.
+	if !p.HasFinalizer(clusterv1.XNetworkPeeringFinalizer) {
+		base := p.DeepCopy()
+		p.AddFinalizer(clusterv1.XNetworkPeeringFinalizer)
+		if err := r.Patch(ctx, p, mergeFromWithLock(base), fieldOwner); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update xnetworkpeering finalizer: %w", err)
+		}
+		log.Info("finalizer added")
+	}
+
+	cl := &clusterv1.XCluster{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: p.Namespace, Name: p.Spec.ClusterName}, cl); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to fetch xcluster instance: %w", err)
+	}
+	peer := &clusterv1.XCluster{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: p.Namespace, Name: p.Spec.PeerClusterName}, peer); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to fetch peer xcluster instance: %w", err)
+	}
+	if cl.IsBeingDeleted() || peer.IsBeingDeleted() {
+		// The xcluster being deleted deletes the peering.
+		return ctrl.Result{}, nil
+	}
+	if !realID(peer.Spec.PrivateNetworkID) {
+		log.Info("waiting for the private network of the peer")
+		return ctrl.Result{RequeueAfter: peeringPollInterval}, nil
+	}
+
+	if valid, err := r.checkPeerNetwork(ctx, p, cl, peer.Spec.PrivateNetworkID, log); err != nil || !valid {
+		// An invalid peering has to be fixed in the spec, which triggers the next reconciliation.
+		return ctrl.Result{}, err
+	}
+
+	// The network is recorded first, so that it is detached even if the
+	// peering is deleted right after.
+	networkID := peer.Spec.PrivateNetworkID
+	if p.Status.NetworkID != networkID {
+		base := p.DeepCopy()
+		p.Status.NetworkID = networkID
+		p.Status.Attached = false
+		if err := r.Status().Patch(ctx, p, mergeFromWithLock(base), fieldOwner); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update the network of the xnetworkpeering: %w", err)
+		}
+	}
+
+	fw := &clusterv1.XFirewall{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: cl.Namespace, Name: cl.Name}, fw); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("failed to fetch xfirewall instance: %w", err)
+		}
+		// Its creation triggers the next reconciliation.
+		log.Info("waiting for the xfirewall")
+		return ctrl.Result{}, nil
+	}
+
+	if !attachedTo(fw.Spec.AdditionalNetworks, networkID) {
+		base := fw.DeepCopy()
+		fw.Spec.AdditionalNetworks = append(fw.Spec.AdditionalNetworks, clusterv1.NetworkAttachment{NetworkID: networkID})
+		if err := r.Patch(ctx, fw, mergeFromWithLock(base), fieldOwner); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to attach the xfirewall to the peer network: %w", err)
+		}
+		log.Info("xfirewall attached to the peer network", "network", networkID)
+	}
+
+	// Updates of the xfirewall trigger the next reconciliation until it is
+	// attached. Under the Report NetworkChangePolicy it never is, since
+	// attaching the network takes a new firewall.
+	attached := fw.Status.Ready && connectedTo(fw.Status.Networks, networkID)
+	cond := clusterv1.Condition{
+		Type:   clusterv1.NetworksAttached,
+		Status: corev1.ConditionTrue,
+		Reason: "Attached",
+	}
+	switch {
+	case attached:
+	case recreationRequired(fw):
+		cond.Status = corev1.ConditionFalse
+		cond.Reason = "RecreationRequired"
+		cond.Message = fmt.Sprintf("xfirewall %s has to be recreated to attach the peer network %s, "+
+			"set its spec.networkChangePolicy to Recreate", fw.Name, networkID)
+	default:
+		cond.Status = corev1.ConditionFalse
+		cond.Reason = "Attaching"
+	}
+	base := p.DeepCopy()
+	changed := p.SetCondition(cond)
+	if p.Status.Attached != attached {
+		p.Status.Attached = attached
+		changed = true
+	}
+	if changed {
+		if err := r.Status().Patch(ctx, p, mergeFromWithLock(base), fieldOwner); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update the status of the xnetworkpeering: %w", err)
+		}
+		log.Info("xnetworkpeering status updated", "attached", attached, "reason", cond.Reason)
+		if cond.Reason == "RecreationRequired" && r.Recorder != nil {
+			r.Recorder.Event(p, corev1.EventTypeWarning, cond.Reason, cond.Message)
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// checkPeerNetwork checks that the firewall of cl may be attached to the
+// network with the given id and records the outcome in the NetworksValid
+// condition of p. It reports whether it may.
+func (r *XNetworkPeeringReconciler) checkPeerNetwork(ctx context.Context, p *clusterv1.XNetworkPeering, cl *clusterv1.XCluster, id string, log logr.Logger) (bool, error) {
+	var problem string
+	if p.Spec.ClusterName == p.Spec.PeerClusterName {
+		problem = fmt.Sprintf("xcluster %s can't peer with itself", p.Spec.ClusterName)
+	} else {
+		var err error
+		problem, err = invalidAttachments(ctx, r.Driver, []clusterv1.NetworkAttachment{{NetworkID: id}}, cl.Spec.Partition, cl.Spec.ProjectID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	cond := clusterv1.Condition{
+		Type:   clusterv1.NetworksValid,
+		Status: corev1.ConditionTrue,
+		Reason: "Validated",
+	}
+	if problem != "" {
+		cond.Status = corev1.ConditionFalse
+		cond.Reason = "Invalid"
+		cond.Message = problem
+		log.Info("invalid peering", "problem", problem)
+		if r.Recorder != nil {
+			r.Recorder.Event(p, corev1.EventTypeWarning, "InvalidPeering", problem)
+		}
+	}
+	base := p.DeepCopy()
+	if p.SetCondition(cond) {
+		if err := r.Status().Patch(ctx, p, mergeFromWithLock(base), fieldOwner); err != nil {
+			return false, fmt.Errorf("failed to update the network condition of the xnetworkpeering: %w", err)
+		}
+	}
+	return problem == "", nil
+}
+
+// ReconcileDeletion detaches the firewall from the peer network and waits
+// until it has been recreated without it, so that the peer network can be
+// freed afterwards. A firewall which goes away with its xcluster anyway is
+// left alone, and so is one which is never recreated under the Report
+// NetworkChangePolicy: it keeps the peer network until it's recreated for
+// another reason.
+func (r *XNetworkPeeringReconciler) ReconcileDeletion(ctx context.Context, p *clusterv1.XNetworkPeering, log logr.Logger) (ctrl.Result, error) {
+	cl := &clusterv1.XCluster{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: p.Namespace, Name: p.Spec.ClusterName}, cl)
+	if client.IgnoreNotFound(err) != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to fetch xcluster instance: %w", err)
+	}
+	clusterGone := err != nil || cl.IsBeingDeleted()
+
+	if networkID := p.Status.NetworkID; networkID != "" && !clusterGone {
+		fw := &clusterv1.XFirewall{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: p.Namespace, Name: p.Spec.ClusterName}, fw)
+		if client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to fetch xfirewall instance: %w", err)
+		}
+		if err == nil && !fw.IsBeingDeleted() {
+			if attachedTo(fw.Spec.AdditionalNetworks, networkID) {
+				base := fw.DeepCopy()
+				fw.Spec.AdditionalNetworks = detach(fw.Spec.AdditionalNetworks, networkID)
+				if err := r.Patch(ctx, fw, mergeFromWithLock(base), fieldOwner); err != nil {
+					return ctrl.Result{}, fmt.Errorf("failed to detach the xfirewall from the peer network: %w", err)
+				}
+				log.Info("xfirewall detached from the peer network", "network", networkID)
+			}
+			if realID(fw.Spec.MachineID) && connectedTo(fw.Status.Networks, networkID) {
+				if !recreationRequired(fw) {
+					// Updates of the xfirewall trigger the next reconciliation.
+					log.Info("waiting for the firewall to be detached")
+					return ctrl.Result{}, nil
+				}
+				log.Info("firewall left attached to the peer network until recreated", "network", networkID)
+				if r.Recorder != nil {
+					r.Recorder.Eventf(p, corev1.EventTypeWarning, "RecreationRequired",
+						"xfirewall %s stays attached to the peer network %s until it is recreated", fw.Name, networkID)
+				}
+			}
+		}
+	}
+
+	base := p.DeepCopy()
+	p.RemoveFinalizer(clusterv1.XNetworkPeeringFinalizer)
+	if err := r.Patch(ctx, p, mergeFromWithLock(base), fieldOwner); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to remove xnetworkpeering finalizer: %w", err)
+	}
+	log.Info("finalizer removed")
+
+	return ctrl.Result{}, nil
+}
+
+// attachedTo reports whether attachments contain the network with the given id.
+func attachedTo(attachments []clusterv1.NetworkAttachment, id string) bool {
+	for _, a := range attachments {
+		if a.NetworkID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// detach removes the network with the given id from attachments.
+func detach(attachments []clusterv1.NetworkAttachment, id string) (out []clusterv1.NetworkAttachment) {
+	for _, a := range attachments {
+		if a.NetworkID != id {
+			out = append(out, a)
+		}
+	}
+	return
+}
+
+// connectedTo reports whether networks contain the network with the given id.
+func connectedTo(networks []clusterv1.NetworkStatus, id string) bool {
+	for _, n := range networks {
+		if n.NetworkID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// recreationRequired reports whether fw waits to be recreated to attach or
+// detach changed networks, which it never is under the Report
+// NetworkChangePolicy.
+func recreationRequired(fw *clusterv1.XFirewall) bool {
+	c := fw.GetCondition(clusterv1.NetworksAttached)
+	return c != nil && c.Status == corev1.ConditionFalse && c.Reason == "RecreationRequired"
+}
+
+// xnetworkpeeringPhase derives the phase of p after a reconciliation which returned err.
+func xnetworkpeeringPhase(p *clusterv1.XNetworkPeering, err error) clusterv1.Phase {
+	switch {
+	case p.IsBeingDeleted():
+		return clusterv1.PhaseDeleting
+	case failedPermanently(err), networksInvalid(p):
+		return clusterv1.PhaseFailed
+	case p.Status.Attached:
+		return clusterv1.PhaseReady
+	case p.Status.NetworkID == "":
+		return clusterv1.PhasePending
+	default:
+		return clusterv1.PhaseAttachingNetwork
+	}
+}
+
+// peeringsOf maps an xfirewall to the xnetworkpeerings attaching it.
+func (r *XNetworkPeeringReconciler) peeringsOf(obj handler.MapObject) []reconcile.Request {
+	peerings := &clusterv1.XNetworkPeeringList{}
+	if err := r.List(context.Background(), peerings, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "failed to list xnetworkpeerings", "xfirewall", obj.Meta.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, p := range peerings.Items {
+		if p.Spec.ClusterName == obj.Meta.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: p.Namespace, Name: p.Name}})
+		}
+	}
+	return requests
+}
+
+func (r *XNetworkPeeringReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&clusterv1.XNetworkPeering{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Watches(&source.Kind{Type: &clusterv1.XFirewall{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.peeringsOf),
+		}).
+		Complete(r)
+}
@@ -0,0 +1,122 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+)
+
+var _ = Describe("XNetworkPeering", func() {
+	var (
+		cl, peer *clusterv1.XCluster
+		p        *clusterv1.XNetworkPeering
+	)
+
+	BeforeEach(func() {
+		cl = newXCluster()
+		peer = newXCluster()
+	})
+
+	JustBeforeEach(func() {
+		waitForReady(cl)
+		waitForReady(peer)
+
+		p = &clusterv1.XNetworkPeering{}
+		p.Name = cl.Name + "-" + peer.Name
+		p.Namespace = cl.Namespace
+		p.Spec.ClusterName = cl.Name
+		p.Spec.PeerClusterName = peer.Name
+	})
+
+	deleted := func() bool {
+		return errors.IsNotFound(k8sClient.Get(context.Background(), keyOf(p), p))
+	}
+
+	It("reports that the firewall has to be recreated and is deleted nonetheless under the default policy", func() {
+		Expect(k8sClient.Create(context.Background(), p)).To(Succeed())
+
+		Eventually(func() (string, error) {
+			err := k8sClient.Get(context.Background(), keyOf(p), p)
+			if c := p.GetCondition(clusterv1.NetworksAttached); c != nil {
+				return c.Reason, err
+			}
+			return "", err
+		}, timeout, interval).Should(Equal("RecreationRequired"))
+		Expect(p.Status.Attached).To(BeFalse())
+		Expect(conditionStatus(p, clusterv1.NetworksAttached)).To(Equal(corev1.ConditionFalse))
+
+		Expect(k8sClient.Delete(context.Background(), p)).To(Succeed())
+		Eventually(deleted, timeout, interval).Should(BeTrue())
+		fw := &clusterv1.XFirewall{}
+		Expect(k8sClient.Get(context.Background(), keyOf(cl), fw)).To(Succeed())
+		Expect(attachedTo(fw.Spec.AdditionalNetworks, peer.Spec.PrivateNetworkID)).To(BeFalse())
+	})
+
+	Context("under the Recreate policy", func() {
+		BeforeEach(func() {
+			// Attaching the peer network takes a new firewall.
+			cl.Spec.XFirewallTemplate.Spec.NetworkChangePolicy = clusterv1.NetworkChangePolicyRecreate
+		})
+
+		It("attaches the firewall to the peer network and detaches it once deleted", func() {
+			Expect(k8sClient.Create(context.Background(), p)).To(Succeed())
+
+			Eventually(func() (bool, error) {
+				err := k8sClient.Get(context.Background(), keyOf(p), p)
+				return p.Status.Attached, err
+			}, timeout, interval).Should(BeTrue())
+			Expect(p.Status.NetworkID).To(Equal(peer.Spec.PrivateNetworkID))
+			fw := &clusterv1.XFirewall{}
+			Expect(k8sClient.Get(context.Background(), keyOf(cl), fw)).To(Succeed())
+			Expect(connectedTo(fw.Status.Networks, peer.Spec.PrivateNetworkID)).To(BeTrue())
+
+			Expect(k8sClient.Delete(context.Background(), p)).To(Succeed())
+			Eventually(deleted, timeout, interval).Should(BeTrue())
+			Expect(k8sClient.Get(context.Background(), keyOf(cl), fw)).To(Succeed())
+			Expect(attachedTo(fw.Spec.AdditionalNetworks, peer.Spec.PrivateNetworkID)).To(BeFalse())
+		})
+
+		It("is deleted once the firewall isn't recreated anymore, which keeps the peer network", func() {
+			Expect(k8sClient.Create(context.Background(), p)).To(Succeed())
+			Eventually(func() (bool, error) {
+				err := k8sClient.Get(context.Background(), keyOf(p), p)
+				return p.Status.Attached, err
+			}, timeout, interval).Should(BeTrue())
+
+			fw := &clusterv1.XFirewall{}
+			Expect(k8sClient.Get(context.Background(), keyOf(cl), fw)).To(Succeed())
+			base := fw.DeepCopy()
+			fw.Spec.NetworkChangePolicy = clusterv1.NetworkChangePolicyReport
+			Expect(k8sClient.Patch(context.Background(), fw, client.MergeFrom(base))).To(Succeed())
+
+			Expect(k8sClient.Delete(context.Background(), p)).To(Succeed())
+			Eventually(deleted, timeout, interval).Should(BeTrue())
+			Expect(k8sClient.Get(context.Background(), keyOf(cl), fw)).To(Succeed())
+			Expect(attachedTo(fw.Spec.AdditionalNetworks, peer.Spec.PrivateNetworkID)).To(BeFalse())
+			Expect(connectedTo(fw.Status.Networks, peer.Spec.PrivateNetworkID)).To(BeTrue())
+			Expect(recreationRequired(fw)).To(BeTrue())
+		})
+	})
+})
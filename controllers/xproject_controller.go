@@ -0,0 +1,195 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	mdv1 "github.com/metal-stack/masterdata-api/api/rest/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+	"github.com/LimKianAn/xcluster/metal"
+	"github.com/LimKianAn/xcluster/tracing"
+)
+
+// XProjectReconciler reconciles a XProject object
+type XProjectReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	Driver metal.Client
+
+	// Recorder, if set, receives an event for every metal-api call skipped in
+	// dry-run mode and for every drift.
+	Recorder record.EventRecorder
+
+	// ResyncPeriod is how often the project of a ready xproject is checked
+	// for drift. Zero disables the periodic check.
+	ResyncPeriod time.Duration
+
+	// MaxConcurrentReconciles is how many xprojects are reconciled at once.
+	// Zero means one.
+	MaxConcurrentReconciles int
+}
+
+// +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xprojects,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cluster.www.x-cellent.com,resources=xprojects/status,verbs=get;update;patch
+
+func (r *XProjectReconciler) Reconcile(req ctrl.Request) (result ctrl.Result, err error) {
+	ctx, span := tracing.Start(context.Background(), "XProject.Reconcile", tracing.String("xproject", req.NamespacedName.String()))
+	defer func() { span.RecordError(err); span.End() }()
+	log := tracing.Logger(ctx, r.Log.WithValues("xproject", req.NamespacedName))
+
+	// Fetch XProject instance
+	p := &clusterv1.XProject{}
+	if err := r.Get(ctx, req.NamespacedName, p); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	ctx = withDryRunRecorder(ctx, r.Recorder, p)
+	defer func() { result, err = updateStatus(ctx, r, p, xprojectPhase(p, err), result, err) }()
+
+	if !realID(p.Spec.ProjectID) {
+		return r.EnsureProject(ctx, p, log)
+	}
+
+	d, err := projectDrift(ctx, r.Driver, p.Spec.ProjectID, p.Spec.TenantID)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	base := p.DeepCopy()
+	changed := p.SetCondition(d.condition())
+	if p.Status.Ready == d.drifted() {
+		p.Status.Ready = !d.drifted()
+		changed = true
+	}
+	if changed {
+		if err := r.Status().Patch(ctx, p, mergeFromWithLock(base), fieldOwner); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update the status of the xproject: %w", err)
+		}
+	}
+	if d.drifted() {
+		log.Info("metal-stack project drifted", "reason", d.Reason)
+		if r.Recorder != nil {
+			r.Recorder.Event(p, corev1.EventTypeWarning, d.Reason, d.Message)
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+}
+
+// EnsureProject adopts the metal-stack project of the name and tenant of p or
+// creates it if there is none.
+func (r *XProjectReconciler) EnsureProject(ctx context.Context, p *clusterv1.XProject, log logr.Logger) (ctrl.Result, error) {
+	name := p.ProjectName()
+	found, err := r.Driver.ProjectFind(ctx, mdv1.ProjectFindRequest{Name: &name, TenantId: &p.Spec.TenantID})
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to find metal-stack project: %w", err)
+	}
+	projects := uniqueProjects(found.Project)
+
+	cond := clusterv1.Condition{
+		Type:   clusterv1.ProjectResolved,
+		Status: corev1.ConditionTrue,
+	}
+	var id string
+	switch len(projects) {
+	case 0:
+		description := p.Spec.Description
+		if description == "" {
+			description = "xproject " + p.Namespace + "/" + p.Name
+		}
+		resp, err := r.Driver.ProjectCreate(ctx, mdv1.ProjectCreateRequest{Project: mdv1.Project{
+			Name:        name,
+			Description: description,
+			TenantId:    p.Spec.TenantID,
+		}})
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to create metal-stack project: %w", err)
+		}
+		id = projectIDOf(resp.Project)
+		cond.Reason = "Created"
+		log.Info("metal-stack project created")
+		if metal.IsDryRunID(id) {
+			// Made-up IDs aren't recorded, see realID.
+			return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+		}
+	case 1:
+		id = projectIDOf(projects[0])
+		cond.Reason = "Found"
+		log.Info("metal-stack project found")
+	default:
+		cond.Status = corev1.ConditionFalse
+		cond.Reason = "Ambiguous"
+		cond.Message = fmt.Sprintf("%d metal-stack projects are named %q in tenant %q, set the projectID", len(projects), name, p.Spec.TenantID)
+		if r.Recorder != nil {
+			r.Recorder.Event(p, corev1.EventTypeWarning, cond.Reason, cond.Message)
+		}
+		base := p.DeepCopy()
+		if p.SetCondition(cond) {
+			if err := r.Status().Patch(ctx, p, mergeFromWithLock(base), fieldOwner); err != nil {
+				return ctrl.Result{}, fmt.Errorf("failed to update the project condition of the xproject: %w", err)
+			}
+		}
+		// The projectID has to be set in the spec, which triggers the next reconciliation.
+		return ctrl.Result{}, nil
+	}
+
+	base := p.DeepCopy()
+	p.Spec.ProjectID = id
+	if err := r.Patch(ctx, p, client.MergeFrom(base), fieldOwner); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update the projectID of the xproject: %w", err)
+	}
+
+	base = p.DeepCopy()
+	p.Status.Ready = true
+	p.SetCondition(cond)
+	if err := r.Status().Patch(ctx, p, mergeFromWithLock(base), fieldOwner); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update the status of the xproject: %w", err)
+	}
+
+	return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+}
+
+// xprojectPhase derives the phase of p after a reconciliation which returned err.
+func xprojectPhase(p *clusterv1.XProject, err error) clusterv1.Phase {
+	switch {
+	case failedPermanently(err), !realID(p.Spec.ProjectID) && projectUnresolved(p):
+		return clusterv1.PhaseFailed
+	case markedDrifted(p):
+		return clusterv1.PhaseDrifted
+	case p.Status.Ready:
+		return clusterv1.PhaseReady
+	default:
+		return clusterv1.PhasePending
+	}
+}
+
+func (r *XProjectReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&clusterv1.XProject{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Complete(r)
+}
@@ -0,0 +1,77 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+
+	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
+)
+
+var projectNamespaces int
+
+var _ = Describe("XProject", func() {
+	var p *clusterv1.XProject
+
+	BeforeEach(func() {
+		// Every xproject gets a namespace of its own, since it provides the
+		// project of the other resources in its namespace.
+		projectNamespaces++
+		ns := &corev1.Namespace{}
+		ns.Name = fmt.Sprintf("xproject-%d", projectNamespaces)
+		Expect(k8sClient.Create(context.Background(), ns)).To(Succeed())
+
+		p = &clusterv1.XProject{}
+		p.Name = "project"
+		p.Namespace = ns.Name
+		p.Spec.TenantID = "test"
+	})
+
+	projectID := func() (string, error) {
+		err := k8sClient.Get(context.Background(), keyOf(p), p)
+		return p.Spec.ProjectID, err
+	}
+
+	It("adopts the project of its name and tenant", func() {
+		p.Spec.Name = "test"
+		Expect(k8sClient.Create(context.Background(), p)).To(Succeed())
+
+		Eventually(projectID, timeout, interval).Should(Equal(testProject))
+		Eventually(func() (bool, error) {
+			err := k8sClient.Get(context.Background(), keyOf(p), p)
+			return p.Status.Ready, err
+		}, timeout, interval).Should(BeTrue())
+		Expect(p.GetCondition(clusterv1.ProjectResolved).Reason).To(Equal("Found"))
+	})
+
+	It("creates the project named like its namespace unless found", func() {
+		Expect(k8sClient.Create(context.Background(), p)).To(Succeed())
+
+		Eventually(projectID, timeout, interval).ShouldNot(BeEmpty())
+		Expect(p.Spec.ProjectID).ToNot(Equal(testProject))
+		Expect(metalAPI.Projects()).To(ContainElement(p.Spec.ProjectID))
+		Eventually(func() (clusterv1.Phase, error) {
+			err := k8sClient.Get(context.Background(), keyOf(p), p)
+			return p.Status.Phase, err
+		}, timeout, interval).Should(Equal(clusterv1.PhaseReady))
+	})
+})
@@ -4,10 +4,19 @@ go 1.15
 
 require (
 	github.com/go-logr/logr v0.1.0
+	github.com/go-openapi/runtime v0.19.23
+	github.com/go-openapi/strfmt v0.19.8
+	github.com/metal-stack/masterdata-api v0.8.3
 	github.com/metal-stack/metal-go v0.11.2
+	github.com/metal-stack/metal-lib v0.6.4
+	github.com/metal-stack/security v0.4.0
 	github.com/onsi/ginkgo v1.14.0
 	github.com/onsi/gomega v1.10.1
+	github.com/prometheus/client_golang v1.7.1
+	go.uber.org/zap v1.16.0
+	k8s.io/api v0.17.2
 	k8s.io/apimachinery v0.17.2
 	k8s.io/client-go v0.17.2
 	sigs.k8s.io/controller-runtime v0.5.0
+	sigs.k8s.io/yaml v1.1.0
 )
@@ -17,17 +17,32 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	uberzap "go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
+	configv1alpha1 "github.com/LimKianAn/xcluster/api/config/v1alpha1"
 	clusterv1 "github.com/LimKianAn/xcluster/api/v1"
 	"github.com/LimKianAn/xcluster/controllers"
+	"github.com/LimKianAn/xcluster/metal"
+	"github.com/LimKianAn/xcluster/tracing"
 	metalgo "github.com/metal-stack/metal-go"
 	// +kubebuilder:scaffold:imports
 )
@@ -45,59 +60,361 @@ func init() {
 }
 
 func main() {
-	var metricsAddr string
-	var enableLeaderElection bool
-	flag.StringVar(&metricsAddr, "metrics-addr", ":8000", "The address the metric endpoint binds to.")
-	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
+	cfg := configv1alpha1.Default()
+	var configFile string
+	flag.StringVar(&configFile, "config", "",
+		"The configuration file of kind "+configv1alpha1.Kind+", see config/manager/controller_manager_config.yaml. "+
+			"Flags given on the command line override it.")
+	flag.StringVar(&cfg.Metrics.BindAddress, "metrics-addr", cfg.Metrics.BindAddress, "The address the metric endpoint binds to.")
+	flag.StringVar(&cfg.Health.BindAddress, "health-addr", cfg.Health.BindAddress, "The address the health probes bind to.")
+	flag.BoolVar(&cfg.LeaderElection.LeaderElect, "enable-leader-election", cfg.LeaderElection.LeaderElect,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.Var(&commaSeparated{&cfg.Namespaces}, "namespaces",
+		"The comma-separated namespaces to watch. All are watched if empty.")
+	flag.StringVar(&cfg.Logging.Format, "log-format", cfg.Logging.Format, "The format of the logs, console or json.")
+	flag.StringVar(&cfg.Logging.Level, "log-level", cfg.Logging.Level, "The level of the logs, debug, info or error.")
+	flag.StringVar(&cfg.Tracing.Endpoint, "tracing-endpoint", cfg.Tracing.Endpoint,
+		"The OTLP/HTTP endpoint of the OpenTelemetry collector, e.g. http://otel-collector:4318. "+
+			"Tracing is disabled if empty.")
+	flag.StringVar(&cfg.Tracing.ServiceName, "tracing-service-name", cfg.Tracing.ServiceName,
+		"The service name reported with every span.")
+	flag.StringVar(&cfg.MetalAPI.URL, "metal-api-url", cfg.MetalAPI.URL,
+		"The endpoint of metal-api. Defaults to $METALCTL_URL.")
+	flag.StringVar(&cfg.MetalAPI.HMACFile, "metal-api-hmac-file", cfg.MetalAPI.HMACFile,
+		"The file holding the HMAC key of metal-api. The key is taken from $METALCTL_HMAC if empty.")
+	flag.StringVar(&cfg.MetalAPI.MinVersion, "metal-api-min-version", cfg.MetalAPI.MinVersion,
+		"The oldest version of metal-api the manager starts with.")
+	flag.DurationVar(&cfg.MetalAPI.Timeout.Duration, "metal-api-timeout", cfg.MetalAPI.Timeout.Duration,
+		"The timeout of a single call to metal-api.")
+	flag.IntVar(&cfg.MetalAPI.MaxRetries, "metal-api-max-retries", cfg.MetalAPI.MaxRetries,
+		"How often idempotent calls to metal-api are retried on transient errors.")
+	flag.DurationVar(&cfg.MetalAPI.Backoff.Duration, "metal-api-backoff", cfg.MetalAPI.Backoff.Duration,
+		"The initial back-off between retries of metal-api calls.")
+	flag.IntVar(&cfg.MetalAPI.FailureThreshold, "metal-api-failure-threshold", cfg.MetalAPI.FailureThreshold,
+		"The number of consecutive transient metal-api failures which opens the circuit breaker.")
+	flag.DurationVar(&cfg.MetalAPI.Cooldown.Duration, "metal-api-cooldown", cfg.MetalAPI.Cooldown.Duration,
+		"How long the circuit breaker stays open before metal-api is tried again.")
+	flag.BoolVar(&cfg.MetalAPI.DryRun, "dry-run", cfg.MetalAPI.DryRun,
+		"Reconcile without mutating metal-stack. The metal-api calls which would be made are logged, "+
+			"emitted as events and recorded in the status of the resources instead.")
+	flag.StringVar(&cfg.MetalAPI.Cassette, "metal-api-cassette", cfg.MetalAPI.Cassette,
+		"Record every metal-api call with its outcome in the cassette at this path, "+
+			"e.g. to replay them in tests. Recording is disabled if empty.")
+	flag.DurationVar(&cfg.ResyncPeriod.Duration, "resync-period", cfg.ResyncPeriod.Duration,
+		"How often ready xclusters and xfirewalls are checked for drift of their metal-stack resources. "+
+			"Zero disables the periodic check.")
+	for kind, n := range cfg.MaxConcurrentReconciles.ByKind() {
+		flag.IntVar(n, kind+"-max-concurrent-reconciles", *n,
+			"How many "+kind+"s are reconciled at once. Allocations in the same metal-stack project and partition "+
+				"are made one after another regardless.")
+	}
 	flag.Parse()
 
-	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
+	if err := loadConfig(configFile, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		exit(1)
+	}
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:             scheme,
-		MetricsBindAddress: metricsAddr,
-		Port:               9443,
-		LeaderElection:     enableLeaderElection,
-		LeaderElectionID:   "8af6cf17.www.x-cellent.com",
-	})
+	ctrl.SetLogger(newLogger(cfg.Logging))
+	if configFile != "" {
+		setupLog.Info("configuration loaded", "file", configFile)
+	}
+
+	if cfg.Tracing.Endpoint != "" {
+		tp := tracing.NewProvider(
+			tracing.NewOTLPExporter(cfg.Tracing.Endpoint, cfg.Tracing.ServiceName),
+			ctrl.Log.WithName("tracing"),
+		)
+		tracing.SetProvider(tp)
+		shutdown = func() {
+			if err := tp.Shutdown(context.Background()); err != nil {
+				setupLog.Error(err, "unable to flush spans")
+			}
+		}
+		setupLog.Info("tracing enabled", "endpoint", cfg.Tracing.Endpoint)
+	}
+
+	opts := ctrl.Options{
+		Scheme:                  scheme,
+		MetricsBindAddress:      cfg.Metrics.BindAddress,
+		HealthProbeBindAddress:  cfg.Health.BindAddress,
+		Port:                    cfg.Webhook.Port,
+		LeaderElection:          cfg.LeaderElection.LeaderElect,
+		LeaderElectionID:        cfg.LeaderElection.ResourceName,
+		LeaderElectionNamespace: cfg.LeaderElection.ResourceNamespace,
+	}
+	switch len(cfg.Namespaces) {
+	case 0:
+	case 1:
+		opts.Namespace = cfg.Namespaces[0]
+	default:
+		opts.NewCache = cache.MultiNamespacedCacheBuilder(cfg.Namespaces)
+	}
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), opts)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
-		os.Exit(1)
+		exit(1)
 	}
 
 	// Create the client to interact with `metal-stack/metal-api`
-	metalClient, err := metalgo.NewDriver(os.Getenv("METALCTL_URL"), "", os.Getenv("METALCTL_HMAC"))
+	hmac, err := metalHMAC(cfg.MetalAPI)
+	if err != nil {
+		setupLog.Error(err, "unable to read the HMAC key of metal-api")
+		exit(1)
+	}
+	driver, err := metalgo.NewDriver(cfg.MetalAPI.URL, "", hmac)
 	if err != nil {
 		setupLog.Error(err, "unable to create the client")
-		os.Exit(1)
+		exit(1)
+	}
+	metalHealth, err := metal.NewHealth(driver, cfg.MetalAPI.URL, cfg.MetalAPI.Timeout.Duration)
+	if err != nil {
+		setupLog.Error(err, "unable to create the health check of metal-api")
+		exit(1)
+	}
+	if metalVersion, err := metalHealth.Version(context.Background()); err != nil {
+		// An unreachable metal-api is reported by the readiness check rather
+		// than restarting the manager, and its version checked once reached.
+		setupLog.Error(err, "unable to get the version of metal-api, checking it once reachable")
+		if err := mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+			return awaitMetalVersion(stop, metalHealth, cfg.MetalAPI.MinVersion)
+		})); err != nil {
+			setupLog.Error(err, "unable to add the version check of metal-api")
+			exit(1)
+		}
+	} else if err := checkMetalVersion(metalVersion, cfg.MetalAPI.MinVersion); err != nil {
+		exit(1)
 	}
-	setupLog.Info("metal-stack client connected")
+
+	metalClient := metal.NewClient(driver)
+	if cfg.MetalAPI.Cassette != "" {
+		metalClient = metal.WithRecording(metalClient, cfg.MetalAPI.Cassette, ctrl.Log.WithName("metal"))
+		setupLog.Info("recording metal-api calls", "cassette", cfg.MetalAPI.Cassette)
+	}
+	metalClient = metal.WithResilience(metalClient, metal.ResilienceOptions{
+		Timeout:          cfg.MetalAPI.Timeout.Duration,
+		MaxRetries:       cfg.MetalAPI.MaxRetries,
+		Backoff:          cfg.MetalAPI.Backoff.Duration,
+		FailureThreshold: cfg.MetalAPI.FailureThreshold,
+		Cooldown:         cfg.MetalAPI.Cooldown.Duration,
+	})
+	metalClient = metal.WithSerialization(metalClient)
+	if cfg.MetalAPI.DryRun {
+		metalClient = metal.NewDryRunClient(metalClient, ctrl.Log.WithName("metal"))
+		setupLog.Info("dry-run enabled, metal-stack will not be changed")
+	}
+	metalClient = metal.WithTracing(metalClient)
 
 	if err = (&controllers.XClusterReconciler{
-		Client: mgr.GetClient(),
-		Driver: metalClient,
-		Log:    ctrl.Log.WithName("controllers").WithName("XCluster"),
-		Scheme: mgr.GetScheme(),
+		Client:                  tracing.NewClient(mgr.GetClient()),
+		Driver:                  metalClient,
+		Log:                     ctrl.Log.WithName("controllers").WithName("XCluster"),
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                mgr.GetEventRecorderFor("xcluster-controller"),
+		MaxConcurrentReconciles: cfg.MaxConcurrentReconciles.XCluster,
+		ResyncPeriod:            cfg.ResyncPeriod.Duration,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "XCluster")
-		os.Exit(1)
+		exit(1)
 	}
 	if err = (&controllers.XFirewallReconciler{
-		Client: mgr.GetClient(),
-		Driver: metalClient,
-		Log:    ctrl.Log.WithName("controllers").WithName("XFirewall"),
-		Scheme: mgr.GetScheme(),
+		Client:                  tracing.NewClient(mgr.GetClient()),
+		Driver:                  metalClient,
+		Log:                     ctrl.Log.WithName("controllers").WithName("XFirewall"),
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                mgr.GetEventRecorderFor("xfirewall-controller"),
+		MaxConcurrentReconciles: cfg.MaxConcurrentReconciles.XFirewall,
+		ResyncPeriod:            cfg.ResyncPeriod.Duration,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "XFirewall")
-		os.Exit(1)
+		exit(1)
+	}
+	if err = (&controllers.XMachineReconciler{
+		Client:                  tracing.NewClient(mgr.GetClient()),
+		Driver:                  metalClient,
+		Log:                     ctrl.Log.WithName("controllers").WithName("XMachine"),
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                mgr.GetEventRecorderFor("xmachine-controller"),
+		MaxConcurrentReconciles: cfg.MaxConcurrentReconciles.XMachine,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "XMachine")
+		exit(1)
+	}
+	if err = (&controllers.XIPClaimReconciler{
+		Client:                  tracing.NewClient(mgr.GetClient()),
+		Driver:                  metalClient,
+		Log:                     ctrl.Log.WithName("controllers").WithName("XIPClaim"),
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                mgr.GetEventRecorderFor("xipclaim-controller"),
+		MaxConcurrentReconciles: cfg.MaxConcurrentReconciles.XIPClaim,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "XIPClaim")
+		exit(1)
+	}
+	if err = (&controllers.XNetworkPeeringReconciler{
+		Client:                  tracing.NewClient(mgr.GetClient()),
+		Driver:                  metalClient,
+		Log:                     ctrl.Log.WithName("controllers").WithName("XNetworkPeering"),
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                mgr.GetEventRecorderFor("xnetworkpeering-controller"),
+		MaxConcurrentReconciles: cfg.MaxConcurrentReconciles.XNetworkPeering,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "XNetworkPeering")
+		exit(1)
+	}
+	if err = (&controllers.XNetworkReconciler{
+		Client:                  tracing.NewClient(mgr.GetClient()),
+		Driver:                  metalClient,
+		Log:                     ctrl.Log.WithName("controllers").WithName("XNetwork"),
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                mgr.GetEventRecorderFor("xnetwork-controller"),
+		MaxConcurrentReconciles: cfg.MaxConcurrentReconciles.XNetwork,
+		ResyncPeriod:            cfg.ResyncPeriod.Duration,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "XNetwork")
+		exit(1)
+	}
+	if err = (&controllers.XProjectReconciler{
+		Client:                  tracing.NewClient(mgr.GetClient()),
+		Driver:                  metalClient,
+		Log:                     ctrl.Log.WithName("controllers").WithName("XProject"),
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                mgr.GetEventRecorderFor("xproject-controller"),
+		MaxConcurrentReconciles: cfg.MaxConcurrentReconciles.XProject,
+		ResyncPeriod:            cfg.ResyncPeriod.Duration,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "XProject")
+		exit(1)
 	}
 	// +kubebuilder:scaffold:builder
 
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to add the health check")
+		exit(1)
+	}
+	if err := mgr.AddReadyzCheck("metal-api", metalHealth.Check); err != nil {
+		setupLog.Error(err, "unable to add the readiness check")
+		exit(1)
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
-		os.Exit(1)
+		exit(1)
+	}
+	shutdown()
+}
+
+// maxVersionBackoff is the longest wait between two attempts to get the
+// version of metal-api.
+const maxVersionBackoff = time.Minute
+
+// awaitMetalVersion gets the version of metal-api, backing off between the
+// attempts, until it succeeds or stop is closed. It fails, which stops the
+// manager, if the version is older than min.
+func awaitMetalVersion(stop <-chan struct{}, h *metal.Health, min string) error {
+	backoff := time.Second
+	for {
+		v, err := h.Version(context.Background())
+		if err == nil {
+			return checkMetalVersion(v, min)
+		}
+		setupLog.Error(err, "unable to get the version of metal-api", "retryAfter", backoff)
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxVersionBackoff {
+			backoff = maxVersionBackoff
+		}
+	}
+}
+
+// checkMetalVersion logs the version v of metal-api and fails if it's older
+// than min.
+func checkMetalVersion(v, min string) error {
+	if err := metal.CheckVersion(v, min); err != nil {
+		setupLog.Error(err, "incompatible metal-api", "version", v)
+		return err
+	}
+	setupLog.Info("metal-api connected", "version", v)
+	return nil
+}
+
+// shutdown flushes the spans not exported yet once tracing is enabled.
+var shutdown = func() {}
+
+// exit exits with code after flushing the spans, which a deferred call
+// wouldn't do.
+func exit(code int) {
+	shutdown()
+	os.Exit(code)
+}
+
+// loadConfig reads the configuration file at path, if any, into cfg and then
+// applies the flags given on the command line once more, so that they
+// override it. The endpoint of metal-api is taken from $METALCTL_URL unless
+// configured. The result is validated.
+func loadConfig(path string, cfg *configv1alpha1.ControllerManagerConfiguration) error {
+	if path != "" {
+		given := map[string]string{}
+		flag.Visit(func(f *flag.Flag) { given[f.Name] = f.Value.String() })
+		if err := configv1alpha1.Load(path, cfg); err != nil {
+			return err
+		}
+		for name, value := range given {
+			if err := flag.Set(name, value); err != nil {
+				return fmt.Errorf("failed to apply flag --%s: %w", name, err)
+			}
+		}
+	}
+	if cfg.MetalAPI.URL == "" {
+		cfg.MetalAPI.URL = os.Getenv("METALCTL_URL")
+	}
+	return cfg.Validate()
+}
+
+// metalHMAC returns the HMAC key of metal-api from the configured file, or
+// from $METALCTL_HMAC if there is none.
+func metalHMAC(cfg configv1alpha1.MetalAPIConfiguration) (string, error) {
+	if cfg.HMACFile == "" {
+		return os.Getenv("METALCTL_HMAC"), nil
+	}
+	key, err := ioutil.ReadFile(cfg.HMACFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(key)), nil
+}
+
+// newLogger returns the logger configured by cfg, which has been validated.
+func newLogger(cfg configv1alpha1.LoggingConfiguration) logr.Logger {
+	var level zapcore.Level
+	_ = level.UnmarshalText([]byte(cfg.Level))
+	atomic := uberzap.NewAtomicLevelAt(level)
+	return zap.New(zap.UseDevMode(cfg.Format == "console"), zap.Level(&atomic))
+}
+
+// commaSeparated is a flag of comma-separated values.
+type commaSeparated struct {
+	values *[]string
+}
+
+func (c *commaSeparated) String() string {
+	if c.values == nil {
+		return ""
+	}
+	return strings.Join(*c.values, ",")
+}
+
+func (c *commaSeparated) Set(s string) error {
+	*c.values = nil
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*c.values = append(*c.values, v)
+		}
 	}
+	return nil
 }
@@ -0,0 +1,129 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metal contains the client used to talk to metal-api.
+package metal
+
+import (
+	"context"
+
+	mdv1 "github.com/metal-stack/masterdata-api/api/rest/v1"
+	metalgo "github.com/metal-stack/metal-go"
+)
+
+// Client is the part of metal-api xcluster depends on. Unlike
+// metalgo.Driver, every call takes a context, so that wrappers can trace,
+// time out or record the calls.
+type Client interface {
+	NetworkAllocate(ctx context.Context, req *metalgo.NetworkAllocateRequest) (*metalgo.NetworkDetailResponse, error)
+	NetworkFind(ctx context.Context, req *metalgo.NetworkFindRequest) (*metalgo.NetworkListResponse, error)
+	NetworkFree(ctx context.Context, id string) (*metalgo.NetworkDetailResponse, error)
+	NetworkGet(ctx context.Context, id string) (*metalgo.NetworkGetResponse, error)
+	IPAllocate(ctx context.Context, req *metalgo.IPAllocateRequest) (*metalgo.IPDetailResponse, error)
+	IPFind(ctx context.Context, req *metalgo.IPFindRequest) (*metalgo.IPListResponse, error)
+	IPFree(ctx context.Context, id string) (*metalgo.IPDetailResponse, error)
+	IPGet(ctx context.Context, ip string) (*metalgo.IPDetailResponse, error)
+	FirewallCreate(ctx context.Context, req *metalgo.FirewallCreateRequest) (*metalgo.FirewallCreateResponse, error)
+	MachineCreate(ctx context.Context, req *metalgo.MachineCreateRequest) (*metalgo.MachineCreateResponse, error)
+	MachineDelete(ctx context.Context, id string) (*metalgo.MachineDeleteResponse, error)
+	MachineFind(ctx context.Context, req *metalgo.MachineFindRequest) (*metalgo.MachineListResponse, error)
+	MachineGet(ctx context.Context, id string) (*metalgo.MachineGetResponse, error)
+	PartitionGet(ctx context.Context, id string) (*metalgo.PartitionGetResponse, error)
+	ProjectCreate(ctx context.Context, req mdv1.ProjectCreateRequest) (*metalgo.ProjectGetResponse, error)
+	ProjectFind(ctx context.Context, req mdv1.ProjectFindRequest) (*metalgo.ProjectListResponse, error)
+	ProjectGet(ctx context.Context, id string) (*metalgo.ProjectGetResponse, error)
+}
+
+// NewClient adapts driver to Client.
+func NewClient(driver *metalgo.Driver) Client {
+	return &driverClient{driver: driver}
+}
+
+type driverClient struct {
+	driver *metalgo.Driver
+}
+
+func (c *driverClient) NetworkAllocate(_ context.Context, req *metalgo.NetworkAllocateRequest) (*metalgo.NetworkDetailResponse, error) {
+	return c.driver.NetworkAllocate(req)
+}
+
+func (c *driverClient) NetworkFind(_ context.Context, req *metalgo.NetworkFindRequest) (*metalgo.NetworkListResponse, error) {
+	return c.driver.NetworkFind(req)
+}
+
+func (c *driverClient) NetworkFree(_ context.Context, id string) (*metalgo.NetworkDetailResponse, error) {
+	return c.driver.NetworkFree(id)
+}
+
+func (c *driverClient) NetworkGet(_ context.Context, id string) (*metalgo.NetworkGetResponse, error) {
+	return c.driver.NetworkGet(id)
+}
+
+func (c *driverClient) IPAllocate(_ context.Context, req *metalgo.IPAllocateRequest) (*metalgo.IPDetailResponse, error) {
+	return c.driver.IPAllocate(req)
+}
+
+func (c *driverClient) IPFind(_ context.Context, req *metalgo.IPFindRequest) (*metalgo.IPListResponse, error) {
+	return c.driver.IPFind(req)
+}
+
+func (c *driverClient) IPFree(_ context.Context, id string) (*metalgo.IPDetailResponse, error) {
+	return c.driver.IPFree(id)
+}
+
+func (c *driverClient) IPGet(_ context.Context, ip string) (*metalgo.IPDetailResponse, error) {
+	return c.driver.IPGet(ip)
+}
+
+func (c *driverClient) FirewallCreate(_ context.Context, req *metalgo.FirewallCreateRequest) (*metalgo.FirewallCreateResponse, error) {
+	return c.driver.FirewallCreate(req)
+}
+
+func (c *driverClient) MachineCreate(_ context.Context, req *metalgo.MachineCreateRequest) (*metalgo.MachineCreateResponse, error) {
+	return c.driver.MachineCreate(req)
+}
+
+func (c *driverClient) MachineDelete(_ context.Context, id string) (*metalgo.MachineDeleteResponse, error) {
+	return c.driver.MachineDelete(id)
+}
+
+func (c *driverClient) MachineFind(_ context.Context, req *metalgo.MachineFindRequest) (*metalgo.MachineListResponse, error) {
+	return c.driver.MachineFind(req)
+}
+
+func (c *driverClient) MachineGet(_ context.Context, id string) (*metalgo.MachineGetResponse, error) {
+	return c.driver.MachineGet(id)
+}
+
+func (c *driverClient) PartitionGet(_ context.Context, id string) (*metalgo.PartitionGetResponse, error) {
+	return c.driver.PartitionGet(id)
+}
+
+func (c *driverClient) ProjectCreate(_ context.Context, req mdv1.ProjectCreateRequest) (*metalgo.ProjectGetResponse, error) {
+	// metalgo.Driver dereferences the meta of the project unchecked.
+	if req.Meta == nil {
+		req.Meta = &mdv1.Meta{}
+	}
+	return c.driver.ProjectCreate(req)
+}
+
+func (c *driverClient) ProjectFind(_ context.Context, req mdv1.ProjectFindRequest) (*metalgo.ProjectListResponse, error) {
+	return c.driver.ProjectFind(req)
+}
+
+func (c *driverClient) ProjectGet(_ context.Context, id string) (*metalgo.ProjectGetResponse, error) {
+	return c.driver.ProjectGet(id)
+}
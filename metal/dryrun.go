@@ -0,0 +1,317 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metal
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	mdv1 "github.com/metal-stack/masterdata-api/api/rest/v1"
+	metalgo "github.com/metal-stack/metal-go"
+	"github.com/metal-stack/metal-go/api/models"
+)
+
+// Call is a metal-api call which would have mutated metal-stack.
+type Call struct {
+	Operation string
+	Args      string
+}
+
+func (c Call) String() string {
+	return c.Operation + "(" + c.Args + ")"
+}
+
+type callObserverKey struct{}
+
+// WithCallObserver returns a copy of ctx which makes the dry-run client hand
+// every call it skips to observe.
+func WithCallObserver(ctx context.Context, observe func(Call)) context.Context {
+	return context.WithValue(ctx, callObserverKey{}, observe)
+}
+
+// NewDryRunClient returns a Client which passes reads through to next, but
+// only logs the calls which would mutate metal-stack and answers them with
+// made-up IDs prefixed with "dry-run-". The made-up IDs are derived from the
+// call, so that repeating a call yields the same ID, and reads of them are
+// answered from memory without asking metal-api, which doesn't know them.
+// They are lost once the manager stops, so they must not be persisted.
+func NewDryRunClient(next Client, log logr.Logger) Client {
+	return &dryRunClient{
+		next:     next,
+		log:      log,
+		networks: map[string]*models.V1NetworkResponse{},
+		machines: map[string]*models.V1MachineResponse{},
+		ips:      map[string]*models.V1IPResponse{},
+		projects: map[string]*models.V1ProjectResponse{},
+	}
+}
+
+type dryRunClient struct {
+	next Client
+	log  logr.Logger
+
+	// mu guards the made-up resources below.
+	mu       sync.Mutex
+	networks map[string]*models.V1NetworkResponse
+	machines map[string]*models.V1MachineResponse
+	// ips are keyed by address, which doesn't carry the "dry-run-" prefix.
+	ips      map[string]*models.V1IPResponse
+	projects map[string]*models.V1ProjectResponse
+}
+
+func (c *dryRunClient) NetworkAllocate(ctx context.Context, req *metalgo.NetworkAllocateRequest) (*metalgo.NetworkDetailResponse, error) {
+	call := c.skip(ctx, "NetworkAllocate", "name=%s partition=%s project=%s shared=%t", req.Name, req.PartitionID, req.ProjectID, req.Shared)
+	n := &models.V1NetworkResponse{
+		ID:          fakeID(call),
+		Name:        req.Name,
+		Description: req.Description,
+		Partitionid: req.PartitionID,
+		Projectid:   req.ProjectID,
+		Shared:      req.Shared,
+		Labels:      req.Labels,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.networks[*n.ID] = n
+	return &metalgo.NetworkDetailResponse{Network: n}, nil
+}
+
+func (c *dryRunClient) NetworkFind(ctx context.Context, req *metalgo.NetworkFindRequest) (*metalgo.NetworkListResponse, error) {
+	if req.ID != nil && IsDryRunID(*req.ID) {
+		resp := &metalgo.NetworkListResponse{}
+		if n := c.network(*req.ID); n != nil {
+			resp.Networks = append(resp.Networks, n)
+		}
+		return resp, nil
+	}
+	return c.next.NetworkFind(ctx, req)
+}
+
+func (c *dryRunClient) NetworkFree(ctx context.Context, id string) (*metalgo.NetworkDetailResponse, error) {
+	c.skip(ctx, "NetworkFree", "id=%s", id)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.networks, id)
+	return &metalgo.NetworkDetailResponse{Network: &models.V1NetworkResponse{ID: &id}}, nil
+}
+
+func (c *dryRunClient) NetworkGet(ctx context.Context, id string) (*metalgo.NetworkGetResponse, error) {
+	if IsDryRunID(id) {
+		n := c.network(id)
+		if n == nil {
+			return nil, &Error{Op: "NetworkGet", Class: NotFound, Err: fmt.Errorf("dry-run network %s not found", id)}
+		}
+		return &metalgo.NetworkGetResponse{Network: n}, nil
+	}
+	return c.next.NetworkGet(ctx, id)
+}
+
+func (c *dryRunClient) IPAllocate(ctx context.Context, req *metalgo.IPAllocateRequest) (*metalgo.IPDetailResponse, error) {
+	call := c.skip(ctx, "IPAllocate", "name=%s network=%s project=%s type=%s ip=%s tags=%s",
+		req.Name, req.Networkid, req.Projectid, req.Type, req.IPAddress, strings.Join(req.Tags, ","))
+
+	address := req.IPAddress
+	if address == "" {
+		// An address of TEST-NET-3, which is never routed.
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(call.String()))
+		address = fmt.Sprintf("203.0.113.%d", h.Sum32()%254+1)
+	}
+	ip := &models.V1IPResponse{
+		Ipaddress: &address,
+		Name:      req.Name,
+		Networkid: &req.Networkid,
+		Projectid: &req.Projectid,
+		Type:      &req.Type,
+		Tags:      req.Tags,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ips[address] = ip
+	return &metalgo.IPDetailResponse{IP: ip}, nil
+}
+
+func (c *dryRunClient) IPFind(ctx context.Context, req *metalgo.IPFindRequest) (*metalgo.IPListResponse, error) {
+	return c.next.IPFind(ctx, req)
+}
+
+func (c *dryRunClient) IPFree(ctx context.Context, id string) (*metalgo.IPDetailResponse, error) {
+	c.skip(ctx, "IPFree", "ip=%s", id)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.ips, id)
+	return &metalgo.IPDetailResponse{IP: &models.V1IPResponse{Ipaddress: &id}}, nil
+}
+
+func (c *dryRunClient) IPGet(ctx context.Context, ip string) (*metalgo.IPDetailResponse, error) {
+	c.mu.Lock()
+	fake, ok := c.ips[ip]
+	c.mu.Unlock()
+	if ok {
+		return &metalgo.IPDetailResponse{IP: fake}, nil
+	}
+	return c.next.IPGet(ctx, ip)
+}
+
+func (c *dryRunClient) FirewallCreate(ctx context.Context, req *metalgo.FirewallCreateRequest) (*metalgo.FirewallCreateResponse, error) {
+	call := c.skip(ctx, "FirewallCreate", "name=%s partition=%s project=%s size=%s image=%s networks=%s",
+		req.Name, req.Partition, req.Project, req.Size, req.Image, networkIDs(req.Networks))
+	m := c.fakeMachine(call, &req.MachineCreateRequest)
+	return &metalgo.FirewallCreateResponse{Firewall: &models.V1FirewallResponse{
+		ID:         m.ID,
+		Name:       m.Name,
+		Partition:  m.Partition,
+		Allocation: m.Allocation,
+	}}, nil
+}
+
+func (c *dryRunClient) MachineCreate(ctx context.Context, req *metalgo.MachineCreateRequest) (*metalgo.MachineCreateResponse, error) {
+	call := c.skip(ctx, "MachineCreate", "name=%s partition=%s project=%s size=%s image=%s networks=%s",
+		req.Name, req.Partition, req.Project, req.Size, req.Image, networkIDs(req.Networks))
+	return &metalgo.MachineCreateResponse{Machine: c.fakeMachine(call, req)}, nil
+}
+
+// fakeMachine makes up and remembers the machine which req would allocate.
+func (c *dryRunClient) fakeMachine(call Call, req *metalgo.MachineCreateRequest) *models.V1MachineResponse {
+	m := &models.V1MachineResponse{
+		ID:        fakeID(call),
+		Name:      req.Name,
+		Partition: &models.V1PartitionResponse{ID: &req.Partition},
+		Allocation: &models.V1MachineAllocation{
+			Hostname: &req.Hostname,
+			Name:     &req.Name,
+			Project:  &req.Project,
+		},
+		Tags: req.Tags,
+	}
+	for _, n := range req.Networks {
+		id := n.NetworkID
+		m.Allocation.Networks = append(m.Allocation.Networks, &models.V1MachineNetwork{Networkid: &id})
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.machines[*m.ID] = m
+	return m
+}
+
+func (c *dryRunClient) MachineDelete(ctx context.Context, id string) (*metalgo.MachineDeleteResponse, error) {
+	c.skip(ctx, "MachineDelete", "id=%s", id)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.machines, id)
+	return &metalgo.MachineDeleteResponse{Machine: &models.V1MachineResponse{ID: &id}}, nil
+}
+
+func (c *dryRunClient) MachineFind(ctx context.Context, req *metalgo.MachineFindRequest) (*metalgo.MachineListResponse, error) {
+	return c.next.MachineFind(ctx, req)
+}
+
+func (c *dryRunClient) MachineGet(ctx context.Context, id string) (*metalgo.MachineGetResponse, error) {
+	if IsDryRunID(id) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		m, ok := c.machines[id]
+		if !ok {
+			return nil, &Error{Op: "MachineGet", Class: NotFound, Err: fmt.Errorf("dry-run machine %s not found", id)}
+		}
+		return &metalgo.MachineGetResponse{Machine: m}, nil
+	}
+	return c.next.MachineGet(ctx, id)
+}
+
+func (c *dryRunClient) PartitionGet(ctx context.Context, id string) (*metalgo.PartitionGetResponse, error) {
+	return c.next.PartitionGet(ctx, id)
+}
+
+func (c *dryRunClient) ProjectCreate(ctx context.Context, req mdv1.ProjectCreateRequest) (*metalgo.ProjectGetResponse, error) {
+	call := c.skip(ctx, "ProjectCreate", "name=%s tenant=%s", req.Name, req.TenantId)
+	p := &models.V1ProjectResponse{
+		Meta:        &models.V1Meta{ID: *fakeID(call)},
+		Name:        req.Name,
+		Description: req.Description,
+		TenantID:    req.TenantId,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.projects[p.Meta.ID] = p
+	return &metalgo.ProjectGetResponse{Project: p}, nil
+}
+
+func (c *dryRunClient) ProjectFind(ctx context.Context, req mdv1.ProjectFindRequest) (*metalgo.ProjectListResponse, error) {
+	return c.next.ProjectFind(ctx, req)
+}
+
+func (c *dryRunClient) ProjectGet(ctx context.Context, id string) (*metalgo.ProjectGetResponse, error) {
+	if IsDryRunID(id) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		p, ok := c.projects[id]
+		if !ok {
+			return nil, &Error{Op: "ProjectGet", Class: NotFound, Err: fmt.Errorf("dry-run project %s not found", id)}
+		}
+		return &metalgo.ProjectGetResponse{Project: p}, nil
+	}
+	return c.next.ProjectGet(ctx, id)
+}
+
+func (c *dryRunClient) skip(ctx context.Context, op, format string, args ...interface{}) Call {
+	call := Call{Operation: op, Args: fmt.Sprintf(format, args...)}
+	c.log.Info("dry-run: skipping metal-api call", "operation", call.Operation, "args", call.Args)
+	if observe, ok := ctx.Value(callObserverKey{}).(func(Call)); ok {
+		observe(call)
+	}
+	return call
+}
+
+func (c *dryRunClient) network(id string) *models.V1NetworkResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.networks[id]
+}
+
+func networkIDs(networks []metalgo.MachineAllocationNetwork) string {
+	ids := make([]string, 0, len(networks))
+	for _, n := range networks {
+		ids = append(ids, n.NetworkID)
+	}
+	return strings.Join(ids, ",")
+}
+
+const fakeIDPrefix = "dry-run-"
+
+func fakeID(call Call) *string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(call.String()))
+	id := fmt.Sprintf("%s%016x", fakeIDPrefix, h.Sum64())
+	return &id
+}
+
+// IsDryRunID reports whether id was made up by the dry-run client.
+func IsDryRunID(id string) bool {
+	return strings.HasPrefix(id, fakeIDPrefix)
+}
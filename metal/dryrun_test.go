@@ -0,0 +1,79 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metal
+
+import (
+	"context"
+	"testing"
+
+	metalgo "github.com/metal-stack/metal-go"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestDryRunSkipsMutations(t *testing.T) {
+	// next panics on the calls it does not count, since it embeds a nil Client.
+	next := &flakyClient{}
+	c := NewDryRunClient(next, zap.New())
+
+	var observed []Call
+	ctx := WithCallObserver(context.Background(), func(call Call) { observed = append(observed, call) })
+
+	req := &metalgo.NetworkAllocateRequest{Name: "vagrant", PartitionID: "vagrant", ProjectID: "p"}
+	first, err := c.NetworkAllocate(ctx, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, _ := c.NetworkAllocate(ctx, req)
+	if *first.Network.ID != *second.Network.ID {
+		t.Errorf("made-up IDs differ for the same call: %s, %s", *first.Network.ID, *second.Network.ID)
+	}
+
+	id := *first.Network.ID
+	if !IsDryRunID(id) {
+		t.Errorf("got %s, want a made-up ID", id)
+	}
+	resp, err := c.NetworkFind(ctx, &metalgo.NetworkFindRequest{ID: &id})
+	if err != nil || len(resp.Networks) != 1 {
+		t.Fatalf("made-up network not found: %v", err)
+	}
+	if _, err := c.NetworkFree(ctx, id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.FirewallCreate(ctx, &metalgo.FirewallCreateRequest{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.MachineDelete(ctx, "m"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"NetworkAllocate", "NetworkAllocate", "NetworkFree", "FirewallCreate", "MachineDelete"}
+	if len(observed) != len(want) {
+		t.Fatalf("got %d observed calls, want %d", len(observed), len(want))
+	}
+	for i, op := range want {
+		if observed[i].Operation != op {
+			t.Errorf("call %d: got %s, want %s", i, observed[i].Operation, op)
+		}
+	}
+	if next.calls != 0 {
+		t.Errorf("got %d calls to metal-api, want none", next.calls)
+	}
+
+	if _, err := c.NetworkFind(ctx, &metalgo.NetworkFindRequest{}); err != nil || next.calls != 1 {
+		t.Errorf("reads are not passed through: %v", err)
+	}
+}
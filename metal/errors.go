@@ -0,0 +1,151 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/metal-stack/metal-lib/httperrors"
+)
+
+// ErrorClass tells the reconcilers how to react to a failed metal-api call.
+type ErrorClass string
+
+const (
+	// Transient errors are expected to go away by retrying, e.g. timeouts or 503.
+	Transient ErrorClass = "transient"
+	// Conflict errors mean the request clashes with the state in metal-api.
+	Conflict ErrorClass = "conflict"
+	// NotFound errors mean the addressed resource does not exist.
+	NotFound ErrorClass = "not-found"
+	// Permanent errors won't go away without changing the request.
+	Permanent ErrorClass = "permanent"
+)
+
+// ErrCircuitOpen is returned without calling metal-api while the circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Error is a classified error of a metal-api call.
+type Error struct {
+	// Op is the name of the Client method which failed.
+	Op    string
+	Class ErrorClass
+	Err   error
+	// RetryAfter is set when the call was rejected by the open circuit
+	// breaker and tells how long it stays open.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("metal-api %s (%s): %v", e.Op, e.Class, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ClassOf returns the class of err. Errors not wrapped by this package are
+// classified by their HTTP status code.
+func ClassOf(err error) ErrorClass {
+	var merr *Error
+	if errors.As(err, &merr) {
+		return merr.Class
+	}
+	return classify(err)
+}
+
+// IsNotFound reports whether err means the metal-stack resource doesn't exist.
+func IsNotFound(err error) bool {
+	return err != nil && ClassOf(err) == NotFound
+}
+
+// IsConflict reports whether err means the request clashes with metal-api.
+func IsConflict(err error) bool {
+	return err != nil && ClassOf(err) == Conflict
+}
+
+// IsTransient reports whether retrying the call later is expected to succeed.
+func IsTransient(err error) bool {
+	return err != nil && ClassOf(err) == Transient
+}
+
+func classify(err error) ErrorClass {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrCircuitOpen) {
+		return Transient
+	}
+
+	code, ok := statusCode(err)
+	if !ok {
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			return Transient
+		}
+		return Permanent
+	}
+
+	switch {
+	case code == http.StatusNotFound:
+		return NotFound
+	case code == http.StatusConflict:
+		return Conflict
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests, code >= 500:
+		return Transient
+	default:
+		return Permanent
+	}
+}
+
+// responseCode matches the status code go-swagger puts into the messages of
+// the generated error responses, e.g. `[POST /v1/network/allocate][409] ...`.
+var responseCode = regexp.MustCompile(`\]\[(\d{3})\]`)
+
+func statusCode(err error) (int, bool) {
+	var apiErr *runtime.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code, true
+	}
+
+	// Default responses of the generated client
+	var coder interface{ Code() int }
+	if errors.As(err, &coder) {
+		return coder.Code(), true
+	}
+
+	var payloader interface {
+		GetPayload() *httperrors.HTTPErrorResponse
+	}
+	if errors.As(err, &payloader) {
+		if p := payloader.GetPayload(); p != nil && p.StatusCode != 0 {
+			return p.StatusCode, true
+		}
+	}
+
+	if m := responseCode.FindStringSubmatch(err.Error()); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		return code, true
+	}
+	return 0, false
+}
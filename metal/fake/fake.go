@@ -0,0 +1,788 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fake contains an in-memory metal-api for tests and demos.
+package fake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	mdv1 "github.com/metal-stack/masterdata-api/api/rest/v1"
+	metalgo "github.com/metal-stack/metal-go"
+	"github.com/metal-stack/metal-go/api/models"
+
+	"github.com/LimKianAn/xcluster/metal"
+)
+
+// DefaultPrefixLength is the length of the private networks allocated in
+// partitions which weren't added with another one.
+const DefaultPrefixLength = 22
+
+// Client is a metal.Client keeping networks, IPs, machines, partitions and
+// projects in memory. Machines report their allocation succeeded once
+// ProvisioningDelay has passed. The zero value is not usable, use New.
+type Client struct {
+	// ProvisioningDelay is how long machines and firewalls take to be
+	// installed.
+	ProvisioningDelay time.Duration
+	// Version is the version of metal-api reported by the server.
+	Version string
+
+	mu         sync.Mutex
+	seq        int
+	networks   map[string]*models.V1NetworkResponse
+	ips        map[string]*models.V1IPResponse
+	machines   map[string]*machine
+	partitions map[string]*models.V1PartitionResponse
+	projects   map[string]*models.V1ProjectResponse
+	calls      map[string]int
+	faults     []*fault
+}
+
+type machine struct {
+	*models.V1MachineResponse
+	created time.Time
+}
+
+var _ metal.Client = &Client{}
+
+// New returns an empty Client.
+func New() *Client {
+	return &Client{
+		networks:   map[string]*models.V1NetworkResponse{},
+		ips:        map[string]*models.V1IPResponse{},
+		machines:   map[string]*machine{},
+		partitions: map[string]*models.V1PartitionResponse{},
+		projects:   map[string]*models.V1ProjectResponse{},
+		calls:      map[string]int{},
+		Version:    metal.MinVersion,
+	}
+}
+
+// AddPartition adds the partition with the given ID, which allocates private
+// networks of the given prefix length.
+func (c *Client) AddPartition(id string, prefixLength int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.partitions[id] = &models.V1PartitionResponse{ID: &id, Name: id, Privatenetworkprefixlength: prefixLength}
+}
+
+// AddNetwork adds n, e.g. an external network which xcluster doesn't
+// allocate itself. A missing ID is made up. It returns the ID.
+func (c *Client) AddNetwork(n *models.V1NetworkResponse) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n.ID == nil {
+		n.ID = c.newID("network")
+	}
+	c.networks[*n.ID] = n
+	return *n.ID
+}
+
+// AddProject adds the project with the given ID, name and tenant.
+func (c *Client) AddProject(id, name, tenant string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.projects[id] = &models.V1ProjectResponse{Meta: &models.V1Meta{ID: id}, Name: name, TenantID: tenant}
+}
+
+// Fault scripts how calls of a Client method misbehave.
+type Fault struct {
+	// Op is the Client method, e.g. "NetworkAllocate".
+	Op string
+
+	// Nth, if positive, limits the fault to the Nth call of Op counted from
+	// the injection, after which the fault is removed. Otherwise every call
+	// is affected until the fault is cleared.
+	Nth int
+
+	// After, if positive, spares the first After calls of Op counted from
+	// the injection. It is meant for faults without Nth, which then affect
+	// every call after those.
+	After int
+
+	// Class, if set, makes the call fail with an error of that class
+	// without changing anything.
+	Class metal.ErrorClass
+
+	// Delay delays the call, at most until its context is done.
+	Delay time.Duration
+
+	// Drop makes the call change what it would but fail with a transient
+	// error as if the response got lost on the way.
+	Drop bool
+
+	// Duplicate makes the find calls return every result twice.
+	Duplicate bool
+}
+
+type fault struct {
+	Fault
+	calls int
+}
+
+// Inject adds f to the faults applied to the calls of f.Op. Several faults
+// of the same method are combined.
+func (c *Client) Inject(f Fault) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.faults = append(c.faults, &fault{Fault: f})
+}
+
+// ClearFaults removes the faults injected for the given Client methods, or
+// all of them if none are given.
+func (c *Client) ClearFaults(ops ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(ops) == 0 {
+		c.faults = nil
+		return
+	}
+	left := c.faults[:0]
+	for _, f := range c.faults {
+		if !containsString(ops, f.Op) {
+			left = append(left, f)
+		}
+	}
+	c.faults = left
+}
+
+// Fail makes every following call of the Client method op fail with an
+// error of the given class until it is called again with an empty class.
+func (c *Client) Fail(op string, class metal.ErrorClass) {
+	c.ClearFaults(op)
+	if class != "" {
+		c.Inject(Fault{Op: op, Class: class})
+	}
+}
+
+// Calls returns how often the Client method op has been called.
+func (c *Client) Calls(op string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[op]
+}
+
+// CallCounts returns how often each Client method has been called.
+func (c *Client) CallCounts() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]int, len(c.calls))
+	for op, n := range c.calls {
+		counts[op] = n
+	}
+	return counts
+}
+
+// Networks returns the IDs of the networks, sorted.
+func (c *Client) Networks() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return sortedKeys(c.networks)
+}
+
+// Machines returns the IDs of the machines and firewalls, sorted.
+func (c *Client) Machines() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return sortedKeys(c.machines)
+}
+
+// IPs returns the addresses of the IPs, sorted.
+func (c *Client) IPs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return sortedKeys(c.ips)
+}
+
+// Projects returns the IDs of the projects, sorted.
+func (c *Client) Projects() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return sortedKeys(c.projects)
+}
+
+// ResourcesOf returns the IDs of the networks and machines and the addresses
+// of the IPs of the given project, sorted. Nothing is left once everything
+// allocated for the project has been freed again.
+func (c *Client) ResourcesOf(project string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var resources []string
+	for id, n := range c.networks {
+		if n.Projectid == project {
+			resources = append(resources, id)
+		}
+	}
+	for address, ip := range c.ips {
+		if metalgo.StrDeref(ip.Projectid) == project {
+			resources = append(resources, address)
+		}
+	}
+	for id, m := range c.machines {
+		if metalgo.StrDeref(m.Allocation.Project) == project {
+			resources = append(resources, id)
+		}
+	}
+	sort.Strings(resources)
+	return resources
+}
+
+func (c *Client) NetworkAllocate(ctx context.Context, req *metalgo.NetworkAllocateRequest) (*metalgo.NetworkDetailResponse, error) {
+	f, err := c.begin(ctx, "NetworkAllocate")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	length := int32(DefaultPrefixLength)
+	if p, ok := c.partitions[req.PartitionID]; ok && p.Privatenetworkprefixlength != 0 {
+		length = p.Privatenetworkprefixlength
+	}
+	id := c.newID("network")
+	n := &models.V1NetworkResponse{
+		ID:          id,
+		Name:        req.Name,
+		Description: req.Description,
+		Partitionid: req.PartitionID,
+		Projectid:   req.ProjectID,
+		Shared:      req.Shared,
+		Labels:      req.Labels,
+		Nat:         boolPtr(false),
+		Prefixes:    []string{fmt.Sprintf("10.%d.%d.0/%d", (c.seq>>8)&255, c.seq&255, length)},
+	}
+	c.networks[*id] = n
+	return &metalgo.NetworkDetailResponse{Network: n}, f.lost()
+}
+
+func (c *Client) NetworkFind(ctx context.Context, req *metalgo.NetworkFindRequest) (*metalgo.NetworkListResponse, error) {
+	f, err := c.begin(ctx, "NetworkFind")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp := &metalgo.NetworkListResponse{}
+	for _, id := range sortedKeys(c.networks) {
+		n := c.networks[id]
+		if matches(req.ID, id) && matches(req.Name, n.Name) && matches(req.PartitionID, n.Partitionid) && matches(req.ProjectID, n.Projectid) &&
+			hasLabels(n.Labels, req.Labels) {
+			resp.Networks = append(resp.Networks, n)
+		}
+	}
+	if f.Duplicate {
+		resp.Networks = append(resp.Networks, resp.Networks...)
+	}
+	return resp, f.lost()
+}
+
+func (c *Client) NetworkFree(ctx context.Context, id string) (*metalgo.NetworkDetailResponse, error) {
+	f, err := c.begin(ctx, "NetworkFree")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.networks[id]
+	if !ok {
+		return nil, notFound("NetworkFree", "network", id)
+	}
+	for _, m := range c.machines {
+		for _, mn := range m.Allocation.Networks {
+			if metalgo.StrDeref(mn.Networkid) == id {
+				return nil, &metal.Error{Op: "NetworkFree", Class: metal.Conflict, Err: fmt.Errorf("network %s is still used by machine %s", id, *m.ID)}
+			}
+		}
+	}
+	delete(c.networks, id)
+	return &metalgo.NetworkDetailResponse{Network: n}, f.lost()
+}
+
+func (c *Client) NetworkGet(ctx context.Context, id string) (*metalgo.NetworkGetResponse, error) {
+	f, err := c.begin(ctx, "NetworkGet")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.networks[id]
+	if !ok {
+		return nil, notFound("NetworkGet", "network", id)
+	}
+	return &metalgo.NetworkGetResponse{Network: n}, f.lost()
+}
+
+func (c *Client) IPAllocate(ctx context.Context, req *metalgo.IPAllocateRequest) (*metalgo.IPDetailResponse, error) {
+	f, err := c.begin(ctx, "IPAllocate")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.networks[req.Networkid]; !ok {
+		return nil, notFound("IPAllocate", "network", req.Networkid)
+	}
+	address := req.IPAddress
+	if address == "" {
+		address = c.newAddress()
+	} else if _, ok := c.ips[address]; ok {
+		return nil, &metal.Error{Op: "IPAllocate", Class: metal.Conflict, Err: fmt.Errorf("ip %s is already allocated", address)}
+	}
+	ip := &models.V1IPResponse{
+		Ipaddress:   &address,
+		Name:        req.Name,
+		Description: req.Description,
+		Networkid:   &req.Networkid,
+		Projectid:   &req.Projectid,
+		Type:        &req.Type,
+		Tags:        req.Tags,
+	}
+	c.ips[address] = ip
+	return &metalgo.IPDetailResponse{IP: ip}, f.lost()
+}
+
+func (c *Client) IPFind(ctx context.Context, req *metalgo.IPFindRequest) (*metalgo.IPListResponse, error) {
+	f, err := c.begin(ctx, "IPFind")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp := &metalgo.IPListResponse{}
+	for _, address := range sortedKeys(c.ips) {
+		ip := c.ips[address]
+		if matches(req.IPAddress, address) && matches(req.ProjectID, metalgo.StrDeref(ip.Projectid)) &&
+			matches(req.NetworkID, metalgo.StrDeref(ip.Networkid)) && hasTags(ip.Tags, req.Tags) {
+			resp.IPs = append(resp.IPs, ip)
+		}
+	}
+	if f.Duplicate {
+		resp.IPs = append(resp.IPs, resp.IPs...)
+	}
+	return resp, f.lost()
+}
+
+func (c *Client) IPFree(ctx context.Context, id string) (*metalgo.IPDetailResponse, error) {
+	f, err := c.begin(ctx, "IPFree")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ip, ok := c.ips[id]
+	if !ok {
+		return nil, notFound("IPFree", "ip", id)
+	}
+	delete(c.ips, id)
+	return &metalgo.IPDetailResponse{IP: ip}, f.lost()
+}
+
+func (c *Client) IPGet(ctx context.Context, ip string) (*metalgo.IPDetailResponse, error) {
+	f, err := c.begin(ctx, "IPGet")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	found, ok := c.ips[ip]
+	if !ok {
+		return nil, notFound("IPGet", "ip", ip)
+	}
+	return &metalgo.IPDetailResponse{IP: found}, f.lost()
+}
+
+func (c *Client) FirewallCreate(ctx context.Context, req *metalgo.FirewallCreateRequest) (*metalgo.FirewallCreateResponse, error) {
+	f, err := c.begin(ctx, "FirewallCreate")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m, err := c.allocate("FirewallCreate", &req.MachineCreateRequest)
+	if err != nil {
+		return nil, err
+	}
+	return &metalgo.FirewallCreateResponse{Firewall: &models.V1FirewallResponse{
+		ID:         m.ID,
+		Name:       m.Name,
+		Partition:  m.Partition,
+		Allocation: m.Allocation,
+		Tags:       m.Tags,
+	}}, f.lost()
+}
+
+func (c *Client) MachineCreate(ctx context.Context, req *metalgo.MachineCreateRequest) (*metalgo.MachineCreateResponse, error) {
+	f, err := c.begin(ctx, "MachineCreate")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m, err := c.allocate("MachineCreate", req)
+	if err != nil {
+		return nil, err
+	}
+	return &metalgo.MachineCreateResponse{Machine: m}, f.lost()
+}
+
+// allocate makes up the machine which req allocates.
+func (c *Client) allocate(op string, req *metalgo.MachineCreateRequest) (*models.V1MachineResponse, error) {
+	id := c.newID("machine")
+	m := &models.V1MachineResponse{
+		ID:        id,
+		Name:      req.Name,
+		Partition: &models.V1PartitionResponse{ID: &req.Partition},
+		Allocation: &models.V1MachineAllocation{
+			Hostname:  &req.Hostname,
+			Name:      &req.Name,
+			Project:   &req.Project,
+			Succeeded: boolPtr(false),
+			UserData:  req.UserData,
+		},
+		Tags: req.Tags,
+	}
+	ips := req.IPs
+	for _, an := range req.Networks {
+		n, ok := c.networks[an.NetworkID]
+		if !ok {
+			return nil, notFound(op, "network", an.NetworkID)
+		}
+		mn := &models.V1MachineNetwork{
+			Networkid: n.ID,
+			Prefixes:  n.Prefixes,
+			Private:   boolPtr(n.Partitionid != ""),
+		}
+		if an.Autoacquire {
+			mn.Ips = []string{c.newAddress()}
+		}
+		for _, address := range ips {
+			if ip, ok := c.ips[address]; ok && metalgo.StrDeref(ip.Networkid) == an.NetworkID {
+				mn.Ips = append(mn.Ips, address)
+			}
+		}
+		m.Allocation.Networks = append(m.Allocation.Networks, mn)
+	}
+
+	c.machines[*id] = &machine{V1MachineResponse: m, created: time.Now()}
+	return m, nil
+}
+
+func (c *Client) MachineDelete(ctx context.Context, id string) (*metalgo.MachineDeleteResponse, error) {
+	f, err := c.begin(ctx, "MachineDelete")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m, ok := c.machines[id]
+	if !ok {
+		return nil, notFound("MachineDelete", "machine", id)
+	}
+	delete(c.machines, id)
+	return &metalgo.MachineDeleteResponse{Machine: m.V1MachineResponse}, f.lost()
+}
+
+func (c *Client) MachineGet(ctx context.Context, id string) (*metalgo.MachineGetResponse, error) {
+	f, err := c.begin(ctx, "MachineGet")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m, ok := c.machines[id]
+	if !ok {
+		return nil, notFound("MachineGet", "machine", id)
+	}
+	return &metalgo.MachineGetResponse{Machine: c.provisioned(m)}, f.lost()
+}
+
+func (c *Client) MachineFind(ctx context.Context, req *metalgo.MachineFindRequest) (*metalgo.MachineListResponse, error) {
+	f, err := c.begin(ctx, "MachineFind")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp := &metalgo.MachineListResponse{}
+	for _, id := range sortedKeys(c.machines) {
+		m := c.machines[id]
+		if matches(req.ID, id) && matches(req.Name, m.Name) && matches(req.PartitionID, metalgo.StrDeref(m.Partition.ID)) &&
+			matches(req.AllocationProject, metalgo.StrDeref(m.Allocation.Project)) && hasTags(m.Tags, req.Tags) &&
+			hasTags(networkIDs(m), req.NetworkIDs) {
+			resp.Machines = append(resp.Machines, c.provisioned(m))
+		}
+	}
+	if f.Duplicate {
+		resp.Machines = append(resp.Machines, resp.Machines...)
+	}
+	return resp, f.lost()
+}
+
+// provisioned copies m, which may be read concurrently, to tell whether it
+// has been provisioned by now. c.mu must be held.
+func (c *Client) provisioned(m *machine) *models.V1MachineResponse {
+	resp := *m.V1MachineResponse
+	allocation := *resp.Allocation
+	allocation.Succeeded = boolPtr(time.Since(m.created) >= c.ProvisioningDelay)
+	resp.Allocation = &allocation
+	return &resp
+}
+
+func (c *Client) PartitionGet(ctx context.Context, id string) (*metalgo.PartitionGetResponse, error) {
+	f, err := c.begin(ctx, "PartitionGet")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.partitions[id]
+	if !ok {
+		return nil, notFound("PartitionGet", "partition", id)
+	}
+	return &metalgo.PartitionGetResponse{Partition: p}, f.lost()
+}
+
+// PartitionList returns the partitions, sorted by ID. It isn't part of
+// metal.Client, but metal.Health calls it to probe metal-api.
+func (c *Client) PartitionList(ctx context.Context) (*metalgo.PartitionListResponse, error) {
+	f, err := c.begin(ctx, "PartitionList")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp := &metalgo.PartitionListResponse{}
+	for _, id := range sortedKeys(c.partitions) {
+		resp.Partition = append(resp.Partition, c.partitions[id])
+	}
+	return resp, f.lost()
+}
+
+func (c *Client) ProjectCreate(ctx context.Context, req mdv1.ProjectCreateRequest) (*metalgo.ProjectGetResponse, error) {
+	f, err := c.begin(ctx, "ProjectCreate")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := c.newID("project")
+	p := &models.V1ProjectResponse{
+		Meta:        &models.V1Meta{ID: *id},
+		Name:        req.Name,
+		Description: req.Description,
+		TenantID:    req.TenantId,
+	}
+	c.projects[*id] = p
+	return &metalgo.ProjectGetResponse{Project: p}, f.lost()
+}
+
+func (c *Client) ProjectFind(ctx context.Context, req mdv1.ProjectFindRequest) (*metalgo.ProjectListResponse, error) {
+	f, err := c.begin(ctx, "ProjectFind")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp := &metalgo.ProjectListResponse{}
+	for _, id := range sortedKeys(c.projects) {
+		p := c.projects[id]
+		if matches(req.Id, id) && matches(req.Name, p.Name) && matches(req.TenantId, p.TenantID) {
+			resp.Project = append(resp.Project, p)
+		}
+	}
+	if f.Duplicate {
+		resp.Project = append(resp.Project, resp.Project...)
+	}
+	return resp, f.lost()
+}
+
+func (c *Client) ProjectGet(ctx context.Context, id string) (*metalgo.ProjectGetResponse, error) {
+	f, err := c.begin(ctx, "ProjectGet")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.projects[id]
+	if !ok {
+		return nil, notFound("ProjectGet", "project", id)
+	}
+	return &metalgo.ProjectGetResponse{Project: p}, f.lost()
+}
+
+// begin counts a call of op and applies the faults injected for it. It
+// returns the fault to finish the call with, and the error the call fails
+// with right away, if any.
+func (c *Client) begin(ctx context.Context, op string) (Fault, error) {
+	c.mu.Lock()
+	c.calls[op]++
+	f := c.fault(op)
+	c.mu.Unlock()
+
+	if f.Delay > 0 {
+		t := time.NewTimer(f.Delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return f, &metal.Error{Op: op, Class: metal.Transient, Err: ctx.Err()}
+		}
+	}
+	if f.Class != "" {
+		return f, &metal.Error{Op: op, Class: f.Class, Err: fmt.Errorf("injected %s failure", f.Class)}
+	}
+	return f, nil
+}
+
+// fault merges the faults applying to the current call of op and removes
+// the ones which are used up. c.mu must be held.
+func (c *Client) fault(op string) Fault {
+	merged := Fault{Op: op}
+	left := c.faults[:0]
+	for _, f := range c.faults {
+		if f.Op != op {
+			left = append(left, f)
+			continue
+		}
+		f.calls++
+		if f.calls > f.After && (f.Nth <= 0 || f.calls == f.Nth) {
+			if merged.Class == "" {
+				merged.Class = f.Class
+			}
+			merged.Delay += f.Delay
+			merged.Drop = merged.Drop || f.Drop
+			merged.Duplicate = merged.Duplicate || f.Duplicate
+		}
+		if f.Nth <= 0 || f.calls < f.Nth {
+			left = append(left, f)
+		}
+	}
+	c.faults = left
+	return merged
+}
+
+// lost returns the error of a call whose response was dropped, or nil.
+func (f Fault) lost() error {
+	if !f.Drop {
+		return nil
+	}
+	return &metal.Error{Op: f.Op, Class: metal.Transient, Err: errors.New("injected loss of the response")}
+}
+
+// newID returns a new ID for a resource of the given kind. c.mu must be held.
+func (c *Client) newID(kind string) *string {
+	c.seq++
+	id := fmt.Sprintf("%s-%06d", kind, c.seq)
+	return &id
+}
+
+// newAddress returns a new address of TEST-NET-2, which is never routed.
+// c.mu must be held.
+func (c *Client) newAddress() string {
+	c.seq++
+	return fmt.Sprintf("198.51.%d.%d", (c.seq/254)%256, c.seq%254+1)
+}
+
+func notFound(op, kind, id string) error {
+	return &metal.Error{Op: op, Class: metal.NotFound, Err: fmt.Errorf("%s %s not found", kind, id)}
+}
+
+func matches(want *string, actual string) bool {
+	return want == nil || *want == "" || *want == actual
+}
+
+func hasLabels(labels, want map[string]string) bool {
+	for k, v := range want {
+		if actual, ok := labels[k]; !ok || actual != v {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTags(tags, want []string) bool {
+	have := map[string]bool{}
+	for _, t := range tags {
+		have[t] = true
+	}
+	for _, t := range want {
+		if !have[t] {
+			return false
+		}
+	}
+	return true
+}
+
+// networkIDs returns the IDs of the networks m is allocated in.
+func networkIDs(m *machine) []string {
+	var ids []string
+	for _, mn := range m.Allocation.Networks {
+		ids = append(ids, metalgo.StrDeref(mn.Networkid))
+	}
+	return ids
+}
+
+// sortedKeys returns the keys of m, a map keyed by strings, sorted.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func strPtr(s string) *string {
+	return &s
+}
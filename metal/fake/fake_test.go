@@ -0,0 +1,142 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metalgo "github.com/metal-stack/metal-go"
+
+	"github.com/LimKianAn/xcluster/metal"
+)
+
+func TestMachineLifecycle(t *testing.T) {
+	ctx := context.Background()
+	c := New()
+	c.ProvisioningDelay = time.Hour
+	c.AddPartition("vagrant", 24)
+
+	n, err := c.NetworkAllocate(ctx, &metalgo.NetworkAllocateRequest{Name: "n", PartitionID: "vagrant", ProjectID: "p"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := n.Network.Prefixes[0]; got[len(got)-3:] != "/24" {
+		t.Errorf("prefix %s is not of the partition's length", got)
+	}
+
+	m, err := c.MachineCreate(ctx, &metalgo.MachineCreateRequest{Name: "m", Partition: "vagrant", Project: "p", Networks: []metalgo.MachineAllocationNetwork{{NetworkID: *n.Network.ID, Autoacquire: true}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := c.MachineGet(ctx, *m.Machine.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *got.Machine.Allocation.Succeeded {
+		t.Error("machine provisioned before the delay passed")
+	}
+	if len(got.Machine.Allocation.Networks[0].Ips) != 1 {
+		t.Error("no IP acquired in the network")
+	}
+
+	if _, err := c.NetworkFree(ctx, *n.Network.ID); metal.ClassOf(err) != metal.Conflict {
+		t.Errorf("network in use freed, error: %v", err)
+	}
+	if _, err := c.MachineDelete(ctx, *m.Machine.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.NetworkFree(ctx, *n.Network.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ids := append(c.Networks(), c.Machines()...); len(ids) != 0 {
+		t.Errorf("resources leaked: %v", ids)
+	}
+}
+
+func TestFail(t *testing.T) {
+	ctx := context.Background()
+	c := New()
+
+	c.Fail("NetworkGet", metal.Transient)
+	if _, err := c.NetworkGet(ctx, "n"); metal.ClassOf(err) != metal.Transient {
+		t.Errorf("got %v, want a transient error", err)
+	}
+	c.Fail("NetworkGet", "")
+	if _, err := c.NetworkGet(ctx, "n"); !metal.IsNotFound(err) {
+		t.Errorf("got %v, want not found", err)
+	}
+	if got := c.Calls("NetworkGet"); got != 2 {
+		t.Errorf("got %d calls, want 2", got)
+	}
+}
+
+func TestInject(t *testing.T) {
+	ctx := context.Background()
+	c := New()
+	c.AddPartition("vagrant", 24)
+	req := &metalgo.NetworkAllocateRequest{Name: "n", PartitionID: "vagrant", ProjectID: "p"}
+
+	c.Inject(Fault{Op: "NetworkAllocate", Nth: 2, Class: metal.Transient})
+	if _, err := c.NetworkAllocate(ctx, req); err != nil {
+		t.Fatalf("first call failed: %v", err)
+	}
+	if _, err := c.NetworkAllocate(ctx, req); metal.ClassOf(err) != metal.Transient {
+		t.Errorf("got %v, want the second call to fail", err)
+	}
+	if _, err := c.NetworkAllocate(ctx, req); err != nil {
+		t.Errorf("third call failed: %v", err)
+	}
+	if got := len(c.ResourcesOf("p")); got != 2 {
+		t.Errorf("got %d networks, want 2 since the failed call changes nothing", got)
+	}
+
+	c.Inject(Fault{Op: "NetworkAllocate", Drop: true})
+	if _, err := c.NetworkAllocate(ctx, req); metal.ClassOf(err) != metal.Transient {
+		t.Errorf("got %v, want the response to be lost", err)
+	}
+	if got := len(c.ResourcesOf("p")); got != 3 {
+		t.Errorf("got %d networks, want 3 since the dropped call is committed", got)
+	}
+
+	c.Inject(Fault{Op: "NetworkFind", Duplicate: true})
+	found, err := c.NetworkFind(ctx, &metalgo.NetworkFindRequest{ProjectID: &req.ProjectID})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := len(found.Networks); got != 6 {
+		t.Errorf("got %d networks, want every one twice", got)
+	}
+
+	c.ClearFaults()
+	c.Inject(Fault{Op: "NetworkGet", After: 1, Class: metal.Transient})
+	for i := 1; i <= 3; i++ {
+		_, err := c.NetworkGet(ctx, "n")
+		if transient := metal.ClassOf(err) == metal.Transient; transient != (i > 1) {
+			t.Errorf("got %v from call %d, want only the calls after the first to fail", err, i)
+		}
+	}
+
+	c.ClearFaults()
+	c.Inject(Fault{Op: "NetworkGet", Delay: time.Hour})
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.NetworkGet(timeout, "n"); metal.ClassOf(err) != metal.Transient {
+		t.Errorf("got %v, want the delayed call to time out", err)
+	}
+}
@@ -0,0 +1,403 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	mdv1 "github.com/metal-stack/masterdata-api/api/rest/v1"
+	metalgo "github.com/metal-stack/metal-go"
+	"github.com/metal-stack/metal-go/api/models"
+	"github.com/metal-stack/metal-lib/httperrors"
+	"github.com/metal-stack/security"
+
+	"github.com/LimKianAn/xcluster/metal"
+)
+
+// HMACAuthType is the type of the HMAC authentication metalgo.Driver uses by
+// default.
+const HMACAuthType = "Metal-Admin"
+
+// NewServer returns an http.Handler serving c as metal-api, so that a
+// metalgo.Driver can talk to it. Only the endpoints behind metal.Client and
+// metal.Health are served, under any base path. Unless hmacKey is empty,
+// requests other than for the version have to be authenticated with it.
+func NewServer(c *Client, hmacKey string) http.Handler {
+	s := &server{client: c}
+	if hmacKey != "" {
+		auth := security.NewHMACAuth(HMACAuthType, []byte(hmacKey))
+		s.auth = &auth
+	}
+	return s
+}
+
+type server struct {
+	client *Client
+	auth   *security.HMACAuth
+}
+
+// handler answers a request matching a route. arg is the path segment matched
+// by the placeholder of the route, if any, and decode reads the request body.
+type handler func(ctx context.Context, arg string, decode func(interface{}) error) (interface{}, error)
+
+type route struct {
+	method string
+	// pattern is the path below /v1/, in which "*" matches one segment.
+	pattern string
+	// status is the status code of a successful answer.
+	status int
+	handle handler
+}
+
+func (s *server) routes() []route {
+	c := s.client
+	return []route{
+		{http.MethodPost, "network/allocate", http.StatusCreated, func(ctx context.Context, _ string, decode func(interface{}) error) (interface{}, error) {
+			var req models.V1NetworkAllocateRequest
+			if err := decode(&req); err != nil {
+				return nil, err
+			}
+			resp, err := c.NetworkAllocate(ctx, &metalgo.NetworkAllocateRequest{
+				Description: req.Description,
+				Name:        req.Name,
+				PartitionID: req.Partitionid,
+				ProjectID:   req.Projectid,
+				Shared:      req.Shared,
+				Labels:      req.Labels,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return resp.Network, nil
+		}},
+		{http.MethodPost, "network/find", http.StatusOK, func(ctx context.Context, _ string, decode func(interface{}) error) (interface{}, error) {
+			var req models.V1NetworkFindRequest
+			if err := decode(&req); err != nil {
+				return nil, err
+			}
+			resp, err := c.NetworkFind(ctx, &metalgo.NetworkFindRequest{
+				ID:          &req.ID,
+				Name:        &req.Name,
+				PartitionID: &req.Partitionid,
+				ProjectID:   &req.Projectid,
+				Labels:      req.Labels,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return resp.Networks, nil
+		}},
+		{http.MethodPost, "network/free/*", http.StatusOK, func(ctx context.Context, id string, _ func(interface{}) error) (interface{}, error) {
+			resp, err := c.NetworkFree(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			return resp.Network, nil
+		}},
+		{http.MethodGet, "network/*", http.StatusOK, func(ctx context.Context, id string, _ func(interface{}) error) (interface{}, error) {
+			resp, err := c.NetworkGet(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			return resp.Network, nil
+		}},
+		{http.MethodPost, "ip/allocate", http.StatusCreated, s.allocateIP},
+		{http.MethodPost, "ip/allocate/*", http.StatusCreated, s.allocateIP},
+		{http.MethodPost, "ip/find", http.StatusOK, func(ctx context.Context, _ string, decode func(interface{}) error) (interface{}, error) {
+			var req models.V1IPFindRequest
+			if err := decode(&req); err != nil {
+				return nil, err
+			}
+			resp, err := c.IPFind(ctx, &metalgo.IPFindRequest{
+				IPAddress: &req.Ipaddress,
+				ProjectID: &req.Projectid,
+				NetworkID: &req.Networkid,
+				Tags:      req.Tags,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return resp.IPs, nil
+		}},
+		{http.MethodPost, "ip/free/*", http.StatusOK, func(ctx context.Context, address string, _ func(interface{}) error) (interface{}, error) {
+			resp, err := c.IPFree(ctx, address)
+			if err != nil {
+				return nil, err
+			}
+			return resp.IP, nil
+		}},
+		{http.MethodGet, "ip/*", http.StatusOK, func(ctx context.Context, address string, _ func(interface{}) error) (interface{}, error) {
+			resp, err := c.IPGet(ctx, address)
+			if err != nil {
+				return nil, err
+			}
+			return resp.IP, nil
+		}},
+		{http.MethodPost, "firewall/allocate", http.StatusOK, func(ctx context.Context, _ string, decode func(interface{}) error) (interface{}, error) {
+			var req models.V1FirewallCreateRequest
+			if err := decode(&req); err != nil {
+				return nil, err
+			}
+			resp, err := c.FirewallCreate(ctx, &metalgo.FirewallCreateRequest{MachineCreateRequest: metalgo.MachineCreateRequest{
+				Description:   req.Description,
+				Hostname:      req.Hostname,
+				Name:          req.Name,
+				UserData:      req.UserData,
+				Size:          metalgo.StrDeref(req.Sizeid),
+				Project:       metalgo.StrDeref(req.Projectid),
+				Partition:     metalgo.StrDeref(req.Partitionid),
+				Image:         metalgo.StrDeref(req.Imageid),
+				Tags:          req.Tags,
+				SSHPublicKeys: req.SSHPubKeys,
+				UUID:          req.UUID,
+				Networks:      allocationNetworks(req.Networks),
+				IPs:           req.Ips,
+			}})
+			if err != nil {
+				return nil, err
+			}
+			return resp.Firewall, nil
+		}},
+		{http.MethodPost, "machine/allocate", http.StatusOK, func(ctx context.Context, _ string, decode func(interface{}) error) (interface{}, error) {
+			var req models.V1MachineAllocateRequest
+			if err := decode(&req); err != nil {
+				return nil, err
+			}
+			resp, err := c.MachineCreate(ctx, &metalgo.MachineCreateRequest{
+				Description:   req.Description,
+				Hostname:      req.Hostname,
+				Name:          req.Name,
+				UserData:      req.UserData,
+				Size:          metalgo.StrDeref(req.Sizeid),
+				Project:       metalgo.StrDeref(req.Projectid),
+				Partition:     metalgo.StrDeref(req.Partitionid),
+				Image:         metalgo.StrDeref(req.Imageid),
+				Tags:          req.Tags,
+				SSHPublicKeys: req.SSHPubKeys,
+				UUID:          req.UUID,
+				Networks:      allocationNetworks(req.Networks),
+				IPs:           req.Ips,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return resp.Machine, nil
+		}},
+		{http.MethodDelete, "machine/*/free", http.StatusOK, func(ctx context.Context, id string, _ func(interface{}) error) (interface{}, error) {
+			resp, err := c.MachineDelete(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			return resp.Machine, nil
+		}},
+		{http.MethodPost, "machine/find", http.StatusOK, func(ctx context.Context, _ string, decode func(interface{}) error) (interface{}, error) {
+			var req models.V1MachineFindRequest
+			if err := decode(&req); err != nil {
+				return nil, err
+			}
+			resp, err := c.MachineFind(ctx, &metalgo.MachineFindRequest{
+				ID:                &req.ID,
+				Name:              &req.Name,
+				PartitionID:       &req.PartitionID,
+				Tags:              req.Tags,
+				AllocationProject: &req.AllocationProject,
+				NetworkIDs:        req.NetworkIds,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return resp.Machines, nil
+		}},
+		{http.MethodGet, "machine/*", http.StatusOK, func(ctx context.Context, id string, _ func(interface{}) error) (interface{}, error) {
+			resp, err := c.MachineGet(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			return resp.Machine, nil
+		}},
+		{http.MethodGet, "partition/*", http.StatusOK, func(ctx context.Context, id string, _ func(interface{}) error) (interface{}, error) {
+			resp, err := c.PartitionGet(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			return resp.Partition, nil
+		}},
+		{http.MethodGet, "partition", http.StatusOK, func(ctx context.Context, _ string, _ func(interface{}) error) (interface{}, error) {
+			resp, err := c.PartitionList(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return resp.Partition, nil
+		}},
+		{http.MethodGet, "version", http.StatusOK, func(context.Context, string, func(interface{}) error) (interface{}, error) {
+			return &models.RestVersion{
+				Name:      strPtr("metal-api"),
+				Version:   strPtr(c.Version),
+				Revision:  strPtr("fake"),
+				Gitsha1:   strPtr("fake"),
+				Builddate: strPtr("fake"),
+			}, nil
+		}},
+		{http.MethodPut, "project", http.StatusCreated, func(ctx context.Context, _ string, decode func(interface{}) error) (interface{}, error) {
+			var req models.V1ProjectCreateRequest
+			if err := decode(&req); err != nil {
+				return nil, err
+			}
+			resp, err := c.ProjectCreate(ctx, mdv1.ProjectCreateRequest{Project: mdv1.Project{
+				Name:        req.Name,
+				Description: req.Description,
+				TenantId:    req.TenantID,
+			}})
+			if err != nil {
+				return nil, err
+			}
+			return resp.Project, nil
+		}},
+		{http.MethodPost, "project/find", http.StatusOK, func(ctx context.Context, _ string, decode func(interface{}) error) (interface{}, error) {
+			var req models.V1ProjectFindRequest
+			if err := decode(&req); err != nil {
+				return nil, err
+			}
+			resp, err := c.ProjectFind(ctx, mdv1.ProjectFindRequest{Id: &req.ID, Name: &req.Name, TenantId: &req.TenantID})
+			if err != nil {
+				return nil, err
+			}
+			return resp.Project, nil
+		}},
+		{http.MethodGet, "project/*", http.StatusOK, func(ctx context.Context, id string, _ func(interface{}) error) (interface{}, error) {
+			resp, err := c.ProjectGet(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			return resp.Project, nil
+		}},
+	}
+}
+
+func (s *server) allocateIP(ctx context.Context, address string, decode func(interface{}) error) (interface{}, error) {
+	var req models.V1IPAllocateRequest
+	if err := decode(&req); err != nil {
+		return nil, err
+	}
+	resp, err := s.client.IPAllocate(ctx, &metalgo.IPAllocateRequest{
+		IPAddress:   address,
+		Description: req.Description,
+		Name:        req.Name,
+		Networkid:   metalgo.StrDeref(req.Networkid),
+		Projectid:   metalgo.StrDeref(req.Projectid),
+		Type:        metalgo.StrDeref(req.Type),
+		Tags:        req.Tags,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.IP, nil
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	i := strings.Index(r.URL.Path, "/v1/")
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	path := r.URL.Path[i+len("/v1/"):]
+
+	// Like metal-api, the version is served to anyone.
+	if s.auth != nil && path != "version" {
+		if _, err := s.auth.User(r); err != nil {
+			writeJSON(w, http.StatusUnauthorized, httperrors.HTTPErrorResponse{StatusCode: http.StatusUnauthorized, Message: err.Error()})
+			return
+		}
+	}
+
+	for _, rt := range s.routes() {
+		arg, ok := match(rt.pattern, path)
+		if !ok || rt.method != r.Method {
+			continue
+		}
+		decode := func(v interface{}) error {
+			if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+				return &metal.Error{Op: "decode", Class: metal.Permanent, Err: err}
+			}
+			return nil
+		}
+		resp, err := rt.handle(r.Context(), arg, decode)
+		if err != nil {
+			status := statusOf(err)
+			writeJSON(w, status, httperrors.HTTPErrorResponse{StatusCode: status, Message: err.Error()})
+			return
+		}
+		writeJSON(w, rt.status, resp)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// match reports whether path matches pattern and returns the segment matched
+// by "*", if any.
+func match(pattern, path string) (string, bool) {
+	want := strings.Split(pattern, "/")
+	got := strings.Split(path, "/")
+	if len(want) != len(got) {
+		return "", false
+	}
+	var arg string
+	for i := range want {
+		switch {
+		case want[i] == "*" && got[i] != "":
+			arg = got[i]
+		case want[i] != got[i]:
+			return "", false
+		}
+	}
+	return arg, true
+}
+
+// statusOf returns the status code metal-api answers err with.
+func statusOf(err error) int {
+	switch metal.ClassOf(err) {
+	case metal.NotFound:
+		return http.StatusNotFound
+	case metal.Conflict:
+		return http.StatusConflict
+	case metal.Transient:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusUnprocessableEntity
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	// The status is sent already, so there's nobody left to tell about errors.
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func allocationNetworks(networks []*models.V1MachineAllocationNetwork) []metalgo.MachineAllocationNetwork {
+	var result []metalgo.MachineAllocationNetwork
+	for _, n := range networks {
+		result = append(result, metalgo.MachineAllocationNetwork{
+			NetworkID:   metalgo.StrDeref(n.Networkid),
+			Autoacquire: metalgo.BoolDeref(n.Autoacquire),
+		})
+	}
+	return result
+}
@@ -0,0 +1,169 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mdv1 "github.com/metal-stack/masterdata-api/api/rest/v1"
+	metalgo "github.com/metal-stack/metal-go"
+
+	"github.com/LimKianAn/xcluster/metal"
+)
+
+// TestServer talks to the server through metalgo.Driver, so that the requests
+// and responses go through the serialization of metal-go.
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	backend := New()
+	backend.AddPartition("vagrant", 24)
+	srv := httptest.NewServer(NewServer(backend, "secret"))
+	defer srv.Close()
+
+	driver, err := metalgo.NewDriver(srv.URL+"/metal", "", "secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := metal.NewClient(driver)
+
+	project, err := c.ProjectCreate(ctx, mdv1.ProjectCreateRequest{Project: mdv1.Project{Name: "p", TenantId: "t"}})
+	if err != nil {
+		t.Fatalf("failed to create project: %v", err)
+	}
+	projectID := project.Project.Meta.ID
+	name := "p"
+	if found, err := c.ProjectFind(ctx, mdv1.ProjectFindRequest{Name: &name}); err != nil || len(found.Project) != 1 {
+		t.Fatalf("project not found: %v", err)
+	}
+
+	n, err := c.NetworkAllocate(ctx, &metalgo.NetworkAllocateRequest{Name: "n", PartitionID: "vagrant", ProjectID: projectID, Labels: map[string]string{"a": "b"}})
+	if err != nil {
+		t.Fatalf("failed to allocate network: %v", err)
+	}
+	networkID := *n.Network.ID
+	if got, err := c.NetworkGet(ctx, networkID); err != nil || got.Network.Labels["a"] != "b" {
+		t.Fatalf("network not read back: %v", err)
+	}
+	if p, err := c.PartitionGet(ctx, "vagrant"); err != nil || p.Partition.Privatenetworkprefixlength != 24 {
+		t.Fatalf("partition not read back: %v", err)
+	}
+
+	ip, err := c.IPAllocate(ctx, &metalgo.IPAllocateRequest{Networkid: networkID, Projectid: projectID, Type: "static", Tags: []string{"t"}})
+	if err != nil {
+		t.Fatalf("failed to allocate IP: %v", err)
+	}
+	if found, err := c.IPFind(ctx, &metalgo.IPFindRequest{Tags: []string{"t"}}); err != nil || len(found.IPs) != 1 {
+		t.Fatalf("IP not found: %v", err)
+	}
+	if _, err := c.IPFree(ctx, *ip.IP.Ipaddress); err != nil {
+		t.Fatalf("failed to free IP: %v", err)
+	}
+
+	fw, err := c.FirewallCreate(ctx, &metalgo.FirewallCreateRequest{MachineCreateRequest: metalgo.MachineCreateRequest{
+		Name:      "fw",
+		Partition: "vagrant",
+		Project:   projectID,
+		Networks:  []metalgo.MachineAllocationNetwork{{NetworkID: networkID, Autoacquire: true}},
+		Tags:      []string{"owner=test"},
+	}})
+	if err != nil {
+		t.Fatalf("failed to create firewall: %v", err)
+	}
+	found, err := c.MachineFind(ctx, &metalgo.MachineFindRequest{AllocationProject: &projectID, Tags: []string{"owner=test"}})
+	if err != nil || len(found.Machines) != 1 {
+		t.Fatalf("firewall not found by its tag: %v", err)
+	}
+	m, err := c.MachineGet(ctx, *fw.Firewall.ID)
+	if err != nil {
+		t.Fatalf("failed to get machine: %v", err)
+	}
+	if !*m.Machine.Allocation.Succeeded || len(m.Machine.Allocation.Networks[0].Ips) != 1 {
+		t.Errorf("firewall not provisioned in the network: %+v", m.Machine.Allocation)
+	}
+
+	if _, err := c.NetworkFree(ctx, networkID); metal.ClassOf(err) != metal.Conflict {
+		t.Errorf("got %v, want a conflict", err)
+	}
+	if _, err := c.MachineDelete(ctx, *fw.Firewall.ID); err != nil {
+		t.Fatalf("failed to delete machine: %v", err)
+	}
+	if _, err := c.MachineGet(ctx, *fw.Firewall.ID); !metal.IsNotFound(err) {
+		t.Errorf("got %v, want not found", err)
+	}
+	if _, err := c.NetworkFree(ctx, networkID); err != nil {
+		t.Fatalf("failed to free network: %v", err)
+	}
+}
+
+func TestServerRejectsWrongHMAC(t *testing.T) {
+	srv := httptest.NewServer(NewServer(New(), "secret"))
+	defer srv.Close()
+
+	driver, err := metalgo.NewDriver(srv.URL, "", "wrong")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := metal.NewClient(driver).NetworkGet(context.Background(), "n"); err == nil || metal.IsNotFound(err) {
+		t.Errorf("got %v, want the request to be rejected", err)
+	}
+}
+
+func TestServerHealth(t *testing.T) {
+	ctx := context.Background()
+	backend := New()
+	backend.AddPartition("vagrant", 24)
+	backend.Version = "v0.12.0"
+	srv := httptest.NewServer(NewServer(backend, "secret"))
+	defer srv.Close()
+
+	health := func(hmac string) *metal.Health {
+		driver, err := metalgo.NewDriver(srv.URL+"/metal", "", hmac)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		h, err := metal.NewHealth(driver, srv.URL+"/metal", time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return h
+	}
+
+	h := health("secret")
+	if err := h.Ping(ctx); err != nil {
+		t.Errorf("got %v, want metal-api reachable", err)
+	}
+	if v, err := h.Version(ctx); err != nil || v != "v0.12.0" {
+		t.Errorf("got version %q and %v, want v0.12.0", v, err)
+	}
+
+	backend.Fail("PartitionList", metal.Transient)
+	if err := h.Ping(ctx); !metal.IsTransient(err) {
+		t.Errorf("got %v, want a transient error", err)
+	}
+	backend.Fail("PartitionList", "")
+
+	h = health("wrong")
+	if err := h.Ping(ctx); err == nil || metal.IsTransient(err) {
+		t.Errorf("got %v, want the credentials to be rejected", err)
+	}
+	if _, err := h.Version(ctx); err != nil {
+		t.Errorf("got %v, want the version served without credentials", err)
+	}
+}
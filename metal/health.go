@@ -0,0 +1,149 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	httptransport "github.com/go-openapi/runtime/client"
+	"github.com/go-openapi/strfmt"
+	metalgo "github.com/metal-stack/metal-go"
+	"github.com/metal-stack/metal-go/api/client/version"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+)
+
+// MinVersion is the oldest metal-api xcluster works with.
+const MinVersion = "v0.11.0"
+
+// Health checks whether metal-api can be reached. It talks to metal-api
+// directly rather than through a Client, so that its probes are neither
+// retried, held back by the circuit breaker, serialized nor recorded.
+type Health struct {
+	// list lists the partitions, which is how metal-api is probed.
+	list    func() error
+	version version.ClientService
+	timeout time.Duration
+
+	mu sync.Mutex
+	// inFlight is the probe waiting for metal-api, if any.
+	inFlight *probe
+}
+
+// probe is a call of Health.list, whose outcome is shared by every Ping made
+// while it is in flight.
+type probe struct {
+	done chan struct{}
+	err  error
+}
+
+// NewHealth returns the Health of the metal-api at rawURL, which driver talks
+// to. Every probe gives up after timeout.
+func NewHealth(driver *metalgo.Driver, rawURL string, timeout time.Duration) (*Health, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metal-api url: %w", err)
+	}
+	transport := httptransport.New(u.Host, u.Path, []string{u.Scheme})
+	return &Health{
+		list: func() error {
+			_, err := driver.PartitionList()
+			return err
+		},
+		version: version.New(transport, strfmt.Default),
+		timeout: timeout,
+	}, nil
+}
+
+// Ping lists the partitions, which is cheap and fails unless metal-api can be
+// reached and accepts the credentials of the driver. The driver takes no
+// context, so a call can't be cancelled once metal-api hangs. Only one call is
+// made at a time therefore, and Pings made meanwhile wait for its outcome
+// instead of piling up calls.
+func (h *Health) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	p := h.startProbe()
+	select {
+	case <-p.done:
+		if p.err != nil {
+			return &Error{Op: "PartitionList", Class: classify(p.err), Err: p.err}
+		}
+		return nil
+	case <-ctx.Done():
+		return &Error{Op: "PartitionList", Class: Transient, Err: ctx.Err()}
+	}
+}
+
+// startProbe returns the probe in flight or starts one.
+func (h *Health) startProbe() *probe {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.inFlight != nil {
+		return h.inFlight
+	}
+
+	p := &probe{done: make(chan struct{})}
+	h.inFlight = p
+	go func() {
+		p.err = h.list()
+		h.mu.Lock()
+		h.inFlight = nil
+		h.mu.Unlock()
+		close(p.done)
+	}()
+	return p
+}
+
+// Check is Ping as a healthz.Checker of controller-runtime.
+func (h *Health) Check(req *http.Request) error {
+	return h.Ping(req.Context())
+}
+
+// Version returns the version metal-api reports.
+func (h *Health) Version(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	resp, err := h.version.Info(version.NewInfoParamsWithContext(ctx), nil)
+	if err != nil {
+		return "", &Error{Op: "Version", Class: classify(err), Err: err}
+	}
+	return metalgo.StrDeref(resp.Payload.Version), nil
+}
+
+// CheckVersion fails if v is older than min. Versions which aren't semantic
+// versions, e.g. of development builds, are taken to be compatible.
+func CheckVersion(v, min string) error {
+	got, err := utilversion.ParseSemantic(v)
+	if err != nil {
+		return nil
+	}
+	want, err := utilversion.ParseSemantic(min)
+	if err != nil {
+		return fmt.Errorf("failed to parse minimal metal-api version: %w", err)
+	}
+	if !got.AtLeast(want) {
+		return fmt.Errorf("metal-api %s is older than %s", v, min)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/runtime"
+)
+
+func TestPing(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{name: "reachable"},
+		{name: "rejected credentials", err: runtime.NewAPIError("listPartitions", nil, 401), want: Permanent},
+		{name: "unavailable", err: runtime.NewAPIError("listPartitions", nil, 503), want: Transient},
+	} {
+		h := &Health{list: func() error { return tc.err }, timeout: time.Second}
+		err := h.Ping(context.Background())
+		if tc.err == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		var merr *Error
+		if !errors.As(err, &merr) || merr.Class != tc.want || !errors.Is(err, tc.err) {
+			t.Errorf("%s: got %v, want a %s error wrapping %v", tc.name, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestPingTimesOutOnceInFlight(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	h := &Health{
+		list: func() error {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return nil
+		},
+		timeout: 10 * time.Millisecond,
+	}
+
+	// metal-api hangs, so every Ping times out while they share one probe.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := h.Ping(context.Background())
+			if ClassOf(err) != Transient || !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("got %v, want a transient timeout", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := h.Ping(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want a timeout", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("got %d probes in flight, want 1", got)
+	}
+
+	// Once metal-api answers, the next Ping probes it anew.
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for h.Ping(context.Background()) != nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := h.Ping(context.Background()); err != nil {
+		t.Errorf("unexpected error once metal-api answers: %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got < 2 {
+		t.Errorf("got %d probes, want the ones after the hang to be made anew", got)
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		wantErr bool
+	}{
+		{version: "v0.11.0"},
+		{version: "v0.11.2"},
+		{version: "v1.0.0"},
+		{version: "v0.10.9", wantErr: true},
+		{version: "v0.11.0-rc.1", wantErr: true},
+		{version: "devel"},
+	} {
+		if err := CheckVersion(tc.version, MinVersion); (err != nil) != tc.wantErr {
+			t.Errorf("CheckVersion(%q): got %v, want error %t", tc.version, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metal
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xcluster_metal_api_requests_total",
+		Help: "Number of metal-api calls by operation and result (success or error class).",
+	}, []string{"operation", "result"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "xcluster_metal_api_request_duration_seconds",
+		Help:    "Duration of metal-api calls including retries.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	retriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xcluster_metal_api_retries_total",
+		Help: "Number of retried metal-api calls by operation.",
+	}, []string{"operation"})
+
+	circuitBreakerState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "xcluster_metal_api_circuit_breaker_state",
+		Help: "State of the circuit breaker around metal-api: 0 closed, 1 half-open, 2 open.",
+	})
+
+	serializationWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "xcluster_metal_api_serialization_wait_seconds",
+		Help:    "Time metal-api calls waited for other allocations in the same project and partition.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(requestsTotal, requestDuration, retriesTotal, circuitBreakerState, serializationWait)
+}
@@ -0,0 +1,237 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-logr/logr"
+	mdv1 "github.com/metal-stack/masterdata-api/api/rest/v1"
+	metalgo "github.com/metal-stack/metal-go"
+)
+
+// Interaction is a metal-api call and its outcome.
+type Interaction struct {
+	// Operation is the name of the Client method called.
+	Operation string          `json:"operation"`
+	Request   json.RawMessage `json:"request"`
+	// Response is the response of a successful call.
+	Response json.RawMessage `json:"response,omitempty"`
+	// Error is the error of a failed call.
+	Error *RecordedError `json:"error,omitempty"`
+}
+
+// RecordedError is the error an interaction failed with.
+type RecordedError struct {
+	Class   ErrorClass `json:"class"`
+	Message string     `json:"message"`
+}
+
+// Cassette holds the interactions with metal-api in the order they took
+// place.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// LoadCassette reads the cassette at path.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	c := &Cassette{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Save writes c to path. The file is replaced at once, so that readers
+// never see half a cassette.
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save cassette: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save cassette: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save cassette: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save cassette: %w", err)
+	}
+	return nil
+}
+
+// WithRecording returns a Client which passes every call through to next
+// and records it with its outcome in the cassette at path. The cassette is
+// saved after every call, so that it is complete whenever the process is
+// stopped. Failing to save it is logged but doesn't fail the call.
+func WithRecording(next Client, path string, log logr.Logger) Client {
+	return &recordingClient{next: next, path: path, log: log}
+}
+
+type recordingClient struct {
+	next Client
+	path string
+	log  logr.Logger
+
+	// mu serializes the recording, so that the cassette is saved in the
+	// order of the interactions.
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// record appends the call of op with req and its outcome to the cassette
+// and saves it.
+func (c *recordingClient) record(op string, req, resp interface{}, err error) {
+	i := Interaction{Operation: op}
+	var merr error
+	if i.Request, merr = json.Marshal(req); merr != nil {
+		c.log.Error(merr, "failed to record metal-api request", "operation", op)
+		return
+	}
+	if err != nil {
+		i.Error = &RecordedError{Class: ClassOf(err), Message: err.Error()}
+	} else if i.Response, merr = json.Marshal(resp); merr != nil {
+		c.log.Error(merr, "failed to record metal-api response", "operation", op)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cassette.Interactions = append(c.cassette.Interactions, i)
+	if err := c.cassette.Save(c.path); err != nil {
+		c.log.Error(err, "failed to save the metal-api cassette", "path", c.path)
+	}
+}
+
+func (c *recordingClient) NetworkAllocate(ctx context.Context, req *metalgo.NetworkAllocateRequest) (*metalgo.NetworkDetailResponse, error) {
+	resp, err := c.next.NetworkAllocate(ctx, req)
+	c.record("NetworkAllocate", req, resp, err)
+	return resp, err
+}
+
+func (c *recordingClient) NetworkFind(ctx context.Context, req *metalgo.NetworkFindRequest) (*metalgo.NetworkListResponse, error) {
+	resp, err := c.next.NetworkFind(ctx, req)
+	c.record("NetworkFind", req, resp, err)
+	return resp, err
+}
+
+func (c *recordingClient) NetworkFree(ctx context.Context, id string) (*metalgo.NetworkDetailResponse, error) {
+	resp, err := c.next.NetworkFree(ctx, id)
+	c.record("NetworkFree", id, resp, err)
+	return resp, err
+}
+
+func (c *recordingClient) NetworkGet(ctx context.Context, id string) (*metalgo.NetworkGetResponse, error) {
+	resp, err := c.next.NetworkGet(ctx, id)
+	c.record("NetworkGet", id, resp, err)
+	return resp, err
+}
+
+func (c *recordingClient) IPAllocate(ctx context.Context, req *metalgo.IPAllocateRequest) (*metalgo.IPDetailResponse, error) {
+	resp, err := c.next.IPAllocate(ctx, req)
+	c.record("IPAllocate", req, resp, err)
+	return resp, err
+}
+
+func (c *recordingClient) IPFind(ctx context.Context, req *metalgo.IPFindRequest) (*metalgo.IPListResponse, error) {
+	resp, err := c.next.IPFind(ctx, req)
+	c.record("IPFind", req, resp, err)
+	return resp, err
+}
+
+func (c *recordingClient) IPFree(ctx context.Context, id string) (*metalgo.IPDetailResponse, error) {
+	resp, err := c.next.IPFree(ctx, id)
+	c.record("IPFree", id, resp, err)
+	return resp, err
+}
+
+func (c *recordingClient) IPGet(ctx context.Context, ip string) (*metalgo.IPDetailResponse, error) {
+	resp, err := c.next.IPGet(ctx, ip)
+	c.record("IPGet", ip, resp, err)
+	return resp, err
+}
+
+func (c *recordingClient) FirewallCreate(ctx context.Context, req *metalgo.FirewallCreateRequest) (*metalgo.FirewallCreateResponse, error) {
+	resp, err := c.next.FirewallCreate(ctx, req)
+	c.record("FirewallCreate", req, resp, err)
+	return resp, err
+}
+
+func (c *recordingClient) MachineCreate(ctx context.Context, req *metalgo.MachineCreateRequest) (*metalgo.MachineCreateResponse, error) {
+	resp, err := c.next.MachineCreate(ctx, req)
+	c.record("MachineCreate", req, resp, err)
+	return resp, err
+}
+
+func (c *recordingClient) MachineDelete(ctx context.Context, id string) (*metalgo.MachineDeleteResponse, error) {
+	resp, err := c.next.MachineDelete(ctx, id)
+	c.record("MachineDelete", id, resp, err)
+	return resp, err
+}
+
+func (c *recordingClient) MachineFind(ctx context.Context, req *metalgo.MachineFindRequest) (*metalgo.MachineListResponse, error) {
+	resp, err := c.next.MachineFind(ctx, req)
+	c.record("MachineFind", req, resp, err)
+	return resp, err
+}
+
+func (c *recordingClient) MachineGet(ctx context.Context, id string) (*metalgo.MachineGetResponse, error) {
+	resp, err := c.next.MachineGet(ctx, id)
+	c.record("MachineGet", id, resp, err)
+	return resp, err
+}
+
+func (c *recordingClient) PartitionGet(ctx context.Context, id string) (*metalgo.PartitionGetResponse, error) {
+	resp, err := c.next.PartitionGet(ctx, id)
+	c.record("PartitionGet", id, resp, err)
+	return resp, err
+}
+
+func (c *recordingClient) ProjectCreate(ctx context.Context, req mdv1.ProjectCreateRequest) (*metalgo.ProjectGetResponse, error) {
+	resp, err := c.next.ProjectCreate(ctx, req)
+	c.record("ProjectCreate", req, resp, err)
+	return resp, err
+}
+
+func (c *recordingClient) ProjectFind(ctx context.Context, req mdv1.ProjectFindRequest) (*metalgo.ProjectListResponse, error) {
+	resp, err := c.next.ProjectFind(ctx, req)
+	c.record("ProjectFind", req, resp, err)
+	return resp, err
+}
+
+func (c *recordingClient) ProjectGet(ctx context.Context, id string) (*metalgo.ProjectGetResponse, error) {
+	resp, err := c.next.ProjectGet(ctx, id)
+	c.record("ProjectGet", id, resp, err)
+	return resp, err
+}
@@ -0,0 +1,267 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	mdv1 "github.com/metal-stack/masterdata-api/api/rest/v1"
+	metalgo "github.com/metal-stack/metal-go"
+)
+
+// ReplayClient is a Client answering calls from a cassette instead of asking
+// metal-api. A call is answered by the recorded interactions of the same
+// operation and request, in the order they were recorded. Reads keep getting
+// the last recorded answer once the others are used up, since how often
+// something is read depends on timing. Every recorded mutation is answered
+// once. Any other call is unexpected and fails permanently.
+type ReplayClient struct {
+	mu         sync.Mutex
+	queues     map[string][]Interaction
+	unexpected []string
+}
+
+var _ Client = &ReplayClient{}
+
+// NewReplayClient returns a ReplayClient serving the interactions of c.
+func NewReplayClient(c *Cassette) (*ReplayClient, error) {
+	r := &ReplayClient{queues: map[string][]Interaction{}}
+	for _, i := range c.Interactions {
+		key, err := interactionKey(i.Operation, i.Request)
+		if err != nil {
+			return nil, err
+		}
+		r.queues[key] = append(r.queues[key], i)
+	}
+	return r, nil
+}
+
+// Unexpected returns the calls which weren't recorded, or were made more
+// often than recorded.
+func (r *ReplayClient) Unexpected() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.unexpected...)
+}
+
+// Pending returns the recorded mutations which haven't been replayed yet.
+func (r *ReplayClient) Pending() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var pending []string
+	for key, queue := range r.queues {
+		if len(queue) > 0 && mutates(queue[0].Operation) {
+			for range queue {
+				pending = append(pending, key)
+			}
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
+
+// replay answers the call of op with req by the next recorded interaction,
+// whose response is decoded into resp.
+func (r *ReplayClient) replay(op string, req, resp interface{}) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s request: %w", op, err)
+	}
+	key, err := interactionKey(op, data)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	queue := r.queues[key]
+	if len(queue) == 0 {
+		r.unexpected = append(r.unexpected, key)
+		r.mu.Unlock()
+		return &Error{Op: op, Class: Permanent, Err: fmt.Errorf("unexpected call %s", key)}
+	}
+	i := queue[0]
+	if len(queue) > 1 || mutates(op) {
+		r.queues[key] = queue[1:]
+	}
+	r.mu.Unlock()
+
+	if i.Error != nil {
+		return &Error{Op: op, Class: i.Error.Class, Err: errors.New(i.Error.Message)}
+	}
+	if err := json.Unmarshal(i.Response, resp); err != nil {
+		return fmt.Errorf("failed to decode recorded %s response: %w", op, err)
+	}
+	return nil
+}
+
+// interactionKey identifies the call of op with the encoded request, which
+// may have been indented when the cassette was saved.
+func interactionKey(op string, request []byte) (string, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, request); err != nil {
+		return "", fmt.Errorf("failed to compact %s request: %w", op, err)
+	}
+	return op + "(" + buf.String() + ")", nil
+}
+
+// mutates reports whether the Client method op changes metal-stack.
+func mutates(op string) bool {
+	return !strings.HasSuffix(op, "Get") && !strings.HasSuffix(op, "Find")
+}
+
+func (r *ReplayClient) NetworkAllocate(_ context.Context, req *metalgo.NetworkAllocateRequest) (*metalgo.NetworkDetailResponse, error) {
+	resp := &metalgo.NetworkDetailResponse{}
+	if err := r.replay("NetworkAllocate", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *ReplayClient) NetworkFind(_ context.Context, req *metalgo.NetworkFindRequest) (*metalgo.NetworkListResponse, error) {
+	resp := &metalgo.NetworkListResponse{}
+	if err := r.replay("NetworkFind", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *ReplayClient) NetworkFree(_ context.Context, id string) (*metalgo.NetworkDetailResponse, error) {
+	resp := &metalgo.NetworkDetailResponse{}
+	if err := r.replay("NetworkFree", id, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *ReplayClient) NetworkGet(_ context.Context, id string) (*metalgo.NetworkGetResponse, error) {
+	resp := &metalgo.NetworkGetResponse{}
+	if err := r.replay("NetworkGet", id, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *ReplayClient) IPAllocate(_ context.Context, req *metalgo.IPAllocateRequest) (*metalgo.IPDetailResponse, error) {
+	resp := &metalgo.IPDetailResponse{}
+	if err := r.replay("IPAllocate", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *ReplayClient) IPFind(_ context.Context, req *metalgo.IPFindRequest) (*metalgo.IPListResponse, error) {
+	resp := &metalgo.IPListResponse{}
+	if err := r.replay("IPFind", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *ReplayClient) IPFree(_ context.Context, id string) (*metalgo.IPDetailResponse, error) {
+	resp := &metalgo.IPDetailResponse{}
+	if err := r.replay("IPFree", id, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *ReplayClient) IPGet(_ context.Context, ip string) (*metalgo.IPDetailResponse, error) {
+	resp := &metalgo.IPDetailResponse{}
+	if err := r.replay("IPGet", ip, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *ReplayClient) FirewallCreate(_ context.Context, req *metalgo.FirewallCreateRequest) (*metalgo.FirewallCreateResponse, error) {
+	resp := &metalgo.FirewallCreateResponse{}
+	if err := r.replay("FirewallCreate", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *ReplayClient) MachineCreate(_ context.Context, req *metalgo.MachineCreateRequest) (*metalgo.MachineCreateResponse, error) {
+	resp := &metalgo.MachineCreateResponse{}
+	if err := r.replay("MachineCreate", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *ReplayClient) MachineDelete(_ context.Context, id string) (*metalgo.MachineDeleteResponse, error) {
+	resp := &metalgo.MachineDeleteResponse{}
+	if err := r.replay("MachineDelete", id, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *ReplayClient) MachineFind(_ context.Context, req *metalgo.MachineFindRequest) (*metalgo.MachineListResponse, error) {
+	resp := &metalgo.MachineListResponse{}
+	if err := r.replay("MachineFind", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *ReplayClient) MachineGet(_ context.Context, id string) (*metalgo.MachineGetResponse, error) {
+	resp := &metalgo.MachineGetResponse{}
+	if err := r.replay("MachineGet", id, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *ReplayClient) PartitionGet(_ context.Context, id string) (*metalgo.PartitionGetResponse, error) {
+	resp := &metalgo.PartitionGetResponse{}
+	if err := r.replay("PartitionGet", id, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *ReplayClient) ProjectCreate(_ context.Context, req mdv1.ProjectCreateRequest) (*metalgo.ProjectGetResponse, error) {
+	resp := &metalgo.ProjectGetResponse{}
+	if err := r.replay("ProjectCreate", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *ReplayClient) ProjectFind(_ context.Context, req mdv1.ProjectFindRequest) (*metalgo.ProjectListResponse, error) {
+	resp := &metalgo.ProjectListResponse{}
+	if err := r.replay("ProjectFind", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *ReplayClient) ProjectGet(_ context.Context, id string) (*metalgo.ProjectGetResponse, error) {
+	resp := &metalgo.ProjectGetResponse{}
+	if err := r.replay("ProjectGet", id, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,120 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metal
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	metalgo "github.com/metal-stack/metal-go"
+	"github.com/metal-stack/metal-go/api/models"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	id := "network-1"
+	find := &metalgo.NetworkFindRequest{ID: &id}
+
+	// Record a failed and a successful find, and a created firewall.
+	next := &flakyClient{errs: []error{&Error{Op: "NetworkFind", Class: Transient, Err: context.DeadlineExceeded}}}
+	rec := WithRecording(next, path, zap.New())
+	if _, err := rec.NetworkFind(ctx, find); ClassOf(err) != Transient {
+		t.Fatalf("got %v, want the transient error passed through", err)
+	}
+	if _, err := rec.NetworkFind(ctx, find); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := rec.FirewallCreate(ctx, &metalgo.FirewallCreateRequest{MachineCreateRequest: metalgo.MachineCreateRequest{Name: "fw"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("failed to load cassette: %v", err)
+	}
+	if got := len(cassette.Interactions); got != 3 {
+		t.Fatalf("got %d interactions, want 3", got)
+	}
+	r, err := NewReplayClient(cassette)
+	if err != nil {
+		t.Fatalf("failed to replay cassette: %v", err)
+	}
+	if pending := r.Pending(); len(pending) != 1 {
+		t.Errorf("got pending mutations %v, want the firewall creation", pending)
+	}
+
+	if _, err := r.NetworkFind(ctx, find); ClassOf(err) != Transient {
+		t.Errorf("got %v, want the recorded transient error", err)
+	}
+	// Reads keep getting the last recorded answer.
+	for i := 0; i < 2; i++ {
+		if _, err := r.NetworkFind(ctx, find); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if _, err := r.FirewallCreate(ctx, &metalgo.FirewallCreateRequest{MachineCreateRequest: metalgo.MachineCreateRequest{Name: "fw"}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(r.Pending()) != 0 || len(r.Unexpected()) != 0 {
+		t.Errorf("got pending %v and unexpected %v, want none", r.Pending(), r.Unexpected())
+	}
+
+	// Mutations are answered once, other requests not at all.
+	if _, err := r.FirewallCreate(ctx, &metalgo.FirewallCreateRequest{MachineCreateRequest: metalgo.MachineCreateRequest{Name: "fw"}}); ClassOf(err) != Permanent {
+		t.Errorf("got %v, want the repeated creation to be unexpected", err)
+	}
+	other := "network-2"
+	if _, err := r.NetworkFind(ctx, &metalgo.NetworkFindRequest{ID: &other}); ClassOf(err) != Permanent {
+		t.Errorf("got %v, want the other find to be unexpected", err)
+	}
+	if got := len(r.Unexpected()); got != 2 {
+		t.Errorf("got %d unexpected calls, want 2", got)
+	}
+}
+
+func TestReplayDecodesResponses(t *testing.T) {
+	id := "network-1"
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	c := &Cassette{Interactions: []Interaction{{
+		Operation: "NetworkGet",
+		Request:   []byte(`"network-1"`),
+		Response:  []byte(`{"Network": {"id": "network-1", "prefixes": ["10.0.0.0/22"]}}`),
+	}}}
+	if err := c.Save(path); err != nil {
+		t.Fatalf("failed to save cassette: %v", err)
+	}
+	loaded, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("failed to load cassette: %v", err)
+	}
+	r, err := NewReplayClient(loaded)
+	if err != nil {
+		t.Fatalf("failed to replay cassette: %v", err)
+	}
+
+	resp, err := r.NetworkGet(context.Background(), id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &models.V1NetworkResponse{ID: &id, Prefixes: []string{"10.0.0.0/22"}}
+	if *resp.Network.ID != *want.ID || resp.Network.Prefixes[0] != want.Prefixes[0] {
+		t.Errorf("got %+v, want %+v", resp.Network, want)
+	}
+}
@@ -0,0 +1,407 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	mdv1 "github.com/metal-stack/masterdata-api/api/rest/v1"
+	metalgo "github.com/metal-stack/metal-go"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// ResilienceOptions configures WithResilience.
+type ResilienceOptions struct {
+	// Timeout bounds every single attempt of a call.
+	Timeout time.Duration
+	// MaxRetries is how often idempotent calls are retried on transient errors.
+	MaxRetries int
+	// Backoff is the wait before the first retry. It doubles with every retry
+	// and is jittered by up to 100%.
+	Backoff time.Duration
+	// FailureThreshold is the number of consecutive transient failures which
+	// opens the circuit breaker.
+	FailureThreshold int
+	// Cooldown is how long the circuit breaker stays open before a single
+	// trial call is let through.
+	Cooldown time.Duration
+}
+
+// DefaultResilienceOptions returns the options used by the manager unless
+// overridden by flags.
+func DefaultResilienceOptions() ResilienceOptions {
+	return ResilienceOptions{
+		Timeout:          10 * time.Second,
+		MaxRetries:       3,
+		Backoff:          200 * time.Millisecond,
+		FailureThreshold: 5,
+		Cooldown:         30 * time.Second,
+	}
+}
+
+// WithResilience wraps next with per-call timeouts, retries of transient
+// errors and a circuit breaker. All errors returned are of type *Error, but
+// for context.Canceled: a call cancelled by its caller is returned as is and
+// neither counted by the metrics nor by the circuit breaker.
+//
+// Calls which create resources in metal-stack are never retried here, since
+// a timed-out attempt may have been committed by metal-api. They are left to
+// the next reconciliation instead.
+//
+// metalgo.Driver can't be cancelled, so an attempt running into the timeout
+// keeps running in the background until the HTTP client gives up.
+func WithResilience(next Client, opts ResilienceOptions) Client {
+	return &resilientClient{
+		next: next,
+		opts: opts,
+		breaker: &circuitBreaker{
+			threshold: opts.FailureThreshold,
+			cooldown:  opts.Cooldown,
+		},
+	}
+}
+
+type resilientClient struct {
+	next    Client
+	opts    ResilienceOptions
+	breaker *circuitBreaker
+}
+
+func (c *resilientClient) NetworkAllocate(ctx context.Context, req *metalgo.NetworkAllocateRequest) (*metalgo.NetworkDetailResponse, error) {
+	resp, err := c.do(ctx, "NetworkAllocate", false, func(ctx context.Context) (interface{}, error) {
+		return c.next.NetworkAllocate(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*metalgo.NetworkDetailResponse), nil
+}
+
+func (c *resilientClient) NetworkFind(ctx context.Context, req *metalgo.NetworkFindRequest) (*metalgo.NetworkListResponse, error) {
+	resp, err := c.do(ctx, "NetworkFind", true, func(ctx context.Context) (interface{}, error) {
+		return c.next.NetworkFind(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*metalgo.NetworkListResponse), nil
+}
+
+func (c *resilientClient) NetworkFree(ctx context.Context, id string) (*metalgo.NetworkDetailResponse, error) {
+	resp, err := c.do(ctx, "NetworkFree", true, func(ctx context.Context) (interface{}, error) {
+		return c.next.NetworkFree(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*metalgo.NetworkDetailResponse), nil
+}
+
+func (c *resilientClient) NetworkGet(ctx context.Context, id string) (*metalgo.NetworkGetResponse, error) {
+	resp, err := c.do(ctx, "NetworkGet", true, func(ctx context.Context) (interface{}, error) {
+		return c.next.NetworkGet(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*metalgo.NetworkGetResponse), nil
+}
+
+func (c *resilientClient) IPAllocate(ctx context.Context, req *metalgo.IPAllocateRequest) (*metalgo.IPDetailResponse, error) {
+	resp, err := c.do(ctx, "IPAllocate", false, func(ctx context.Context) (interface{}, error) {
+		return c.next.IPAllocate(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*metalgo.IPDetailResponse), nil
+}
+
+func (c *resilientClient) IPFind(ctx context.Context, req *metalgo.IPFindRequest) (*metalgo.IPListResponse, error) {
+	resp, err := c.do(ctx, "IPFind", true, func(ctx context.Context) (interface{}, error) {
+		return c.next.IPFind(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*metalgo.IPListResponse), nil
+}
+
+func (c *resilientClient) IPFree(ctx context.Context, id string) (*metalgo.IPDetailResponse, error) {
+	resp, err := c.do(ctx, "IPFree", true, func(ctx context.Context) (interface{}, error) {
+		return c.next.IPFree(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*metalgo.IPDetailResponse), nil
+}
+
+func (c *resilientClient) IPGet(ctx context.Context, ip string) (*metalgo.IPDetailResponse, error) {
+	resp, err := c.do(ctx, "IPGet", true, func(ctx context.Context) (interface{}, error) {
+		return c.next.IPGet(ctx, ip)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*metalgo.IPDetailResponse), nil
+}
+
+func (c *resilientClient) FirewallCreate(ctx context.Context, req *metalgo.FirewallCreateRequest) (*metalgo.FirewallCreateResponse, error) {
+	resp, err := c.do(ctx, "FirewallCreate", false, func(ctx context.Context) (interface{}, error) {
+		return c.next.FirewallCreate(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*metalgo.FirewallCreateResponse), nil
+}
+
+func (c *resilientClient) MachineCreate(ctx context.Context, req *metalgo.MachineCreateRequest) (*metalgo.MachineCreateResponse, error) {
+	resp, err := c.do(ctx, "MachineCreate", false, func(ctx context.Context) (interface{}, error) {
+		return c.next.MachineCreate(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*metalgo.MachineCreateResponse), nil
+}
+
+func (c *resilientClient) MachineDelete(ctx context.Context, id string) (*metalgo.MachineDeleteResponse, error) {
+	resp, err := c.do(ctx, "MachineDelete", true, func(ctx context.Context) (interface{}, error) {
+		return c.next.MachineDelete(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*metalgo.MachineDeleteResponse), nil
+}
+
+func (c *resilientClient) MachineFind(ctx context.Context, req *metalgo.MachineFindRequest) (*metalgo.MachineListResponse, error) {
+	resp, err := c.do(ctx, "MachineFind", true, func(ctx context.Context) (interface{}, error) {
+		return c.next.MachineFind(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*metalgo.MachineListResponse), nil
+}
+
+func (c *resilientClient) MachineGet(ctx context.Context, id string) (*metalgo.MachineGetResponse, error) {
+	resp, err := c.do(ctx, "MachineGet", true, func(ctx context.Context) (interface{}, error) {
+		return c.next.MachineGet(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*metalgo.MachineGetResponse), nil
+}
+
+func (c *resilientClient) PartitionGet(ctx context.Context, id string) (*metalgo.PartitionGetResponse, error) {
+	resp, err := c.do(ctx, "PartitionGet", true, func(ctx context.Context) (interface{}, error) {
+		return c.next.PartitionGet(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*metalgo.PartitionGetResponse), nil
+}
+
+func (c *resilientClient) ProjectCreate(ctx context.Context, req mdv1.ProjectCreateRequest) (*metalgo.ProjectGetResponse, error) {
+	resp, err := c.do(ctx, "ProjectCreate", false, func(ctx context.Context) (interface{}, error) {
+		return c.next.ProjectCreate(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*metalgo.ProjectGetResponse), nil
+}
+
+func (c *resilientClient) ProjectFind(ctx context.Context, req mdv1.ProjectFindRequest) (*metalgo.ProjectListResponse, error) {
+	resp, err := c.do(ctx, "ProjectFind", true, func(ctx context.Context) (interface{}, error) {
+		return c.next.ProjectFind(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*metalgo.ProjectListResponse), nil
+}
+
+func (c *resilientClient) ProjectGet(ctx context.Context, id string) (*metalgo.ProjectGetResponse, error) {
+	resp, err := c.do(ctx, "ProjectGet", true, func(ctx context.Context) (interface{}, error) {
+		return c.next.ProjectGet(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*metalgo.ProjectGetResponse), nil
+}
+
+func (c *resilientClient) do(ctx context.Context, op string, idempotent bool, call func(context.Context) (interface{}, error)) (interface{}, error) {
+	start := time.Now()
+	defer func() { requestDuration.WithLabelValues(op).Observe(time.Since(start).Seconds()) }()
+
+	backoff := c.opts.Backoff
+	for attempt := 0; ; attempt++ {
+		if retryAfter, ok := c.breaker.allow(); !ok {
+			requestsTotal.WithLabelValues(op, string(Transient)).Inc()
+			return nil, &Error{Op: op, Class: Transient, Err: ErrCircuitOpen, RetryAfter: retryAfter}
+		}
+
+		resp, err := c.attempt(ctx, call)
+		if errors.Is(err, context.Canceled) {
+			// The caller gave up, which tells nothing about metal-api.
+			c.breaker.abandon()
+			return nil, err
+		}
+		if err == nil {
+			c.breaker.succeed()
+			requestsTotal.WithLabelValues(op, "success").Inc()
+			return resp, nil
+		}
+
+		class := classify(err)
+		if class == Transient {
+			c.breaker.fail()
+		} else {
+			// metal-api answered, so it is up.
+			c.breaker.succeed()
+		}
+		requestsTotal.WithLabelValues(op, string(class)).Inc()
+
+		if class != Transient || !idempotent || attempt >= c.opts.MaxRetries {
+			return nil, &Error{Op: op, Class: class, Err: err}
+		}
+
+		retriesTotal.WithLabelValues(op).Inc()
+		select {
+		case <-time.After(wait.Jitter(backoff, 1)):
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil, ctx.Err()
+			}
+			return nil, &Error{Op: op, Class: Transient, Err: ctx.Err()}
+		}
+		backoff *= 2
+	}
+}
+
+type result struct {
+	resp interface{}
+	err  error
+}
+
+func (c *resilientClient) attempt(ctx context.Context, call func(context.Context) (interface{}, error)) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+
+	done := make(chan result, 1)
+	go func() {
+		resp, err := call(ctx)
+		done <- result{resp: resp, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.resp, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+// circuitBreaker stops calls to metal-api after too many consecutive
+// transient failures. After the cool-down it lets a single trial call
+// through, which either closes or reopens it.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	trialing bool
+}
+
+func (b *circuitBreaker) allow() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if remaining := b.cooldown - time.Since(b.openedAt); remaining > 0 {
+			return remaining, false
+		}
+		b.setState(breakerHalfOpen)
+		b.trialing = true
+		return 0, true
+	case breakerHalfOpen:
+		if b.trialing {
+			return b.cooldown, false
+		}
+		b.trialing = true
+		return 0, true
+	default:
+		return 0, true
+	}
+}
+
+func (b *circuitBreaker) succeed() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.trialing = false
+	b.setState(breakerClosed)
+}
+
+func (b *circuitBreaker) fail() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.trialing = false
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+// abandon gives up the trial call allow let through, if any, without taking
+// it as a success or a failure.
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trialing = false
+}
+
+func (b *circuitBreaker) setState(s breakerState) {
+	b.state = s
+	circuitBreakerState.Set(float64(s))
+}
@@ -0,0 +1,191 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	metalgo "github.com/metal-stack/metal-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// flakyClient fails NetworkFind and FirewallCreate with errs in turn and
+// succeeds once they are used up.
+type flakyClient struct {
+	Client
+	errs  []error
+	calls int
+}
+
+func (c *flakyClient) next() error {
+	c.calls++
+	if len(c.errs) == 0 {
+		return nil
+	}
+	err := c.errs[0]
+	c.errs = c.errs[1:]
+	return err
+}
+
+func (c *flakyClient) NetworkFind(context.Context, *metalgo.NetworkFindRequest) (*metalgo.NetworkListResponse, error) {
+	if err := c.next(); err != nil {
+		return nil, err
+	}
+	return &metalgo.NetworkListResponse{}, nil
+}
+
+func (c *flakyClient) FirewallCreate(context.Context, *metalgo.FirewallCreateRequest) (*metalgo.FirewallCreateResponse, error) {
+	if err := c.next(); err != nil {
+		return nil, err
+	}
+	return &metalgo.FirewallCreateResponse{}, nil
+}
+
+func testOptions() ResilienceOptions {
+	return ResilienceOptions{
+		Timeout:          time.Second,
+		MaxRetries:       2,
+		Backoff:          time.Millisecond,
+		FailureThreshold: 3,
+		Cooldown:         time.Hour,
+	}
+}
+
+func TestClassify(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want ErrorClass
+	}{
+		{runtime.NewAPIError("findNetwork", nil, 404), NotFound},
+		{runtime.NewAPIError("allocateNetwork", nil, 409), Conflict},
+		{runtime.NewAPIError("allocateNetwork", nil, 503), Transient},
+		{runtime.NewAPIError("allocateNetwork", nil, 422), Permanent},
+		{errors.New("[POST /v1/network/allocate][409] allocateNetworkConflict"), Conflict},
+		{context.DeadlineExceeded, Transient},
+		{&Error{Op: "NetworkFree", Class: NotFound}, NotFound},
+	} {
+		if got := ClassOf(tt.err); got != tt.want {
+			t.Errorf("ClassOf(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestIdempotentCallsAreRetried(t *testing.T) {
+	flaky := &flakyClient{errs: []error{
+		runtime.NewAPIError("findNetworks", nil, 503),
+		runtime.NewAPIError("findNetworks", nil, 502),
+	}}
+	c := WithResilience(flaky, testOptions())
+
+	if _, err := c.NetworkFind(context.Background(), nil); err != nil {
+		t.Fatalf("expected success after retries, got %v", err)
+	}
+	if flaky.calls != 3 {
+		t.Errorf("expected 3 calls, got %d", flaky.calls)
+	}
+}
+
+func TestCreatesAreNotRetried(t *testing.T) {
+	flaky := &flakyClient{errs: []error{runtime.NewAPIError("allocateFirewall", nil, 503)}}
+	c := WithResilience(flaky, testOptions())
+
+	_, err := c.FirewallCreate(context.Background(), &metalgo.FirewallCreateRequest{})
+	if !IsTransient(err) {
+		t.Fatalf("expected transient error, got %v", err)
+	}
+	if flaky.calls != 1 {
+		t.Errorf("expected 1 call, got %d", flaky.calls)
+	}
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	var errs []error
+	for i := 0; i < 10; i++ {
+		errs = append(errs, runtime.NewAPIError("findNetworks", nil, 503))
+	}
+	flaky := &flakyClient{errs: errs}
+	c := WithResilience(flaky, testOptions())
+
+	// The first call fails 3 times in a row, which opens the breaker.
+	if _, err := c.NetworkFind(context.Background(), nil); !IsTransient(err) {
+		t.Fatalf("expected transient error, got %v", err)
+	}
+
+	_, err := c.NetworkFind(context.Background(), nil)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected open circuit, got %v", err)
+	}
+	var merr *Error
+	if !errors.As(err, &merr) || merr.RetryAfter <= 0 {
+		t.Errorf("expected RetryAfter to be set, got %+v", merr)
+	}
+	if flaky.calls != 3 {
+		t.Errorf("expected metal-api not to be called while the circuit is open, got %d calls", flaky.calls)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	opts := testOptions()
+	opts.Timeout = 10 * time.Millisecond
+	opts.MaxRetries = 0
+	c := WithResilience(&slowClient{delay: time.Second}, opts)
+
+	_, err := c.NetworkFind(context.Background(), nil)
+	if !errors.Is(err, context.DeadlineExceeded) || !IsTransient(err) {
+		t.Fatalf("expected transient deadline exceeded, got %v", err)
+	}
+}
+
+type slowClient struct {
+	Client
+	delay time.Duration
+}
+
+func (c *slowClient) NetworkFind(ctx context.Context, _ *metalgo.NetworkFindRequest) (*metalgo.NetworkListResponse, error) {
+	time.Sleep(c.delay)
+	return &metalgo.NetworkListResponse{}, nil
+}
+
+func TestCancellationIsNotCounted(t *testing.T) {
+	flaky := &flakyClient{errs: []error{context.Canceled, context.Canceled, context.Canceled, context.Canceled}}
+	c := WithResilience(flaky, testOptions())
+	before := testutil.ToFloat64(requestsTotal.WithLabelValues("NetworkFind", string(Permanent)))
+
+	for i := 0; i < 4; i++ {
+		_, err := c.NetworkFind(context.Background(), nil)
+		var merr *Error
+		if err != context.Canceled || errors.As(err, &merr) {
+			t.Fatalf("expected cancellation returned as is, got %v", err)
+		}
+	}
+	if flaky.calls != 4 {
+		t.Errorf("expected cancellations neither retried nor to open the circuit, got %d calls", flaky.calls)
+	}
+	if got := testutil.ToFloat64(requestsTotal.WithLabelValues("NetworkFind", string(Permanent))); got != before {
+		t.Errorf("expected cancellations not to be counted as errors, got %v more", got-before)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.NetworkFind(ctx, nil); !errors.Is(err, context.Canceled) || IsTransient(err) {
+		t.Errorf("expected cancellation returned as is, got %v", err)
+	}
+}
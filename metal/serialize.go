@@ -0,0 +1,184 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	mdv1 "github.com/metal-stack/masterdata-api/api/rest/v1"
+	metalgo "github.com/metal-stack/metal-go"
+)
+
+// WithSerialization returns a Client which makes the calls allocating
+// resources in the same metal-stack project and partition one after another,
+// i.e. network allocations and machine and firewall creations. metal-api can
+// race on concurrent allocations in the same project and partition, e.g. hand
+// out overlapping prefixes. Calls for other projects or partitions, and all
+// other calls, go ahead in parallel.
+//
+// A call waits at most until its context is done, then it fails without
+// calling metal-api: as transient once its deadline passed, with
+// context.Canceled as is once its caller cancelled it. The time spent waiting is recorded in
+// xcluster_metal_api_serialization_wait_seconds.
+func WithSerialization(next Client) Client {
+	return &serializedClient{
+		next:  next,
+		slots: map[string]*slot{},
+	}
+}
+
+type serializedClient struct {
+	next Client
+
+	// mu guards slots.
+	mu    sync.Mutex
+	slots map[string]*slot
+}
+
+// slot is taken by the call allocating in a project and partition.
+type slot struct {
+	taken chan struct{}
+	// users is the number of calls holding or waiting for the slot, which is
+	// dropped once there are none left.
+	users int
+}
+
+// acquire waits until the slot of project and partition is free and takes it.
+// The returned function releases it.
+func (c *serializedClient) acquire(ctx context.Context, op, project, partition string) (func(), error) {
+	key := project + "/" + partition
+	c.mu.Lock()
+	s, ok := c.slots[key]
+	if !ok {
+		s = &slot{taken: make(chan struct{}, 1)}
+		c.slots[key] = s
+	}
+	s.users++
+	c.mu.Unlock()
+
+	start := time.Now()
+	select {
+	case s.taken <- struct{}{}:
+	case <-ctx.Done():
+		c.leave(key, s)
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return nil, ctx.Err()
+		}
+		return nil, &Error{Op: op, Class: Transient, Err: ctx.Err()}
+	}
+	serializationWait.WithLabelValues(op).Observe(time.Since(start).Seconds())
+
+	return func() {
+		<-s.taken
+		c.leave(key, s)
+	}, nil
+}
+
+func (c *serializedClient) leave(key string, s *slot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s.users--
+	if s.users == 0 {
+		delete(c.slots, key)
+	}
+}
+
+func (c *serializedClient) NetworkAllocate(ctx context.Context, req *metalgo.NetworkAllocateRequest) (*metalgo.NetworkDetailResponse, error) {
+	release, err := c.acquire(ctx, "NetworkAllocate", req.ProjectID, req.PartitionID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.next.NetworkAllocate(ctx, req)
+}
+
+func (c *serializedClient) NetworkFind(ctx context.Context, req *metalgo.NetworkFindRequest) (*metalgo.NetworkListResponse, error) {
+	return c.next.NetworkFind(ctx, req)
+}
+
+func (c *serializedClient) NetworkFree(ctx context.Context, id string) (*metalgo.NetworkDetailResponse, error) {
+	return c.next.NetworkFree(ctx, id)
+}
+
+func (c *serializedClient) NetworkGet(ctx context.Context, id string) (*metalgo.NetworkGetResponse, error) {
+	return c.next.NetworkGet(ctx, id)
+}
+
+func (c *serializedClient) IPAllocate(ctx context.Context, req *metalgo.IPAllocateRequest) (*metalgo.IPDetailResponse, error) {
+	return c.next.IPAllocate(ctx, req)
+}
+
+func (c *serializedClient) IPFind(ctx context.Context, req *metalgo.IPFindRequest) (*metalgo.IPListResponse, error) {
+	return c.next.IPFind(ctx, req)
+}
+
+func (c *serializedClient) IPFree(ctx context.Context, id string) (*metalgo.IPDetailResponse, error) {
+	return c.next.IPFree(ctx, id)
+}
+
+func (c *serializedClient) IPGet(ctx context.Context, ip string) (*metalgo.IPDetailResponse, error) {
+	return c.next.IPGet(ctx, ip)
+}
+
+func (c *serializedClient) FirewallCreate(ctx context.Context, req *metalgo.FirewallCreateRequest) (*metalgo.FirewallCreateResponse, error) {
+	release, err := c.acquire(ctx, "FirewallCreate", req.Project, req.Partition)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.next.FirewallCreate(ctx, req)
+}
+
+func (c *serializedClient) MachineCreate(ctx context.Context, req *metalgo.MachineCreateRequest) (*metalgo.MachineCreateResponse, error) {
+	release, err := c.acquire(ctx, "MachineCreate", req.Project, req.Partition)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.next.MachineCreate(ctx, req)
+}
+
+func (c *serializedClient) MachineDelete(ctx context.Context, id string) (*metalgo.MachineDeleteResponse, error) {
+	return c.next.MachineDelete(ctx, id)
+}
+
+func (c *serializedClient) MachineFind(ctx context.Context, req *metalgo.MachineFindRequest) (*metalgo.MachineListResponse, error) {
+	return c.next.MachineFind(ctx, req)
+}
+
+func (c *serializedClient) MachineGet(ctx context.Context, id string) (*metalgo.MachineGetResponse, error) {
+	return c.next.MachineGet(ctx, id)
+}
+
+func (c *serializedClient) PartitionGet(ctx context.Context, id string) (*metalgo.PartitionGetResponse, error) {
+	return c.next.PartitionGet(ctx, id)
+}
+
+func (c *serializedClient) ProjectCreate(ctx context.Context, req mdv1.ProjectCreateRequest) (*metalgo.ProjectGetResponse, error) {
+	return c.next.ProjectCreate(ctx, req)
+}
+
+func (c *serializedClient) ProjectFind(ctx context.Context, req mdv1.ProjectFindRequest) (*metalgo.ProjectListResponse, error) {
+	return c.next.ProjectFind(ctx, req)
+}
+
+func (c *serializedClient) ProjectGet(ctx context.Context, id string) (*metalgo.ProjectGetResponse, error) {
+	return c.next.ProjectGet(ctx, id)
+}
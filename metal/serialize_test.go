@@ -0,0 +1,86 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metalgo "github.com/metal-stack/metal-go"
+)
+
+// blockingClient announces every NetworkAllocate by its project on started
+// and blocks it until release is closed.
+type blockingClient struct {
+	Client
+	started chan string
+	release chan struct{}
+}
+
+func (c *blockingClient) NetworkAllocate(ctx context.Context, req *metalgo.NetworkAllocateRequest) (*metalgo.NetworkDetailResponse, error) {
+	c.started <- req.ProjectID
+	<-c.release
+	return &metalgo.NetworkDetailResponse{}, nil
+}
+
+func TestSerialization(t *testing.T) {
+	next := &blockingClient{started: make(chan string, 3), release: make(chan struct{})}
+	c := WithSerialization(next)
+	allocate := func(ctx context.Context, project string) error {
+		_, err := c.NetworkAllocate(ctx, &metalgo.NetworkAllocateRequest{ProjectID: project, PartitionID: "vagrant"})
+		return err
+	}
+
+	done := make(chan error, 3)
+	go func() { done <- allocate(context.Background(), "a") }()
+	if got := <-next.started; got != "a" {
+		t.Fatalf("got %s, want the first call to start", got)
+	}
+	go func() { done <- allocate(context.Background(), "b") }()
+	if got := <-next.started; got != "b" {
+		t.Fatalf("got %s, want the call of another project to start right away", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := allocate(ctx, "a"); !IsTransient(err) {
+		t.Errorf("got %v, want a transient error once waiting timed out", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := allocate(ctx, "a"); err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled once waiting was cancelled", err)
+	}
+
+	go func() { done <- allocate(context.Background(), "a") }()
+	select {
+	case <-next.started:
+		t.Fatal("second call of the same project started while the first one runs")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(next.release)
+	if got := <-next.started; got != "a" {
+		t.Errorf("got %s, want the second call of project a to start", got)
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
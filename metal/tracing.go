@@ -0,0 +1,149 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metal
+
+import (
+	"context"
+
+	mdv1 "github.com/metal-stack/masterdata-api/api/rest/v1"
+	metalgo "github.com/metal-stack/metal-go"
+
+	"github.com/LimKianAn/xcluster/tracing"
+)
+
+// WithTracing wraps next so that every metal-api call gets its own span.
+func WithTracing(next Client) Client {
+	return &tracingClient{next: next}
+}
+
+type tracingClient struct {
+	next Client
+}
+
+func (c *tracingClient) NetworkAllocate(ctx context.Context, req *metalgo.NetworkAllocateRequest) (resp *metalgo.NetworkDetailResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "metal.NetworkAllocate",
+		tracing.String("metal.partition", req.PartitionID),
+		tracing.String("metal.project", req.ProjectID),
+	)
+	defer func() { span.RecordError(err); span.End() }()
+	return c.next.NetworkAllocate(ctx, req)
+}
+
+func (c *tracingClient) NetworkFind(ctx context.Context, req *metalgo.NetworkFindRequest) (resp *metalgo.NetworkListResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "metal.NetworkFind")
+	defer func() { span.RecordError(err); span.End() }()
+	return c.next.NetworkFind(ctx, req)
+}
+
+func (c *tracingClient) NetworkFree(ctx context.Context, id string) (resp *metalgo.NetworkDetailResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "metal.NetworkFree", tracing.String("metal.network", id))
+	defer func() { span.RecordError(err); span.End() }()
+	return c.next.NetworkFree(ctx, id)
+}
+
+func (c *tracingClient) NetworkGet(ctx context.Context, id string) (resp *metalgo.NetworkGetResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "metal.NetworkGet", tracing.String("metal.network", id))
+	defer func() { span.RecordError(err); span.End() }()
+	return c.next.NetworkGet(ctx, id)
+}
+
+func (c *tracingClient) IPAllocate(ctx context.Context, req *metalgo.IPAllocateRequest) (resp *metalgo.IPDetailResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "metal.IPAllocate",
+		tracing.String("metal.network", req.Networkid),
+		tracing.String("metal.project", req.Projectid),
+	)
+	defer func() { span.RecordError(err); span.End() }()
+	return c.next.IPAllocate(ctx, req)
+}
+
+func (c *tracingClient) IPFind(ctx context.Context, req *metalgo.IPFindRequest) (resp *metalgo.IPListResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "metal.IPFind")
+	defer func() { span.RecordError(err); span.End() }()
+	return c.next.IPFind(ctx, req)
+}
+
+func (c *tracingClient) IPFree(ctx context.Context, id string) (resp *metalgo.IPDetailResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "metal.IPFree", tracing.String("metal.ip", id))
+	defer func() { span.RecordError(err); span.End() }()
+	return c.next.IPFree(ctx, id)
+}
+
+func (c *tracingClient) IPGet(ctx context.Context, ip string) (resp *metalgo.IPDetailResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "metal.IPGet", tracing.String("metal.ip", ip))
+	defer func() { span.RecordError(err); span.End() }()
+	return c.next.IPGet(ctx, ip)
+}
+
+func (c *tracingClient) FirewallCreate(ctx context.Context, req *metalgo.FirewallCreateRequest) (resp *metalgo.FirewallCreateResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "metal.FirewallCreate",
+		tracing.String("metal.partition", req.Partition),
+		tracing.String("metal.project", req.Project),
+	)
+	defer func() { span.RecordError(err); span.End() }()
+	return c.next.FirewallCreate(ctx, req)
+}
+
+func (c *tracingClient) MachineCreate(ctx context.Context, req *metalgo.MachineCreateRequest) (resp *metalgo.MachineCreateResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "metal.MachineCreate",
+		tracing.String("metal.partition", req.Partition),
+		tracing.String("metal.project", req.Project),
+	)
+	defer func() { span.RecordError(err); span.End() }()
+	return c.next.MachineCreate(ctx, req)
+}
+
+func (c *tracingClient) MachineDelete(ctx context.Context, id string) (resp *metalgo.MachineDeleteResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "metal.MachineDelete", tracing.String("metal.machine", id))
+	defer func() { span.RecordError(err); span.End() }()
+	return c.next.MachineDelete(ctx, id)
+}
+
+func (c *tracingClient) MachineFind(ctx context.Context, req *metalgo.MachineFindRequest) (resp *metalgo.MachineListResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "metal.MachineFind")
+	defer func() { span.RecordError(err); span.End() }()
+	return c.next.MachineFind(ctx, req)
+}
+
+func (c *tracingClient) MachineGet(ctx context.Context, id string) (resp *metalgo.MachineGetResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "metal.MachineGet", tracing.String("metal.machine", id))
+	defer func() { span.RecordError(err); span.End() }()
+	return c.next.MachineGet(ctx, id)
+}
+
+func (c *tracingClient) PartitionGet(ctx context.Context, id string) (resp *metalgo.PartitionGetResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "metal.PartitionGet", tracing.String("metal.partition", id))
+	defer func() { span.RecordError(err); span.End() }()
+	return c.next.PartitionGet(ctx, id)
+}
+
+func (c *tracingClient) ProjectCreate(ctx context.Context, req mdv1.ProjectCreateRequest) (resp *metalgo.ProjectGetResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "metal.ProjectCreate", tracing.String("metal.tenant", req.TenantId))
+	defer func() { span.RecordError(err); span.End() }()
+	return c.next.ProjectCreate(ctx, req)
+}
+
+func (c *tracingClient) ProjectFind(ctx context.Context, req mdv1.ProjectFindRequest) (resp *metalgo.ProjectListResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "metal.ProjectFind")
+	defer func() { span.RecordError(err); span.End() }()
+	return c.next.ProjectFind(ctx, req)
+}
+
+func (c *tracingClient) ProjectGet(ctx context.Context, id string) (resp *metalgo.ProjectGetResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "metal.ProjectGet", tracing.String("metal.project", id))
+	defer func() { span.RecordError(err); span.End() }()
+	return c.next.ProjectGet(ctx, id)
+}
@@ -0,0 +1,97 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NewClient wraps c so that every write to the api-server gets its own span.
+// Reads are served from the cache and are not traced.
+func NewClient(c client.Client) client.Client {
+	return &tracedClient{Client: c}
+}
+
+type tracedClient struct {
+	client.Client
+}
+
+func (c *tracedClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) (err error) {
+	ctx, span := startWrite(ctx, "Create", obj)
+	defer func() { span.RecordError(err); span.End() }()
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *tracedClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) (err error) {
+	ctx, span := startWrite(ctx, "Delete", obj)
+	defer func() { span.RecordError(err); span.End() }()
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *tracedClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) (err error) {
+	ctx, span := startWrite(ctx, "Update", obj)
+	defer func() { span.RecordError(err); span.End() }()
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *tracedClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) (err error) {
+	ctx, span := startWrite(ctx, "Patch", obj)
+	defer func() { span.RecordError(err); span.End() }()
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *tracedClient) DeleteAllOf(ctx context.Context, obj runtime.Object, opts ...client.DeleteAllOfOption) (err error) {
+	ctx, span := startWrite(ctx, "DeleteAllOf", obj)
+	defer func() { span.RecordError(err); span.End() }()
+	return c.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c *tracedClient) Status() client.StatusWriter {
+	return &tracedStatusWriter{StatusWriter: c.Client.Status()}
+}
+
+type tracedStatusWriter struct {
+	client.StatusWriter
+}
+
+func (w *tracedStatusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) (err error) {
+	ctx, span := startWrite(ctx, "Status.Update", obj)
+	defer func() { span.RecordError(err); span.End() }()
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func (w *tracedStatusWriter) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) (err error) {
+	ctx, span := startWrite(ctx, "Status.Patch", obj)
+	defer func() { span.RecordError(err); span.End() }()
+	return w.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
+
+func startWrite(ctx context.Context, verb string, obj runtime.Object) (context.Context, *Span) {
+	attrs := []Attribute{String("k8s.kind", fmt.Sprintf("%T", obj))}
+	if m, err := meta.Accessor(obj); err == nil {
+		attrs = append(attrs,
+			String("k8s.namespace", m.GetNamespace()),
+			String("k8s.name", m.GetName()),
+		)
+	}
+	return StartClient(ctx, "k8s."+verb, attrs...)
+}
@@ -0,0 +1,110 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	defaultBatchSize     = 512
+	defaultFlushInterval = 5 * time.Second
+)
+
+// Provider collects ended spans and hands them to the exporter in batches.
+type Provider struct {
+	exporter Exporter
+	log      logr.Logger
+
+	mu    sync.Mutex
+	queue []SpanData
+
+	full chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewProvider creates a Provider exporting spans via exporter and starts its
+// background flushing. Export failures are logged to log.
+func NewProvider(exporter Exporter, log logr.Logger) *Provider {
+	p := &Provider{
+		exporter: exporter,
+		log:      log,
+		full:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *Provider) enqueue(data SpanData) {
+	p.mu.Lock()
+	p.queue = append(p.queue, data)
+	n := len(p.queue)
+	p.mu.Unlock()
+
+	if n >= defaultBatchSize {
+		select {
+		case p.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (p *Provider) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(defaultFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.full:
+		case <-p.stop:
+			return
+		}
+		if err := p.ForceFlush(context.Background()); err != nil {
+			p.log.Error(err, "failed to export spans")
+		}
+	}
+}
+
+// ForceFlush exports all spans ended so far.
+func (p *Provider) ForceFlush(ctx context.Context) error {
+	p.mu.Lock()
+	batch := p.queue
+	p.queue = nil
+	p.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return p.exporter.ExportSpans(ctx, batch)
+}
+
+// Shutdown stops the background flushing and exports the remaining spans.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	close(p.stop)
+	<-p.done
+	return p.ForceFlush(ctx)
+}
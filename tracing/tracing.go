@@ -0,0 +1,231 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing records spans of reconcile loops, metal-api calls and
+// Kubernetes writes and exports them in the OpenTelemetry (OTLP) format.
+// Tracing is off until a Provider is installed by SetProvider. Until then
+// Start hands out nil spans whose methods do nothing.
+//
+// The package stands in for the OpenTelemetry SDK rather than using it. The
+// SDK isn't among the dependencies of this module, and the manager needs
+// little of it: every trace starts and ends within the manager.
+// metalgo.Driver takes no context and offers no way to set request headers,
+// so a W3C traceparent couldn't be sent to metal-api, and no request carrying
+// one reaches the manager. Hence there is no context propagation, only spans
+// exported as OTLP/JSON over HTTP, which any OpenTelemetry collector accepts.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// TraceID identifies a trace, i.e. a tree of spans.
+type TraceID [16]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// IsValid reports whether the span ID is set.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanKind follows the OTLP span kinds.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindClient   SpanKind = 3
+)
+
+// Attribute is a key-value pair attached to a span.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// String creates an Attribute.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// SpanData is the immutable snapshot of an ended span handed to exporters.
+type SpanData struct {
+	TraceID       TraceID
+	SpanID        SpanID
+	ParentSpanID  SpanID
+	Name          string
+	Kind          SpanKind
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	Failed        bool
+	StatusMessage string
+}
+
+// Span is an operation in progress. A nil *Span is valid and records nothing.
+type Span struct {
+	mu       sync.Mutex
+	data     SpanData
+	ended    bool
+	provider *Provider
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// RecordError marks the span as failed if err is not nil.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Failed = true
+	s.data.StatusMessage = err.Error()
+}
+
+// End finishes the span and queues it for export. Only the first call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	s.provider.enqueue(data)
+}
+
+// TraceID returns the ID of the trace the span belongs to.
+func (s *Span) TraceID() TraceID {
+	if s == nil {
+		return TraceID{}
+	}
+	return s.data.TraceID
+}
+
+// SpanID returns the ID of the span.
+func (s *Span) SpanID() SpanID {
+	if s == nil {
+		return SpanID{}
+	}
+	return s.data.SpanID
+}
+
+type spanKey struct{}
+
+// SpanFromContext returns the current span, or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start starts an internal span as a child of the span in ctx, if any.
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	return start(ctx, SpanKindInternal, name, attrs)
+}
+
+// StartClient starts a span for a call to a remote service.
+func StartClient(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	return start(ctx, SpanKindClient, name, attrs)
+}
+
+func start(ctx context.Context, kind SpanKind, name string, attrs []Attribute) (context.Context, *Span) {
+	p := getProvider()
+	if p == nil {
+		return ctx, nil
+	}
+
+	s := &Span{
+		provider: p,
+		data: SpanData{
+			SpanID:     newSpanID(),
+			Name:       name,
+			Kind:       kind,
+			Start:      time.Now(),
+			Attributes: attrs,
+		},
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		s.data.TraceID = parent.data.TraceID
+		s.data.ParentSpanID = parent.data.SpanID
+	} else {
+		s.data.TraceID = newTraceID()
+	}
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Logger adds the trace and span IDs of the span in ctx to log, so that log
+// lines can be correlated with traces.
+func Logger(ctx context.Context, log logr.Logger) logr.Logger {
+	s := SpanFromContext(ctx)
+	if s == nil {
+		return log
+	}
+	return log.WithValues("traceID", s.TraceID().String(), "spanID", s.SpanID().String())
+}
+
+var (
+	globalMu       sync.RWMutex
+	globalProvider *Provider
+)
+
+// SetProvider installs p as the provider of all spans. Passing nil turns
+// tracing off.
+func SetProvider(p *Provider) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	globalProvider = p
+}
+
+func getProvider() *Provider {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	return globalProvider
+}
+
+func newTraceID() (id TraceID) {
+	_, _ = rand.Read(id[:])
+	return
+}
+
+func newSpanID() (id SpanID) {
+	_, _ = rand.Read(id[:])
+	return
+}
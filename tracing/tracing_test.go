@@ -0,0 +1,125 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func setupInMemory(t *testing.T) (*Provider, *InMemoryExporter) {
+	exporter := &InMemoryExporter{}
+	tp := NewProvider(exporter, zap.New(zap.UseDevMode(true)))
+	SetProvider(tp)
+	t.Cleanup(func() {
+		SetProvider(nil)
+		_ = tp.Shutdown(context.Background())
+	})
+	return tp, exporter
+}
+
+func TestChildSpansShareTheTrace(t *testing.T) {
+	tp, exporter := setupInMemory(t)
+
+	ctx, parent := Start(context.Background(), "XCluster.Reconcile")
+	_, child := StartClient(ctx, "metal.NetworkAllocate", String("metal.partition", "vagrant"))
+	child.RecordError(errors.New("boom"))
+	child.End()
+	parent.End()
+
+	if err := tp.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	c, p := spans[0], spans[1]
+	if c.TraceID != p.TraceID {
+		t.Errorf("child trace %s differs from parent trace %s", c.TraceID, p.TraceID)
+	}
+	if c.ParentSpanID != p.SpanID {
+		t.Errorf("child parent %s is not the parent span %s", c.ParentSpanID, p.SpanID)
+	}
+	if p.ParentSpanID.IsValid() {
+		t.Errorf("root span has parent %s", p.ParentSpanID)
+	}
+	if !c.Failed || c.StatusMessage != "boom" {
+		t.Errorf("child span did not record the error: %+v", c)
+	}
+	if c.Kind != SpanKindClient {
+		t.Errorf("expected client span, got kind %d", c.Kind)
+	}
+}
+
+func TestSpansAreNoopWithoutProvider(t *testing.T) {
+	SetProvider(nil)
+
+	ctx, span := Start(context.Background(), "XFirewall.Reconcile")
+	if span != nil {
+		t.Fatalf("expected nil span, got %+v", span)
+	}
+	if SpanFromContext(ctx) != nil {
+		t.Fatal("expected no span in context")
+	}
+	// Calls on nil spans must not panic.
+	span.SetAttributes(String("k", "v"))
+	span.RecordError(errors.New("boom"))
+	span.End()
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var got otlpRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	tp := NewProvider(NewOTLPExporter(srv.URL, "xcluster-test"), zap.New(zap.UseDevMode(true)))
+	SetProvider(tp)
+	defer SetProvider(nil)
+
+	_, span := Start(context.Background(), "XCluster.Reconcile")
+	span.End()
+
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request %+v", got)
+	}
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 1 || spans[0].Name != "XCluster.Reconcile" || spans[0].TraceID != span.TraceID().String() {
+		t.Errorf("unexpected spans %+v", spans)
+	}
+	if attrs := got.ResourceSpans[0].Resource.Attributes; attrs[0].Value.StringValue != "xcluster-test" {
+		t.Errorf("unexpected resource attributes %+v", attrs)
+	}
+}